@@ -3,47 +3,203 @@ package main
 import (
 	"encoding/base64"
 	"flag"
+	"fmt"
 	"os"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/ldclabs/cose/iana"
 	"github.com/ldclabs/cose/key"
 	"github.com/ldclabs/cose/key/aesgcm"
+	"github.com/ldclabs/cose/key/ecdsa"
+	"github.com/ldclabs/cose/key/ed25519"
 	"github.com/ldclabs/cose/key/hmac"
-)
 
-var kind = flag.String("kind", "state", "generate key for kind")
-var out = flag.String("out", "./keys/out.key", "write key to a file")
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
 
+// Usage:
+//
+//	keys -kind hmac -out ./keys/hmac.key
+//	keys add -ring ./keys/hmac.key -key ./keys/new.key [-activate]
+//	keys rotate -ring ./keys/hmac.key -kind hmac
+//	keys retire -ring ./keys/hmac.key -kid <base64url kid>
+//
+// The service reloads the key rings on SIGHUP.
 func main() {
-	flag.Parse()
-
 	var err error
-	var k key.Key
-	var data []byte
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "add":
+			err = addKey(os.Args[2:])
+		case "rotate":
+			err = rotateKey(os.Args[2:])
+		case "retire":
+			err = retireKey(os.Args[2:])
+		default:
+			err = generate(os.Args[1:])
+		}
+	} else {
+		err = generate(nil)
+	}
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func generate(args []string) error {
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	kind := fs.String("kind", "state", "generate key for kind")
+	out := fs.String("out", "./keys/out.key", "write key to a file, the public key of signing key is written to the file with .pub suffix")
+	fs.Parse(args)
+
+	k, err := generateKey(*kind)
+	if err != nil {
+		return err
+	}
+	data, err := encodeKey(k)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(*out, data, 0644); err != nil {
+		return err
+	}
+
+	if *kind == "ed25519" || *kind == "es256" {
+		pk, err := util.ToPublicKey(k)
+		if err != nil {
+			return err
+		}
+		if data, err = encodeKey(pk); err != nil {
+			return err
+		}
+		return os.WriteFile(*out+".pub", data, 0644)
+	}
+	return nil
+}
+
+// addKey adds a key file to the key ring.
+func addKey(args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	ringPath := fs.String("ring", "", "key ring file, a single key file is upgraded to a key ring")
+	keyPath := fs.String("key", "", "key file to add")
+	activate := fs.Bool("activate", false, "make the key the active key")
+	fs.Parse(args)
+
+	ring, err := readRing(*ringPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(*keyPath)
+	if err != nil {
+		return err
+	}
+	kr, err := util.ParseKeyRing(data)
+	if err != nil {
+		return err
+	}
 
-	switch *kind {
+	k := kr.ActiveKey()
+	if err = ring.Add(k); err != nil {
+		return err
+	}
+	if *activate {
+		if err = ring.Activate(k.Kid()); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("added kid %s\n", base64.RawURLEncoding.EncodeToString(k.Kid()))
+	return writeRing(*ringPath, ring)
+}
+
+// rotateKey generates a new key and makes it the active key, the previous
+// keys are still usable for verification until retired.
+func rotateKey(args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	ringPath := fs.String("ring", "", "key ring file, a single key file is upgraded to a key ring")
+	kind := fs.String("kind", "", "kind of the new key: hmac, aesgcm, ed25519 or es256")
+	fs.Parse(args)
+
+	ring, err := readRing(*ringPath)
+	if err != nil {
+		return err
+	}
+	k, err := generateKey(*kind)
+	if err != nil {
+		return err
+	}
+	if err = ring.Add(k); err != nil {
+		return err
+	}
+	if err = ring.Activate(k.Kid()); err != nil {
+		return err
+	}
+	fmt.Printf("activated kid %s\n", base64.RawURLEncoding.EncodeToString(k.Kid()))
+	return writeRing(*ringPath, ring)
+}
+
+func retireKey(args []string) error {
+	fs := flag.NewFlagSet("retire", flag.ExitOnError)
+	ringPath := fs.String("ring", "", "key ring file")
+	kid := fs.String("kid", "", "base64url encoded kid of the key to retire")
+	fs.Parse(args)
+
+	ring, err := readRing(*ringPath)
+	if err != nil {
+		return err
+	}
+	id, err := base64.RawURLEncoding.DecodeString(*kid)
+	if err != nil {
+		return err
+	}
+	if err = ring.Retire(id); err != nil {
+		return err
+	}
+	fmt.Printf("retired kid %s\n", *kid)
+	return writeRing(*ringPath, ring)
+}
+
+func generateKey(kind string) (key.Key, error) {
+	switch kind {
 	case "hmac":
-		k, err = hmac.GenerateKey(iana.AlgorithmHMAC_256_64)
+		return hmac.GenerateKey(iana.AlgorithmHMAC_256_64)
 	case "aesgcm":
-		k, err = aesgcm.GenerateKey(iana.AlgorithmA256GCM)
-	default:
-		panic("unsupported kind")
+		return aesgcm.GenerateKey(iana.AlgorithmA256GCM)
+	case "ed25519":
+		return ed25519.GenerateKey()
+	case "es256":
+		return ecdsa.GenerateKey(iana.AlgorithmES256)
 	}
+	return nil, fmt.Errorf("unsupported kind %q", kind)
+}
 
-	if err == nil {
-		// data, err = k.MarshalCBOR()
-		data, err = cbor.Marshal(cbor.Tag{
-			Number:  55799, // self described CBOR Tag
-			Content: k,
-		})
+func readRing(filePath string) (*util.KeyRing, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
 	}
+	return util.ParseKeyRing(data)
+}
 
-	if err == nil {
-		err = os.WriteFile(*out, []byte(base64.RawURLEncoding.EncodeToString(data)), 0644)
+func writeRing(filePath string, ring *util.KeyRing) error {
+	if err := ring.Validate(); err != nil {
+		return err
+	}
+	data, err := ring.Marshal()
+	if err != nil {
+		return err
 	}
+	return os.WriteFile(filePath, data, 0644)
+}
 
+func encodeKey(k key.Key) ([]byte, error) {
+	// data, err = k.MarshalCBOR()
+	data, err := cbor.Marshal(cbor.Tag{
+		Number:  55799, // self described CBOR Tag
+		Content: k,
+	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return []byte(base64.RawURLEncoding.EncodeToString(data)), nil
 }
@@ -14,6 +14,7 @@ import (
 
 var help = flag.Bool("help", false, "show help info")
 var version = flag.Bool("version", false, "show version info")
+var checkConfig = flag.Bool("check-config", false, "validate the config and exit")
 
 func main() {
 	flag.Parse()
@@ -23,6 +24,15 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *checkConfig {
+		if conf.ConfigErr != nil {
+			fmt.Fprintln(os.Stderr, conf.ConfigErr)
+			os.Exit(1)
+		}
+		fmt.Printf("config %s is valid\n", conf.Config.Version)
+		os.Exit(0)
+	}
+
 	app := api.NewApp()
 	host := "http://" + conf.Config.Server.Addr
 	logging.Infof("%s@%s start on %s %s", conf.AppName, conf.AppVersion, conf.Config.Env, host)
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/bll"
+	"github.com/yiwen-ai/yiwen-api/src/conf"
+	"github.com/yiwen-ai/yiwen-api/src/middleware"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+// Admin APIs are only for the admins in config.
+type Admin struct {
+	blls *bll.Blls
+}
+
+func (a *Admin) checkAdmin(ctx *gear.Context) error {
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if !util.SliceHas(conf.Current().Admins, sess.UserID) {
+		return gear.ErrForbidden.WithMsg("no permission")
+	}
+	return nil
+}
+
+// GetConfig returns the active config with the secrets redacted.
+func (a *Admin) GetConfig(ctx *gear.Context) error {
+	if err := a.checkAdmin(ctx); err != nil {
+		return err
+	}
+
+	cfg, err := conf.Current().Redacted()
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.OkSend(bll.SuccessResponse[map[string]any]{Result: cfg})
+}
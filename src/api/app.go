@@ -43,6 +43,10 @@ func NewApp() *gear.App {
 		h.Set("x-auth-user", util.JARVIS.String())
 		h.Set("x-auth-app", util.JARVIS.String())
 		ctx = gear.CtxWith[util.CtxHeader](ctx, util.Ptr(util.CtxHeader(h)))
+		if err := blls.InitApp(ctx, app); err != nil {
+			return err
+		}
+
 		if err := blls.Jarvis.InitApp(ctx, app); err != nil {
 			return err
 		}
@@ -55,6 +59,14 @@ func NewApp() *gear.App {
 			return err
 		}
 
+		if err := blls.Statistic.InitApp(ctx, app); err != nil {
+			return err
+		}
+
+		if err := blls.Renewal.InitApp(ctx, app); err != nil {
+			return err
+		}
+
 		return nil
 	})
 
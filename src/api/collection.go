@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -39,13 +40,7 @@ func (a *Collection) Get(ctx *gear.Context) error {
 		return gear.ErrInternalServerError.From(err)
 	}
 	if output.Subscription != nil {
-		subtoken, err := util.EncodeMac0(a.blls.MACer, SubscriptionToken{
-			Kind:     2,
-			ExpireAt: output.Subscription.ExpireAt,
-			UID:      output.Subscription.UID,
-			CID:      output.Subscription.CID,
-			GID:      output.Subscription.GID,
-		}, []byte("SubscriptionToken"))
+		subtoken, err := encodeSubToken(a.blls, 2, output.Subscription)
 		if err == nil {
 			output.SubToken = &subtoken
 		}
@@ -81,13 +76,7 @@ func (a *Collection) ListByChild(ctx *gear.Context) error {
 	}
 	for i := range output.Result {
 		if s := output.Result[i].Subscription; s != nil {
-			subtoken, err := util.EncodeMac0(a.blls.MACer, SubscriptionToken{
-				Kind:     2,
-				ExpireAt: s.ExpireAt,
-				UID:      s.UID,
-				CID:      s.CID,
-				GID:      s.GID,
-			}, []byte("SubscriptionToken"))
+			subtoken, err := encodeSubToken(a.blls, 2, s)
 			if err == nil {
 				output.Result[i].SubToken = &subtoken
 			}
@@ -422,7 +411,11 @@ func (a *Collection) TranslateInfo(ctx *gear.Context) error {
 
 	model := bll.DefaultModel
 	if input.Model != nil {
-		model = bll.GetAIModel(*input.Model)
+		md, err := bll.GetAIModel(*input.Model)
+		if err != nil {
+			return err
+		}
+		model = md
 	}
 
 	languages := strings.Join(input.Languages, ",")
@@ -453,6 +446,12 @@ func (a *Collection) TranslateInfo(ctx *gear.Context) error {
 		return gear.ErrPaymentRequired.WithMsg("insufficient balance")
 	}
 
+	for _, lang := range input.Languages {
+		if err := model.Allow(wallet.Level, lang); err != nil {
+			return err
+		}
+	}
+
 	teContents := srcMsg.ToTEContents()
 	teData, err := cbor.Marshal(teContents)
 	if err != nil {
@@ -469,8 +468,12 @@ func (a *Collection) TranslateInfo(ctx *gear.Context) error {
 			tokens, util.MAX_TOKENS)
 	}
 
-	tokens := a.blls.Jarvis.EstimateTranslatingTokens(trans, *msg.Language, input.Languages[0])
-	estimate_cost := model.CostWEN(tokens) * int64(len(input.Languages))
+	estimates := make(map[string]*bll.TokensEstimate, len(input.Languages))
+	estimate_cost := int64(0)
+	for _, lang := range input.Languages {
+		estimates[lang] = a.blls.Estimator.Estimate(ctx, &model, trans, *msg.Language, lang)
+		estimate_cost += model.CostWEN(estimates[lang].Tokens)
+	}
 	if b := wallet.Balance(); b < estimate_cost {
 		return gear.ErrPaymentRequired.WithMsgf("insufficient balance, expected %d, got %d", estimate_cost, b)
 	}
@@ -482,6 +485,24 @@ func (a *Collection) TranslateInfo(ctx *gear.Context) error {
 		return gear.ErrLocked.From(err)
 	}
 
+	estimate_tokens := uint32(0)
+	for _, e := range estimates {
+		estimate_tokens += e.Tokens
+	}
+	hold, err := a.blls.Walletbase.Hold(ctx, sess.UserID, &bll.SpendPayload{
+		GID:      input.GID,
+		ID:       &msg.ID,
+		Action:   bll.LogActionMessageUpdate,
+		Language: languages,
+		Version:  input.Version,
+		Model:    model.ID,
+		Tokens:   estimate_tokens,
+	})
+	if err != nil {
+		locker.Release(gctx)
+		return gear.ErrInternalServerError.From(err)
+	}
+
 	payload := &bll.LogMessage{
 		ID:        msg.ID,
 		AttachTo:  *msg.AttachTo,
@@ -491,6 +512,7 @@ func (a *Collection) TranslateInfo(ctx *gear.Context) error {
 
 	log, err := a.blls.Logbase.Log(ctx, bll.LogActionMessageUpdate, 0, input.GID, payload)
 	if err != nil {
+		_ = a.blls.Walletbase.Release(gctx, hold)
 		locker.Release(gctx)
 		return gear.ErrInternalServerError.From(err)
 	}
@@ -551,37 +573,15 @@ func (a *Collection) TranslateInfo(ctx *gear.Context) error {
 			}
 			if err == nil {
 				usedTokens += tmOutput.Tokens
+				_ = a.blls.Estimator.Record(gctx, model.ID, *msg.Language, language, estimates[language].Base, tmOutput.Tokens)
 			}
 		}
 
-		if usedTokens > 0 {
+		if usedTokens == 0 {
+			_ = a.blls.Walletbase.Release(gctx, hold)
+		} else {
 			auditLog.Tokens = util.Ptr(usedTokens)
-			exp := bll.SpendPayload{
-				GID:      input.GID,
-				ID:       &msg.ID,
-				Action:   bll.LogActionMessageUpdate,
-				Language: languages,
-				Version:  input.Version,
-				Model:    model.ID,
-				Price:    model.Price,
-				Tokens:   usedTokens,
-			}
-
-			wallet, err = a.blls.Walletbase.Spend(gctx, sess.UserID, &exp)
-			if err == nil {
-				txn := &bll.TransactionPK{
-					UID: sess.UserID,
-					ID:  wallet.Txn,
-				}
-
-				if err == nil {
-					err = a.blls.Walletbase.CommitTxn(gctx, txn)
-				}
-
-				if err != nil {
-					_ = a.blls.Walletbase.CancelTxn(gctx, txn)
-				}
-			}
+			err = a.blls.Walletbase.Settle(gctx, hold, usedTokens)
 		}
 
 		log := logging.Log{
@@ -616,6 +616,405 @@ func (a *Collection) TranslateInfo(ctx *gear.Context) error {
 	})
 }
 
+const (
+	maxChildrenPages  = 10
+	translateChildTTL = 20 * 60 * time.Second
+)
+
+type translateChild struct {
+	src        *bll.PublicationOutput
+	teContents content.TEContents
+	estimate   *bll.TokensEstimate
+}
+
+// TranslateChildren translates the child publications of the collection into
+// the target language as a batch job, children that already translated are skipped.
+func (a *Collection) TranslateChildren(ctx *gear.Context) error {
+	input := &bll.TranslateCollectionChildrenInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	if err := a.checkWritePermission(ctx, input.GID); err != nil {
+		return err
+	}
+
+	model := bll.DefaultModel
+	if input.Model != nil {
+		md, err := bll.GetAIModel(*input.Model)
+		if err != nil {
+			return err
+		}
+		model = md
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	wallet, err := a.blls.Walletbase.Get(ctx, sess.UserID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	if wallet.Balance() < 1 {
+		return gear.ErrPaymentRequired.WithMsg("insufficient balance")
+	}
+
+	if err := model.Allow(wallet.Level, input.Language); err != nil {
+		return err
+	}
+
+	payload := &bll.BatchPayload{
+		GID:      input.GID,
+		ID:       input.ID,
+		Language: input.Language,
+		Model:    model.ID,
+		Items:    []bll.BatchItem{},
+	}
+	pending := 0
+
+	var pageToken *util.Bytes
+	for i := 0; i < maxChildrenPages; i++ {
+		res, err := a.blls.Writing.ListCollectionChildren(ctx, &bll.IDGIDPagination{
+			ID:        input.ID,
+			GID:       input.GID,
+			PageToken: pageToken,
+			PageSize:  util.Ptr(uint16(100)),
+			Status:    util.Ptr(int8(0)),
+		})
+		if err != nil {
+			return gear.ErrInternalServerError.From(err)
+		}
+
+		for _, child := range res.Result {
+			item := bll.BatchItem{
+				GID:      child.GID,
+				CID:      child.CID,
+				Language: child.Language,
+				Version:  child.Version,
+			}
+			if child.Kind == 2 {
+				item.Status = bll.BatchItemSkipped
+				item.Error = "collection is not translatable"
+			} else if child.Language == input.Language {
+				item.Status = bll.BatchItemSkipped
+				item.Error = "same language"
+			} else if tc, reason := a.prepareChild(ctx, input, &model, &item); tc == nil {
+				item.Status = bll.BatchItemSkipped
+				item.Error = reason
+			} else {
+				pending++
+				payload.Tokens += tc.estimate.Tokens
+			}
+			payload.Items = append(payload.Items, item)
+		}
+
+		pageToken = nil
+		if len(res.NextPageToken) == 0 {
+			break
+		}
+		pageToken = &res.NextPageToken
+	}
+	payload.Truncated = pageToken != nil
+
+	if pending == 0 {
+		return ctx.OkSend(bll.SuccessResponse[*bll.BatchPayload]{Result: payload})
+	}
+
+	// the children are billed one by one in the job, check the estimated cost
+	// of all of them up front
+	payload.Cost = model.CostWEN(payload.Tokens)
+	if b := wallet.Balance(); b < payload.Cost {
+		return gear.ErrPaymentRequired.WithMsgf("insufficient balance, expected %d, got %d", payload.Cost, b)
+	}
+	if err := a.blls.Budget.Check(ctx, input.GID, sess.UserID, payload.Cost); err != nil {
+		return err
+	}
+
+	gctx := middleware.WithGlobalCtx(ctx)
+	key := fmt.Sprintf("TC:%s:%s:%s", input.GID.String(), input.ID.String(), input.Language)
+	locker, err := a.blls.Locker.Lock(gctx, key, translateChildTTL)
+	if err != nil {
+		return gear.ErrLocked.From(err)
+	}
+
+	log, err := a.blls.Logbase.Log(ctx, bll.LogActionCollectionTranslate, 0, input.GID, payload)
+	if err != nil {
+		locker.Release(gctx)
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	auditLog := &bll.UpdateLog{
+		UID: log.UID,
+		ID:  log.ID,
+	}
+
+	go logging.Run(func() logging.Log {
+		conf.Config.ObtainJob()
+		defer conf.Config.ReleaseJob()
+		defer locker.Release(gctx)
+
+		var err error
+		var usedTokens uint32
+		now := time.Now()
+		attempted := 0
+		failed := 0
+
+		for i := range payload.Items {
+			item := &payload.Items[i]
+			if item.Status != bll.BatchItemPending {
+				continue
+			}
+
+			// the lock is held for one child at a time
+			if er := locker.Refresh(gctx, translateChildTTL, nil); er != nil {
+				err = fmt.Errorf("lock lost: %w", er)
+				break
+			}
+
+			a.runChild(gctx, sess.UserID, input, &model, item)
+			switch item.Status {
+			case bll.BatchItemFailed:
+				attempted++
+				failed++
+			case bll.BatchItemDone:
+				attempted++
+				usedTokens += item.Tokens
+			}
+
+			// update the job progress
+			if data, er := util.Marshal(payload); er == nil {
+				_, _ = a.blls.Logbase.Update(gctx, &bll.UpdateLog{UID: log.UID, ID: log.ID, Payload: &data})
+			}
+		}
+
+		auditLog.Tokens = util.Ptr(usedTokens)
+		if err == nil && attempted > 0 && failed == attempted {
+			err = fmt.Errorf("all %d children failed to translate", failed)
+		}
+
+		log := logging.Log{
+			"action":    "collection.translate_children",
+			"rid":       sess.RID,
+			"uid":       sess.UserID.String(),
+			"gid":       input.GID.String(),
+			"id":        input.ID.String(),
+			"language":  input.Language,
+			"children":  pending,
+			"failed":    failed,
+			"truncated": payload.Truncated,
+			"elapsed":   time.Since(now) / 1e6,
+			"tokens":    usedTokens,
+		}
+
+		if data, er := util.Marshal(payload); er == nil {
+			auditLog.Payload = &data
+		}
+		if err != nil {
+			auditLog.Status = -1
+			auditLog.Error = util.Ptr(err.Error())
+			log["error"] = err.Error()
+		} else {
+			auditLog.Status = 1
+			log["cost"] = model.CostWEN(usedTokens)
+		}
+
+		go a.blls.Logbase.Update(gctx, auditLog)
+		return log
+	})
+
+	return ctx.Send(http.StatusAccepted, bll.SuccessResponse[*bll.BatchPayload]{
+		Job:      auditLog.ID.String(),
+		Progress: util.Ptr(payload.Progress()),
+		Result:   payload,
+	})
+}
+
+// runChild prepares, bills and translates one child in the job, the item is
+// updated with the result.
+func (a *Collection) runChild(gctx context.Context, uid util.ID, input *bll.TranslateCollectionChildrenInput,
+	model *bll.AIModel, item *bll.BatchItem) {
+	tc, reason := a.prepareChild(gctx, input, model, item)
+	if tc == nil {
+		item.Status = bll.BatchItemSkipped
+		item.Error = reason
+		return
+	}
+
+	fail := func(err error) {
+		item.Status = bll.BatchItemFailed
+		item.Error = err.Error()
+	}
+
+	hold, err := a.blls.Walletbase.Hold(gctx, uid, &bll.SpendPayload{
+		GID:      input.GID,
+		ID:       &item.CID,
+		Action:   bll.LogActionCollectionTranslate,
+		Language: input.Language,
+		Model:    model.ID,
+		Tokens:   tc.estimate.Tokens,
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	tokens, err := a.translateChild(gctx, input, model, tc)
+	if err != nil {
+		_ = a.blls.Walletbase.Release(gctx, hold)
+		fail(err)
+		return
+	}
+	if err = a.blls.Walletbase.Settle(gctx, hold, tokens); err != nil {
+		fail(err)
+		return
+	}
+
+	item.Status = bll.BatchItemDone
+	item.Tokens = tokens
+	item.Txn = &hold.ID
+	a.blls.Statistic.Incr(tc.src.GID, tc.src.CID, bll.StatisticTranslations, 1)
+}
+
+// prepareChild loads the child publication and estimates its translating
+// tokens, returns the reason if the child should be skipped.
+func (a *Collection) prepareChild(ctx context.Context, input *bll.TranslateCollectionChildrenInput,
+	model *bll.AIModel, child *bll.BatchItem) (*translateChild, string) {
+	dst, _ := a.blls.Writing.GetPublication(ctx, &bll.ImplicitQueryPublication{
+		GID:      &input.GID,
+		CID:      child.CID,
+		Language: input.Language,
+		Version:  child.Version,
+		Fields:   "status",
+	}, nil)
+	if dst != nil && dst.Status != nil && *dst.Status >= 0 {
+		return nil, "already translated"
+	}
+
+	src, err := a.blls.Writing.GetPublication(ctx, &bll.ImplicitQueryPublication{
+		GID:      &child.GID,
+		CID:      child.CID,
+		Language: child.Language,
+		Version:  child.Version,
+	}, nil)
+	if err != nil {
+		return nil, "publication not found"
+	}
+
+	teContents, err := src.ToTEContents()
+	if err != nil {
+		return nil, err.Error()
+	}
+	if input.ContentFilter != nil && *input.ContentFilter {
+		teContents.ContentFilter()
+	}
+
+	trans, err := teContents.EstimateTranslatingString()
+	if err != nil {
+		return nil, err.Error()
+	}
+	if tokens := util.Tiktokens(trans); tokens > util.MAX_TOKENS {
+		return nil, fmt.Sprintf("too many tokens: %d, expected <= %d", tokens, util.MAX_TOKENS)
+	}
+
+	return &translateChild{
+		src:        src,
+		teContents: teContents,
+		estimate:   a.blls.Estimator.Estimate(ctx, model, trans, child.Language, input.Language),
+	}, ""
+}
+
+// translateChild translates a child publication into the collection's group,
+// returns the used tokens.
+func (a *Collection) translateChild(gctx context.Context, input *bll.TranslateCollectionChildrenInput,
+	model *bll.AIModel, tc *translateChild) (uint32, error) {
+	src := tc.src
+	key := fmt.Sprintf("CP:%s:%s:%s:%d", input.GID.String(), src.CID.String(), input.Language, src.Version)
+	locker, err := a.blls.Locker.Lock(gctx, key, 20*60*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer locker.Release(gctx)
+
+	teData, err := cbor.Marshal(tc.teContents)
+	if err != nil {
+		return 0, err
+	}
+
+	teOutput, err := a.blls.Jarvis.Translate(gctx, &bll.TEInput{
+		GID:          input.GID,
+		CID:          src.CID,
+		Language:     input.Language,
+		Version:      src.Version,
+		FromLanguage: util.Ptr(src.Language),
+		Context:      util.Ptr(fmt.Sprintf("The text is part or all of the %q", *src.Title)),
+		Model:        util.Ptr(model.ID),
+		Content:      util.Ptr(util.Bytes(teData)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	_ = a.blls.Estimator.Record(gctx, model.ID, src.Language, input.Language, tc.estimate.Base, teOutput.Tokens)
+
+	draft, err := src.IntoPublicationDraft(input.GID, input.Language, model.ID, teOutput.Content)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = a.blls.Writing.CreatePublication(gctx, &bll.CreatePublication{
+		GID:      src.GID,
+		CID:      src.CID,
+		Language: src.Language,
+		Version:  src.Version,
+		Draft:    draft,
+	}); err != nil {
+		return 0, err
+	}
+
+	return teOutput.Tokens, nil
+}
+
+func (a *Collection) GetTranslateChildrenByJob(ctx *gear.Context) error {
+	input := &bll.QueryJob{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	log, err := a.blls.Logbase.Get(ctx, sess.UserID, input.ID, "")
+	if err != nil {
+		return gear.ErrBadRequest.WithMsgf("invalid job: %s", err.Error())
+	}
+
+	if log.Action != bll.LogActionCollectionTranslate {
+		return gear.ErrBadRequest.WithMsgf("invalid job action: %s", log.Action)
+	}
+
+	if log.Error != nil {
+		return gear.ErrInternalServerError.WithMsgf("job %s error: %s", log.Action, *log.Error)
+	}
+
+	p, err := util.Unmarshal[bll.BatchPayload](log.Payload)
+	if err != nil {
+		return gear.ErrBadRequest.WithMsgf("invalid job: %v", err)
+	}
+
+	if _, err := a.checkReadPermission(ctx, p.GID); err != nil {
+		return err
+	}
+
+	if log.Status == 0 {
+		progress := p.Progress()
+		if progress >= 100 {
+			progress = 99
+		}
+		return ctx.Send(http.StatusAccepted, bll.SuccessResponse[*bll.BatchPayload]{
+			Job:      input.ID.String(),
+			Progress: util.Ptr(progress),
+			Result:   p,
+		})
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[*bll.BatchPayload]{Result: p})
+}
+
 func (a *Collection) UpdateStatus(ctx *gear.Context) error {
 	input := &bll.UpdateStatusInput{}
 	if err := ctx.ParseBody(input); err != nil {
@@ -703,6 +1102,7 @@ func (a *Collection) Bookmark(ctx *gear.Context) error {
 	if err != nil {
 		return gear.ErrInternalServerError.From(err)
 	}
+	a.blls.Statistic.Incr(input.GID, input.CID, bll.StatisticBookmarks, 1)
 
 	sess := gear.CtxValue[middleware.Session](ctx)
 	if _, err = a.blls.Logbase.Log(ctx, bll.LogActionUserBookmark, 1, sess.UserID, &bll.LogPayload{
@@ -316,7 +316,7 @@ func (a *Creation) Release(ctx *gear.Context) error {
 		return err
 	}
 
-	input.Model = bll.AIModels[0].ID
+	input.Model = bll.DefaultModel.ID
 	creation, err := a.checkWritePermission(ctx, input.GID, input.CID)
 	if err != nil {
 		return err
@@ -680,3 +680,551 @@ func (a *Creation) summarize(gctx context.Context, gid, cid util.ID, auditLog *b
 
 	return output, nil
 }
+
+// Summarize regenerates the summary and keywords of a creation, publication
+// or collection. With preview, the result is only returned by the job, and
+// can be saved by ApplySummarize.
+func (a *Creation) Summarize(ctx *gear.Context) error {
+	input := &bll.SummarizeInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	model := bll.DefaultModel
+	if input.Model != nil {
+		md, err := bll.GetAIModel(*input.Model)
+		if err != nil {
+			return err
+		}
+		model = md
+	}
+
+	src, err := a.summarizeSource(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	wallet, err := a.blls.Walletbase.Get(ctx, sess.UserID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	if wallet.Balance() < 1 {
+		return gear.ErrPaymentRequired.WithMsg("insufficient balance")
+	}
+
+	if err := model.Allow(wallet.Level, src.Language); err != nil {
+		return err
+	}
+
+	teData, err := cbor.Marshal(src.Contents)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	tokens := util.TiktokensWith(model.Tokenizer, src.Text) + uint32(input.MaxLength)
+	if tokens > util.MAX_TOKENS {
+		return gear.ErrUnprocessableEntity.WithMsgf("too many tokens: %d, expected <= %d",
+			tokens, util.MAX_TOKENS)
+	}
+
+	estimate_cost := model.CostWEN(tokens)
+	if b := wallet.Balance(); b < estimate_cost {
+		return gear.ErrPaymentRequired.WithMsgf("insufficient balance, expected %d, got %d", estimate_cost, b)
+	}
+
+	gctx := middleware.WithGlobalCtx(ctx)
+	key := fmt.Sprintf("SM:%s:%s:%s:%d", input.GID.String(), input.ID.String(), src.Language, src.Version)
+	locker, err := a.blls.Locker.Lock(gctx, key, 10*60*time.Second)
+	if err != nil {
+		return gear.ErrLocked.From(err)
+	}
+
+	hold, err := a.blls.Walletbase.Hold(ctx, sess.UserID, &bll.SpendPayload{
+		GID:      input.GID,
+		CID:      &input.ID,
+		Action:   bll.LogActionCreationSummarize,
+		Language: src.Language,
+		Version:  src.Version,
+		Model:    model.ID,
+		Tokens:   tokens,
+	})
+	if err != nil {
+		locker.Release(gctx)
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	payload := &bll.SummarizePayload{
+		LogPayload: bll.LogPayload{
+			GID:      input.GID,
+			CID:      input.ID,
+			Language: util.Ptr(src.Language),
+			Version:  util.Ptr(src.Version),
+			Kind:     util.Ptr(input.Kind),
+		},
+		Model:     model.ID,
+		MaxLength: input.MaxLength,
+		Preview:   input.Preview,
+	}
+
+	log, err := a.blls.Logbase.Log(ctx, bll.LogActionCreationSummarize, 0, input.GID, payload)
+	if err != nil {
+		_ = a.blls.Walletbase.Release(gctx, hold)
+		locker.Release(gctx)
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	auditLog := &bll.UpdateLog{
+		UID: log.UID,
+		ID:  log.ID,
+	}
+
+	go logging.Run(func() logging.Log {
+		conf.Config.ObtainJob()
+		defer conf.Config.ReleaseJob()
+		defer locker.Release(gctx)
+
+		now := time.Now()
+		output, err := a.blls.Jarvis.Summarize(gctx, &bll.TEInput{
+			GID:       input.GID,
+			CID:       input.ID,
+			Language:  src.Language,
+			Version:   src.Version,
+			Model:     util.Ptr(model.ID),
+			MaxLength: util.Ptr(input.MaxLength),
+			Content:   util.Ptr(util.Bytes(teData)),
+		})
+
+		var result *bll.SummarizeOutput
+		if err == nil {
+			if len(output.Keywords) > 5 {
+				output.Keywords = output.Keywords[:5]
+			}
+
+			result = &bll.SummarizeOutput{
+				GID:      input.GID,
+				ID:       input.ID,
+				Kind:     input.Kind,
+				Language: src.Language,
+				Version:  src.Version,
+				Model:    model.ID,
+				Tokens:   output.Tokens,
+				Summary:  util.Truncate(output.Summary, int(input.MaxLength)),
+				Keywords: output.Keywords,
+				Preview:  input.Preview,
+			}
+			if !input.Preview {
+				result.UpdatedAt, err = a.saveSummary(gctx, src, result)
+			}
+		}
+
+		switch {
+		case err != nil:
+			_ = a.blls.Walletbase.Release(gctx, hold)
+		case result.Tokens == 0:
+			// summary will not generated by ai if the content is too short
+			_ = a.blls.Walletbase.Release(gctx, hold)
+		default:
+			auditLog.Tokens = util.Ptr(result.Tokens)
+			err = a.blls.Walletbase.Settle(gctx, hold, result.Tokens)
+			if err == nil {
+				payload.Txn = &hold.ID
+			}
+		}
+
+		log := logging.Log{
+			"action":   "creation.summarize",
+			"rid":      sess.RID,
+			"uid":      sess.UserID.String(),
+			"gid":      input.GID.String(),
+			"id":       input.ID.String(),
+			"kind":     input.Kind,
+			"language": src.Language,
+			"version":  src.Version,
+			"preview":  input.Preview,
+			"elapsed":  time.Since(now) / 1e6,
+			"tokens":   auditLog.Tokens,
+		}
+
+		if err != nil {
+			auditLog.Status = -1
+			auditLog.Error = util.Ptr(err.Error())
+			log["error"] = err.Error()
+		} else {
+			auditLog.Status = 1
+			payload.Result = result
+			if data, er := util.Marshal(payload); er == nil {
+				auditLog.Payload = &data
+			}
+			if auditLog.Tokens != nil {
+				log["cost"] = model.CostWEN(*auditLog.Tokens)
+			}
+		}
+
+		go a.blls.Logbase.Update(gctx, auditLog)
+		return log
+	})
+
+	return ctx.Send(http.StatusAccepted, bll.SuccessResponse[*bll.SummarizeOutput]{
+		Job:    auditLog.ID.String(),
+		Result: nil,
+	})
+}
+
+func (a *Creation) GetSummarizeByJob(ctx *gear.Context) error {
+	input := &bll.QueryJob{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	log, err := a.blls.Logbase.Get(ctx, sess.UserID, input.ID, "")
+	if err != nil {
+		return gear.ErrBadRequest.WithMsgf("invalid job: %s", err.Error())
+	}
+
+	if log.Action != bll.LogActionCreationSummarize {
+		return gear.ErrBadRequest.WithMsgf("invalid job action: %s", log.Action)
+	}
+
+	if log.Error != nil {
+		return gear.ErrInternalServerError.WithMsgf("job %s error: %s", log.Action, *log.Error)
+	}
+
+	p, err := util.Unmarshal[bll.SummarizePayload](log.Payload)
+	if err != nil {
+		return gear.ErrBadRequest.WithMsgf("invalid job: %v", err)
+	}
+	if p.Language == nil || p.Version == nil {
+		return gear.ErrBadRequest.WithMsgf("invalid job payload: %v", p)
+	}
+
+	if err := a.checkReadPermission(ctx, p.GID); err != nil {
+		return err
+	}
+
+	if p.Result != nil {
+		return ctx.OkSend(bll.SuccessResponse[*bll.SummarizeOutput]{Result: p.Result})
+	}
+
+	// the result will be saved to the job payload soon after done
+	progress := int8(99)
+	if log.Status == 0 {
+		res, err := a.blls.Jarvis.GetSummary(ctx, &bll.TEInput{
+			GID:      p.GID,
+			CID:      p.CID,
+			Language: *p.Language,
+			Version:  *p.Version,
+		})
+		if err != nil {
+			er := gear.ErrInternalServerError.From(err)
+			if er.Code != 404 {
+				return er
+			}
+			progress = 0
+		} else if res != nil && res.Progress < progress {
+			progress = res.Progress
+		}
+	}
+
+	return ctx.Send(http.StatusAccepted, bll.SuccessResponse[*bll.SummarizeOutput]{
+		Job:      input.ID.String(),
+		Progress: util.Ptr(progress),
+		Result:   nil,
+	})
+}
+
+// ApplySummarize saves the previewed result of a summarizing job to the
+// record, so that it need not be generated and paid again.
+func (a *Creation) ApplySummarize(ctx *gear.Context) error {
+	input := &bll.QueryJob{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	log, err := a.blls.Logbase.Get(ctx, sess.UserID, input.ID, "")
+	if err != nil {
+		return gear.ErrBadRequest.WithMsgf("invalid job: %s", err.Error())
+	}
+	if log.Action != bll.LogActionCreationSummarize {
+		return gear.ErrBadRequest.WithMsgf("invalid job action: %s", log.Action)
+	}
+	if log.Status != 1 {
+		return gear.ErrBadRequest.WithMsg("job is not done")
+	}
+
+	p, err := util.Unmarshal[bll.SummarizePayload](log.Payload)
+	if err != nil {
+		return gear.ErrBadRequest.WithMsgf("invalid job: %v", err)
+	}
+	result := p.Result
+	if result == nil {
+		return gear.ErrBadRequest.WithMsg("no result in the job")
+	}
+	if !result.Preview {
+		// saved already
+		return ctx.OkSend(bll.SuccessResponse[*bll.SummarizeOutput]{Result: result})
+	}
+
+	gctx := middleware.WithGlobalCtx(ctx)
+	key := fmt.Sprintf("SM:%s:%s:%s:%d", result.GID.String(), result.ID.String(), result.Language, result.Version)
+	locker, err := a.blls.Locker.Lock(gctx, key, 60*time.Second)
+	if err != nil {
+		return gear.ErrLocked.From(err)
+	}
+	defer locker.Release(gctx)
+
+	src, err := a.summarizeSource(ctx, &bll.SummarizeInput{
+		GID:      result.GID,
+		ID:       result.ID,
+		Kind:     result.Kind,
+		Language: result.Language,
+		Version:  result.Version,
+	})
+	if err != nil {
+		return err
+	}
+	if src.Language != result.Language || src.Version != result.Version {
+		return gear.ErrConflict.WithMsg("the record has changed since the preview")
+	}
+
+	if result.UpdatedAt, err = a.saveSummary(gctx, src, result); err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	result.Preview = false
+
+	data, err := util.Marshal(p)
+	if err == nil {
+		_, err = a.blls.Logbase.Update(gctx, &bll.UpdateLog{
+			UID:     log.UID,
+			ID:      log.ID,
+			Status:  log.Status,
+			Payload: &data,
+		})
+	}
+	if err != nil {
+		logging.SetTo(ctx, "writeLogError", err.Error())
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.SummarizeOutput]{Result: result})
+}
+
+type summarizeSource struct {
+	GID       util.ID
+	ID        util.ID
+	Kind      int8
+	Language  string
+	Version   uint16
+	UpdatedAt int64
+	Contents  content.TEContents
+	Text      string
+}
+
+func (a *Creation) summarizeSource(ctx *gear.Context, input *bll.SummarizeInput) (*summarizeSource, error) {
+	sess := gear.CtxValue[middleware.Session](ctx)
+	role, err := a.blls.Userbase.UserGroupRole(ctx, sess.UserID, input.GID)
+	if err != nil {
+		return nil, gear.ErrInternalServerError.From(err)
+	}
+	if role < 0 {
+		return nil, gear.ErrForbidden.WithMsg("no permission")
+	}
+
+	src := &summarizeSource{
+		GID:  input.GID,
+		ID:   input.ID,
+		Kind: input.Kind,
+	}
+
+	switch input.Kind {
+	case 0:
+		creation, err := a.blls.Writing.GetCreation(ctx, &bll.QueryGidID{
+			GID:    input.GID,
+			ID:     input.ID,
+			Fields: "status,creator,updated_at,language,version,content",
+		})
+		if err != nil {
+			return nil, gear.ErrNotFound.From(err)
+		}
+		if creation.Creator == nil || creation.Status == nil || creation.Content == nil {
+			return nil, gear.ErrInternalServerError.WithMsg("invalid creation")
+		}
+		if role < 1 && *creation.Creator != sess.UserID {
+			return nil, gear.ErrForbidden.WithMsg("no permission")
+		}
+		if *creation.Status < 0 {
+			return nil, gear.ErrBadRequest.WithMsg("cannot summarize creation, status is -1")
+		}
+
+		doc, err := content.ParseDocumentNode(*creation.Content)
+		if err != nil {
+			return nil, gear.ErrInternalServerError.From(err)
+		}
+		src.Language = *creation.Language
+		src.Version = *creation.Version
+		src.UpdatedAt = *creation.UpdatedAt
+		src.Contents = doc.ToTEContents()
+
+	case 1:
+		publication, err := a.blls.Writing.GetPublication(ctx, &bll.ImplicitQueryPublication{
+			GID:      &input.GID,
+			CID:      input.ID,
+			Language: input.Language,
+			Version:  input.Version,
+		}, nil)
+		if err != nil {
+			return nil, gear.ErrNotFound.From(err)
+		}
+		if publication.Creator == nil || publication.Status == nil || publication.UpdatedAt == nil {
+			return nil, gear.ErrInternalServerError.WithMsg("invalid publication")
+		}
+		if role < 1 && *publication.Creator != sess.UserID {
+			return nil, gear.ErrForbidden.WithMsg("no permission")
+		}
+		if *publication.Status < 0 {
+			return nil, gear.ErrBadRequest.WithMsg("cannot summarize publication, status is -1")
+		}
+
+		src.Language = publication.Language
+		src.Version = publication.Version
+		src.UpdatedAt = *publication.UpdatedAt
+		src.Contents, err = publication.ToTEContents()
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		if role < 1 {
+			return nil, gear.ErrForbidden.WithMsg("no permission")
+		}
+
+		msg, err := a.blls.Writing.GetCollectionInfo(ctx, &bll.QueryGidID{
+			ID:     input.ID,
+			GID:    input.GID,
+			Fields: "version,language,message",
+		})
+		if err != nil {
+			return nil, gear.ErrNotFound.From(err)
+		}
+		info, err := bll.FromContent[*bll.ArrayMessage](*msg.Message)
+		if err != nil {
+			return nil, gear.ErrInternalServerError.From(err)
+		}
+
+		// a collection is summarized by its title and the titles and summaries of its children
+		src.Language = *msg.Language
+		src.Version = *msg.Version
+		src.Contents = content.TEContents{}
+		for _, v := range info.ToTEContents() {
+			if v.ID == "title" {
+				src.Contents = append(src.Contents, v)
+			}
+		}
+
+		children, err := a.blls.Writing.ListCollectionChildren(ctx, &bll.IDGIDPagination{
+			ID:       input.ID,
+			GID:      input.GID,
+			PageSize: util.Ptr(uint16(100)),
+		})
+		if err != nil {
+			return nil, gear.ErrInternalServerError.From(err)
+		}
+		for _, child := range children.Result {
+			src.Contents = append(src.Contents, &content.TEContent{
+				ID:    child.CID.String(),
+				Texts: []string{child.Title, child.Summary},
+			})
+		}
+	}
+
+	if len(src.Contents) == 0 {
+		return nil, gear.ErrBadRequest.WithMsg("nothing to summarize")
+	}
+
+	src.Text, err = src.Contents.EstimateTranslatingString()
+	if err != nil {
+		return nil, gear.ErrInternalServerError.From(err)
+	}
+	return src, nil
+}
+
+// saveSummary saves the summary and keywords to the record, returns the new updated_at.
+func (a *Creation) saveSummary(gctx context.Context, src *summarizeSource, result *bll.SummarizeOutput) (*int64, error) {
+	switch src.Kind {
+	case 0:
+		output, err := a.blls.Writing.UpdateCreation(gctx, &bll.UpdateCreationInput{
+			GID:       src.GID,
+			ID:        src.ID,
+			UpdatedAt: src.UpdatedAt,
+			Summary:   &result.Summary,
+			Keywords:  &result.Keywords,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return output.UpdatedAt, nil
+
+	case 1:
+		output, err := a.blls.Writing.UpdatePublication(gctx, &bll.UpdatePublicationInput{
+			GID:       src.GID,
+			CID:       src.ID,
+			Language:  src.Language,
+			Version:   src.Version,
+			UpdatedAt: src.UpdatedAt,
+			Summary:   &result.Summary,
+			Keywords:  &result.Keywords,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return output.UpdatedAt, nil
+
+	default:
+		msg, err := a.blls.Writing.GetCollectionInfo(gctx, &bll.QueryGidID{
+			ID:     src.ID,
+			GID:    src.GID,
+			Fields: "version,message",
+		})
+		if err != nil {
+			return nil, err
+		}
+		if *msg.Version != src.Version {
+			return nil, errors.New("collection info version mismatch")
+		}
+
+		info, err := bll.FromContent[*bll.ArrayMessage](*msg.Message)
+		if err != nil {
+			return nil, err
+		}
+
+		updated := bll.ArrayMessage{}
+		for _, v := range *info {
+			if v.ID != "summary" && v.ID != "keywords" {
+				updated = append(updated, v)
+			}
+		}
+		updated = append(updated, &content.TEContent{
+			ID:    "summary",
+			Texts: []string{result.Summary},
+		}, &content.TEContent{
+			ID:    "keywords",
+			Texts: result.Keywords,
+		})
+
+		data, err := updated.MarshalCBOR()
+		if err != nil {
+			return nil, err
+		}
+		output, err := a.blls.Writing.UpdateCollectionInfo(gctx, &bll.UpdateMessageInput{
+			ID:      src.ID,
+			GID:     src.GID,
+			Version: src.Version,
+			Message: util.Ptr(util.Bytes(data)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return output.UpdatedAt, nil
+	}
+}
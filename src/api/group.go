@@ -1,6 +1,8 @@
 package api
 
 import (
+	"time"
+
 	"github.com/teambition/gear"
 
 	"github.com/yiwen-ai/yiwen-api/src/bll"
@@ -140,28 +142,270 @@ func (a *Group) UpdateInfo(ctx *gear.Context) error {
 type GroupStatisticOutput struct {
 	Publications uint `json:"publications" cbor:"publications"`
 	Members      uint `json:"members" cbor:"members"`
+	bll.StatisticCounters
+	Daily []bll.StatisticDaily `json:"daily,omitempty" cbor:"daily,omitempty"` // only for group owners
+}
+
+type QueryGroupStatistic struct {
+	ID   util.ID `json:"id" cbor:"id" query:"id" validate:"required"`
+	Days uint16  `json:"days,omitempty" cbor:"days,omitempty" query:"days" validate:"lte=90"`
+}
+
+func (i *QueryGroupStatistic) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	return nil
 }
 
 func (a *Group) GetStatistic(ctx *gear.Context) error {
-	input := &bll.QueryIdCn{}
+	input := &QueryGroupStatistic{}
 	err := ctx.ParseURL(input)
 	if err != nil {
 		return err
 	}
 
+	res := &GroupStatisticOutput{}
+
+	res.Publications, err = a.blls.Writing.CountPublicationPublish(ctx, &bll.GIDPagination{
+		GID: input.ID,
+	})
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	res.Members, err = a.blls.Userbase.CountGroupMembers(ctx, input.ID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	counters, err := a.blls.Statistic.GroupCounters(ctx, input.ID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	res.StatisticCounters = *counters
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if input.Days > 0 && sess.UserID.Compare(util.MinID) > 0 {
+		if role, _ := a.blls.Userbase.UserGroupRole(ctx, sess.UserID, input.ID); role >= 1 {
+			res.Daily, err = a.blls.Statistic.GroupDaily(ctx, input.ID, input.Days)
+			if err != nil {
+				return gear.ErrInternalServerError.From(err)
+			}
+		}
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[*GroupStatisticOutput]{Result: res})
+}
+
+func (a *Group) GetBudget(ctx *gear.Context) error {
+	input := &bll.QueryIdCn{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
 	if input.ID == nil {
 		return gear.ErrBadRequest.WithMsgf("missing group id")
 	}
 
-	res := &GroupStatisticOutput{}
+	sess := gear.CtxValue[middleware.Session](ctx)
+	role, err := a.blls.Userbase.UserGroupRole(ctx, sess.UserID, *input.ID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	if role < 0 {
+		return gear.ErrForbidden.WithMsg("no permission")
+	}
 
-	res.Publications, err = a.blls.Writing.CountPublicationPublish(ctx, &bll.GIDPagination{
-		GID: *input.ID,
+	limits, err := a.blls.Budget.GetLimits(ctx, *input.ID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	usage, err := a.blls.Budget.Usage(ctx, *input.ID, sess.UserID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[*bll.BudgetOutput]{Result: &bll.BudgetOutput{
+		GID:    *input.ID,
+		Limits: *limits,
+		Usage:  *usage,
+	}})
+}
+
+func (a *Group) UpdateBudget(ctx *gear.Context) error {
+	input := &bll.UpdateBudgetInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	role, err := a.blls.Userbase.UserGroupRole(ctx, sess.UserID, input.GID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	if role < 1 {
+		return gear.ErrForbidden.WithMsg("no permission")
+	}
+
+	if err = a.blls.Budget.SetLimits(ctx, input.GID, &input.BudgetLimits); err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	usage, err := a.blls.Budget.Usage(ctx, input.GID, sess.UserID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[*bll.BudgetOutput]{Result: &bll.BudgetOutput{
+		GID:    input.GID,
+		Limits: input.BudgetLimits,
+		Usage:  *usage,
+	}})
+}
+
+func (a *Group) GetMembership(ctx *gear.Context) error {
+	input := &bll.QueryIdCn{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+	if input.ID == nil {
+		return gear.ErrBadRequest.WithMsgf("missing group id")
+	}
+
+	plan, err := a.blls.Membership.GetPlan(ctx, *input.ID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	output := &bll.MembershipOutput{GID: *input.ID, MembershipPlan: *plan}
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if sess.UserID.Compare(util.MinID) > 0 {
+		output.Subscription, err = a.blls.Membership.Get(ctx, sess.UserID, *input.ID)
+		if err != nil {
+			return gear.ErrInternalServerError.From(err)
+		}
+		if s := output.Subscription; s != nil && s.ExpireAt > time.Now().Unix() {
+			if subtoken, err := encodeSubToken(a.blls, 3, s); err == nil {
+				output.SubToken = &subtoken
+			}
+		}
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[*bll.MembershipOutput]{Result: output})
+}
+
+func (a *Group) UpdateMembership(ctx *gear.Context) error {
+	input := &bll.UpdateMembershipPlanInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	role, err := a.blls.Userbase.UserGroupRole(ctx, sess.UserID, input.GID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	if role < 2 {
+		return gear.ErrForbidden.WithMsg("no permission")
+	}
+
+	if err = a.blls.Membership.SetPlan(ctx, input.GID, &input.MembershipPlan); err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[*bll.MembershipOutput]{Result: &bll.MembershipOutput{
+		GID:            input.GID,
+		MembershipPlan: input.MembershipPlan,
+	}})
+}
+
+func (a *Group) ListSubscribing(ctx *gear.Context) error {
+	sess := gear.CtxValue[middleware.Session](ctx)
+	subs, err := a.blls.Membership.Subscriptions(ctx, sess.UserID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	now := time.Now().Unix()
+	output := make(bll.GroupSubscriptionOutputs, 0, len(subs))
+	for i := range subs {
+		if subs[i].ExpireAt <= now {
+			continue
+		}
+		item := bll.GroupSubscriptionOutput{SubscriptionOutput: subs[i]}
+		if subtoken, err := encodeSubToken(a.blls, 3, &subs[i]); err == nil {
+			item.SubToken = &subtoken
+		}
+		output = append(output, item)
+	}
+
+	output.LoadGroups(func(ids ...util.ID) []bll.GroupInfo {
+		return a.blls.Userbase.LoadGroupInfo(ctx, ids...)
 	})
+	return ctx.OkSend(bll.SuccessResponse[bll.GroupSubscriptionOutputs]{Result: output})
+}
+
+func (a *Group) ListCoupons(ctx *gear.Context) error {
+	input := &bll.QueryCoupon{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	if err := a.checkOwner(ctx, input.GID); err != nil {
+		return err
+	}
 
+	output, err := a.blls.Coupon.List(ctx, input.GID)
 	if err != nil {
 		return gear.ErrInternalServerError.From(err)
 	}
+	return ctx.OkSend(bll.SuccessResponse[[]bll.CouponOutput]{Result: output})
+}
 
-	return ctx.OkSend(bll.SuccessResponse[*GroupStatisticOutput]{Result: res})
+func (a *Group) UpdateCoupon(ctx *gear.Context) error {
+	input := &bll.UpdateCouponInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	if err := a.checkOwner(ctx, input.GID); err != nil {
+		return err
+	}
+
+	output, err := a.blls.Coupon.Save(ctx, input.GID, &input.CouponInfo)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.CouponOutput]{Result: output})
+}
+
+func (a *Group) DeleteCoupon(ctx *gear.Context) error {
+	input := &bll.QueryCoupon{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+	if input.Code == "" {
+		return gear.ErrBadRequest.WithMsg("missing coupon code")
+	}
+
+	if err := a.checkOwner(ctx, input.GID); err != nil {
+		return err
+	}
+
+	if err := a.blls.Coupon.Delete(ctx, input.GID, input.Code); err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.OkSend(bll.SuccessResponse[bool]{Result: true})
+}
+
+func (a *Group) checkOwner(ctx *gear.Context, gid util.ID) error {
+	sess := gear.CtxValue[middleware.Session](ctx)
+	role, err := a.blls.Userbase.UserGroupRole(ctx, sess.UserID, gid)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	if role < 2 {
+		return gear.ErrForbidden.WithMsg("no permission")
+	}
+	return nil
 }
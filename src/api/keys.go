@@ -0,0 +1,53 @@
+package api
+
+import (
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ldclabs/cose/key"
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/bll"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+// Keys publishes the public keys to verify subscription tokens offline.
+// A token is a base64url encoded COSE_Sign1 message, the kid in its
+// unprotected headers picks the key, and it is signed with the external
+// additional authenticated data "SubscriptionToken".
+type Keys struct {
+	blls *bll.Blls
+}
+
+func (a *Keys) publicKeys() key.KeySet {
+	if a.blls.Signer == nil {
+		return key.KeySet{}
+	}
+	return a.blls.Signer.PublicKeys()
+}
+
+// JWKS returns the public keys as a JSON Web Key Set.
+func (a *Keys) JWKS(ctx *gear.Context) error {
+	pks := a.publicKeys()
+	keys := make([]map[string]any, 0, len(pks))
+	for _, k := range pks {
+		jwk, err := util.ToJWK(k)
+		if err != nil {
+			return gear.ErrInternalServerError.From(err)
+		}
+		keys = append(keys, jwk)
+	}
+
+	ctx.SetHeader(gear.HeaderCacheControl, "public, max-age=3600")
+	return ctx.OkJSON(map[string]any{"keys": keys})
+}
+
+// COSEKeys returns the public keys as a CBOR encoded COSE_KeySet.
+func (a *Keys) COSEKeys(ctx *gear.Context) error {
+	data, err := cbor.Marshal(a.publicKeys())
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	ctx.SetHeader(gear.HeaderCacheControl, "public, max-age=3600")
+	ctx.Type(gear.MIMEApplicationCBOR)
+	return ctx.End(200, data)
+}
@@ -108,7 +108,11 @@ func (a *Message) UpdateI18n(ctx *gear.Context) error {
 
 	model := bll.DefaultModel
 	if input.Model != nil {
-		model = bll.GetAIModel(*input.Model)
+		md, err := bll.GetAIModel(*input.Model)
+		if err != nil {
+			return err
+		}
+		model = md
 	}
 
 	lang := *input.Language
@@ -165,6 +169,10 @@ func (a *Message) UpdateI18n(ctx *gear.Context) error {
 		return gear.ErrPaymentRequired.WithMsg("insufficient balance")
 	}
 
+	if err := model.Allow(wallet.Level, lang); err != nil {
+		return err
+	}
+
 	teContents := srcMsg.ToTEContents()
 	teData, err := cbor.Marshal(teContents)
 	if err != nil {
@@ -181,8 +189,8 @@ func (a *Message) UpdateI18n(ctx *gear.Context) error {
 			tokens, util.MAX_TOKENS)
 	}
 
-	tokens := a.blls.Jarvis.EstimateTranslatingTokens(trans, *msg.Language, *input.Language)
-	estimate_cost := model.CostWEN(tokens)
+	estimate := a.blls.Estimator.Estimate(ctx, &model, trans, *msg.Language, *input.Language)
+	estimate_cost := model.CostWEN(estimate.Tokens)
 	if b := wallet.Balance(); b < estimate_cost {
 		return gear.ErrPaymentRequired.WithMsgf("insufficient balance, expected %d, got %d", estimate_cost, b)
 	}
@@ -194,6 +202,20 @@ func (a *Message) UpdateI18n(ctx *gear.Context) error {
 		return gear.ErrLocked.From(err)
 	}
 
+	hold, err := a.blls.Walletbase.Hold(ctx, sess.UserID, &bll.SpendPayload{
+		GID:      *msg.AttachTo,
+		ID:       &msg.ID,
+		Action:   bll.LogActionMessageUpdate,
+		Language: *input.Language,
+		Version:  *msg.Version,
+		Model:    model.ID,
+		Tokens:   estimate.Tokens,
+	})
+	if err != nil {
+		locker.Release(gctx)
+		return gear.ErrInternalServerError.From(err)
+	}
+
 	payload := &bll.LogMessage{
 		ID:       msg.ID,
 		AttachTo: *msg.AttachTo,
@@ -203,6 +225,7 @@ func (a *Message) UpdateI18n(ctx *gear.Context) error {
 
 	log, err := a.blls.Logbase.Log(ctx, bll.LogActionMessageUpdate, 0, payload.AttachTo, payload)
 	if err != nil {
+		_ = a.blls.Walletbase.Release(gctx, hold)
 		locker.Release(gctx)
 		return gear.ErrInternalServerError.From(err)
 	}
@@ -232,40 +255,23 @@ func (a *Message) UpdateI18n(ctx *gear.Context) error {
 			err = bll.WithContent(dstMsg, tmOutput.Content)
 		}
 
-		if err == nil {
+		if err != nil {
+			_ = a.blls.Walletbase.Release(gctx, hold)
+		} else {
 			auditLog.Tokens = util.Ptr(tmOutput.Tokens)
+			_ = a.blls.Estimator.Record(gctx, model.ID, *msg.Language, *input.Language, estimate.Base, tmOutput.Tokens)
 
-			exp := bll.SpendPayload{
-				GID:      *msg.AttachTo,
-				ID:       &msg.ID,
-				Action:   bll.LogActionMessageUpdate,
-				Language: *input.Language,
-				Version:  *msg.Version,
-				Model:    model.ID,
-				Price:    model.Price,
-				Tokens:   tmOutput.Tokens,
+			var data []byte
+			data, err = cbor.Marshal(dstMsg)
+			if err == nil {
+				input.Message = util.Ptr(util.Bytes(data))
+				_, err = a.blls.Writing.UpdateMessage(gctx, input)
 			}
 
-			wallet, err = a.blls.Walletbase.Spend(gctx, sess.UserID, &exp)
 			if err == nil {
-				txn := &bll.TransactionPK{
-					UID: sess.UserID,
-					ID:  wallet.Txn,
-				}
-
-				data, err := cbor.Marshal(dstMsg)
-				if err == nil {
-					input.Message = util.Ptr(util.Bytes(data))
-					_, err = a.blls.Writing.UpdateMessage(gctx, input)
-				}
-
-				if err == nil {
-					err = a.blls.Walletbase.CommitTxn(gctx, txn)
-				}
-
-				if err != nil {
-					_ = a.blls.Walletbase.CancelTxn(gctx, txn)
-				}
+				err = a.blls.Walletbase.Settle(gctx, hold, tmOutput.Tokens)
+			} else {
+				_ = a.blls.Walletbase.Release(gctx, hold)
 			}
 		}
 
@@ -6,6 +6,7 @@ import (
 	"github.com/teambition/gear"
 
 	"github.com/yiwen-ai/yiwen-api/src/bll"
+	"github.com/yiwen-ai/yiwen-api/src/logging"
 	"github.com/yiwen-ai/yiwen-api/src/middleware"
 	"github.com/yiwen-ai/yiwen-api/src/util"
 )
@@ -15,30 +16,76 @@ type Payment struct {
 }
 
 type PaymentCode struct {
-	Kind     int8     `cbor:"1,keyasint"`           // 0: subscribe creation; 2: subscribe collection
-	ExpireAt int64    `cbor:"2,keyasint"`           // code 的失效时间，unix 秒
-	Payee    util.ID  `cbor:"3,keyasint"`           // 收款人 id
-	SubPayee *util.ID `cbor:"4,keyasint,omitempty"` // 分成收款人 id
-	Amount   int64    `cbor:"5,keyasint"`           // 花费的亿文币数量
-	GID      util.ID  `cbor:"6,keyasint"`           // 订阅对象所属 group
-	UID      util.ID  `cbor:"7,keyasint"`           // 受益人 id
-	CID      util.ID  `cbor:"8,keyasint"`           // 订阅对象 id
-	Duration int64    `cbor:"9,keyasint"`           // 增加的订阅时长，单位秒
+	Kind     int8     `cbor:"1,keyasint"`            // 0: subscribe creation; 2: subscribe collection; 3: group membership
+	ExpireAt int64    `cbor:"2,keyasint"`            // code 的失效时间，unix 秒
+	Payee    util.ID  `cbor:"3,keyasint"`            // 收款人 id
+	SubPayee *util.ID `cbor:"4,keyasint,omitempty"`  // 分成收款人 id
+	Amount   int64    `cbor:"5,keyasint"`            // 花费的亿文币数量
+	GID      util.ID  `cbor:"6,keyasint"`            // 订阅对象所属 group
+	UID      util.ID  `cbor:"7,keyasint"`            // 受益人 id
+	CID      util.ID  `cbor:"8,keyasint"`            // 订阅对象 id
+	Duration int64    `cbor:"9,keyasint"`            // 增加的订阅时长，单位秒
+	Period   string   `cbor:"10,keyasint,omitempty"` // 订阅周期：month 或 year，支持自动续订
+	Coupon   string   `cbor:"11,keyasint,omitempty"` // 使用的优惠券
+	Price    int64    `cbor:"12,keyasint,omitempty"` // 使用优惠券时的原价，自动续订按原价扣费
+	CouponBy *util.ID `cbor:"13,keyasint,omitempty"` // 发放优惠券的 group
+	Nonce    util.ID  `cbor:"14,keyasint"`           // 一次性随机数，支付时消耗
+	Epoch    int64    `cbor:"15,keyasint"`           // 签发时用户的 epoch，撤销全部 code 时递增
 }
 
 type SubscriptionToken struct {
-	Kind     int8    `cbor:"1,keyasint"` // 2: collection subscription
+	Kind     int8    `cbor:"1,keyasint"` // 2: collection subscription; 3: group membership
 	ExpireAt int64   `cbor:"2,keyasint"` // 订阅失效时间，unix 秒
 	GID      util.ID `cbor:"3,keyasint"` // 订阅对象所属 group
 	UID      util.ID `cbor:"4,keyasint"` // 受益人 id
 	CID      util.ID `cbor:"5,keyasint"` // 订阅对象 id
 }
 
+// encodeSubToken signs the token with the asymmetric key if configured,
+// so that it can be verified offline with the public keys.
+func encodeSubToken(blls *bll.Blls, kind int8, s *bll.SubscriptionOutput) (string, error) {
+	token := SubscriptionToken{
+		Kind:     kind,
+		ExpireAt: s.ExpireAt,
+		UID:      s.UID,
+		CID:      s.CID,
+		GID:      s.GID,
+	}
+	if blls.Signer != nil {
+		return util.EncodeSign1(blls.Signer, token, []byte("SubscriptionToken"))
+	}
+	return util.EncodeMac0(blls.MACer, token, []byte("SubscriptionToken"))
+}
+
+// decodeSubToken also accepts the HMAC tokens issued before the signing key configured.
+func decodeSubToken(blls *bll.Blls, input string) (*SubscriptionToken, error) {
+	if blls.Verifier != nil {
+		if token, err := util.DecodeSign1[SubscriptionToken](blls.Verifier, input, []byte("SubscriptionToken")); err == nil {
+			return token, nil
+		}
+	}
+	return util.DecodeMac0[SubscriptionToken](blls.MACer, input, []byte("SubscriptionToken"))
+}
+
+type GiftCode struct {
+	ID       util.ID `cbor:"1,keyasint"` // code id，用于单次兑换
+	Batch    util.ID `cbor:"2,keyasint"` // 购买交易 id
+	Kind     int8    `cbor:"3,keyasint"` // 同 PaymentCode.Kind
+	GID      util.ID `cbor:"4,keyasint"` // 订阅对象所属 group
+	CID      util.ID `cbor:"5,keyasint"` // 订阅对象 id
+	Duration int64   `cbor:"6,keyasint"` // 增加的订阅时长，单位秒
+	ExpireAt int64   `cbor:"7,keyasint"` // 兑换截止时间，unix 秒
+}
+
 type QueryPaymentCode struct {
-	Kind int8    `json:"kind" cbor:"kind" query:"kind" validate:"gte=0,lte=2"`
+	Kind int8    `json:"kind" cbor:"kind" query:"kind" validate:"gte=0,lte=3"`
 	CID  util.ID `json:"cid" cbor:"cid" query:"cid" validate:"required"`
 	// 触发支付的 group，如果不是订阅对象所属 group，则分享收益给该 group
 	GID util.ID `json:"gid" cbor:"gid" query:"gid" validate:"required"`
+	// 订阅周期，为空则一次性购买
+	Period string `json:"period" cbor:"period" query:"period" validate:"omitempty,oneof=month year"`
+	// 优惠券，由订阅对象所属 group 发放
+	Coupon string `json:"coupon" cbor:"coupon" query:"coupon" validate:"omitempty,alphanum,max=32"`
 }
 
 func (i *QueryPaymentCode) Validate() error {
@@ -51,9 +98,12 @@ func (i *QueryPaymentCode) Validate() error {
 
 type PaymentCodeOutput struct {
 	Kind      int8           `json:"kind" cbor:"kind"`
+	Period    string         `json:"period,omitempty" cbor:"period,omitempty"`
 	Title     string         `json:"title" cbor:"title"`
 	Duration  int64          `json:"duration" cbor:"duration"`
 	Amount    int64          `json:"amount" cbor:"amount"`
+	Price     int64          `json:"price,omitempty" cbor:"price,omitempty"`
+	Coupon    string         `json:"coupon,omitempty" cbor:"coupon,omitempty"`
 	Code      string         `json:"code" cbor:"code"`
 	ExpireAt  int64          `json:"expire_at" cbor:"expire_at"`
 	GroupInfo *bll.GroupInfo `json:"group_info" cbor:"group_info"`
@@ -65,6 +115,31 @@ func (a *Payment) GetCode(ctx *gear.Context) error {
 		return err
 	}
 
+	code, output, bookmark, err := a.newCode(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	code.Nonce, code.Epoch, err = a.blls.PayCode.Issue(ctx, code.UID, uint(code.ExpireAt-time.Now().Unix()))
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	output.Code, err = util.EncodeEncrypt0(a.blls.Encryptor, code, []byte("PaymentCode"))
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	if bookmark != nil {
+		_, _ = a.blls.Writing.CreateBookmark(ctx, bookmark)
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[[]*PaymentCodeOutput]{Result: []*PaymentCodeOutput{output}})
+}
+
+// newCode prices the subscription object and resolves the payees,
+// it returns a bookmark input for content subscriptions.
+func (a *Payment) newCode(ctx *gear.Context, input *QueryPaymentCode) (*PaymentCode, *PaymentCodeOutput, *bll.CreateBookmarkInput, error) {
 	sess := gear.CtxValue[middleware.Session](ctx)
 	code := &PaymentCode{
 		Kind:     input.Kind,
@@ -72,11 +147,12 @@ func (a *Payment) GetCode(ctx *gear.Context) error {
 		GID:      input.GID,
 		UID:      sess.UserID,
 		CID:      input.CID,
-		Duration: 3600 * 24 * 365 * 3, // seconds
+		Duration: bll.SubscriptionDefaultDuration,
+		Period:   input.Period,
 	}
 	output := &PaymentCodeOutput{
 		Kind:     code.Kind,
-		Duration: code.Duration,
+		Period:   code.Period,
 		ExpireAt: code.ExpireAt,
 	}
 
@@ -87,7 +163,7 @@ func (a *Payment) GetCode(ctx *gear.Context) error {
 
 	switch input.Kind {
 	default:
-		return gear.ErrBadRequest.WithMsg("invalid kind")
+		return nil, nil, nil, gear.ErrBadRequest.WithMsg("invalid kind")
 	case 0, 1:
 		doc, err := a.blls.Writing.ImplicitGetPublication(ctx, &bll.ImplicitQueryPublication{
 			CID:    input.CID,
@@ -95,10 +171,10 @@ func (a *Payment) GetCode(ctx *gear.Context) error {
 			Fields: "title",
 		}, nil)
 		if err != nil {
-			return gear.ErrInternalServerError.From(err)
+			return nil, nil, nil, gear.ErrInternalServerError.From(err)
 		}
 		if doc.Title == nil || doc.Price == nil || doc.FromGID == nil {
-			return gear.ErrInternalServerError.WithMsg("title or price or from_gid is nil")
+			return nil, nil, nil, gear.ErrInternalServerError.WithMsg("title or price or from_gid is nil")
 		}
 
 		if *doc.FromGID != input.GID {
@@ -108,7 +184,7 @@ func (a *Payment) GetCode(ctx *gear.Context) error {
 
 		code.Amount = *doc.Price
 		if code.Amount <= 0 {
-			return gear.ErrBadRequest.WithMsg("creation is free")
+			return nil, nil, nil, gear.ErrBadRequest.WithMsg("creation is free")
 		}
 		output.Amount = code.Amount
 		output.Title = *doc.Title
@@ -121,10 +197,10 @@ func (a *Payment) GetCode(ctx *gear.Context) error {
 			Fields: "gid,info",
 		})
 		if err != nil {
-			return gear.ErrInternalServerError.From(err)
+			return nil, nil, nil, gear.ErrInternalServerError.From(err)
 		}
 		if doc.Info == nil || doc.Price == nil {
-			return gear.ErrInternalServerError.WithMsg("title or price is nil")
+			return nil, nil, nil, gear.ErrInternalServerError.WithMsg("title or price is nil")
 		}
 		if doc.GID != input.GID {
 			PayeeGID = doc.GID
@@ -133,7 +209,7 @@ func (a *Payment) GetCode(ctx *gear.Context) error {
 
 		code.Amount = *doc.Price
 		if code.Amount <= 0 {
-			return gear.ErrBadRequest.WithMsg("collection is free")
+			return nil, nil, nil, gear.ErrBadRequest.WithMsg("collection is free")
 		}
 		output.Amount = code.Amount
 		output.Title = doc.Info.Title
@@ -146,7 +222,50 @@ func (a *Payment) GetCode(ctx *gear.Context) error {
 				break
 			}
 		}
+	case 3:
+		// the group itself is the subscription object, no revenue sharing
+		if input.CID != input.GID {
+			return nil, nil, nil, gear.ErrBadRequest.WithMsg("cid should be the group id")
+		}
+		plan, err := a.blls.Membership.GetPlan(ctx, input.GID)
+		if err != nil {
+			return nil, nil, nil, gear.ErrInternalServerError.From(err)
+		}
+		if plan.Price <= 0 || plan.Duration <= 0 {
+			return nil, nil, nil, gear.ErrBadRequest.WithMsg("group membership is not available")
+		}
+
+		code.Amount = plan.Price
+		code.Duration = plan.Duration
+		output.Amount = code.Amount
+	}
+
+	var err error
+	if code.Duration, code.Amount, err = bll.SubscriptionPeriod(code.Period, code.Amount, code.Duration); err != nil {
+		return nil, nil, nil, err
 	}
+	if input.Coupon != "" {
+		coupon, err := a.blls.Coupon.Get(ctx, PayeeGID, input.Coupon)
+		if err != nil {
+			return nil, nil, nil, gear.ErrBadRequest.From(err)
+		}
+		if coupon.MaxUses > 0 && coupon.Uses >= coupon.MaxUses {
+			return nil, nil, nil, gear.ErrBadRequest.WithMsg("coupon has been used up")
+		}
+		amount, err := coupon.Apply(code.CID, code.Amount, time.Now().Unix())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		code.Price = code.Amount
+		code.Amount = amount
+		code.Coupon = coupon.Code
+		code.CouponBy = &PayeeGID
+		output.Price = code.Price
+		output.Coupon = code.Coupon
+	}
+	output.Amount = code.Amount
+	output.Duration = code.Duration
 
 	if SubPayeeGID != nil {
 		group, err := a.blls.Userbase.GetGroup(ctx, *SubPayeeGID, "uid,status")
@@ -157,12 +276,18 @@ func (a *Payment) GetCode(ctx *gear.Context) error {
 
 	group, err := a.blls.Userbase.GetGroup(ctx, PayeeGID, "uid,cn,name,logo,status,slogan")
 	if err != nil {
-		return gear.ErrInternalServerError.From(err)
+		return nil, nil, nil, gear.ErrInternalServerError.From(err)
 	}
 	if *group.Status < 0 {
-		return gear.ErrBadRequest.WithMsg("group is not active")
+		return nil, nil, nil, gear.ErrBadRequest.WithMsg("group is not active")
 	}
 	code.Payee = *group.UID
+	if code.Kind == 3 {
+		if code.Payee == sess.UserID {
+			return nil, nil, nil, gear.ErrBadRequest.WithMsg("cannot subscribe own group")
+		}
+		output.Title = group.Name
+	}
 
 	output.GroupInfo = &bll.GroupInfo{
 		ID:     *group.ID,
@@ -173,25 +298,24 @@ func (a *Payment) GetCode(ctx *gear.Context) error {
 		Status: *group.Status,
 	}
 
-	output.Code, err = util.EncodeEncrypt0(a.blls.Encryptor, code, []byte("PaymentCode"))
-	if err != nil {
-		return gear.ErrInternalServerError.From(err)
+	if code.Kind == 3 {
+		return code, output, nil, nil
 	}
 
-	_, _ = a.blls.Writing.CreateBookmark(ctx, &bll.CreateBookmarkInput{
+	return code, output, &bll.CreateBookmarkInput{
 		GID:      code.GID,
 		CID:      code.CID,
 		Language: language,
 		Version:  version,
 		Kind:     output.Kind,
 		Title:    output.Title,
-	})
-
-	return ctx.OkSend(bll.SuccessResponse[[]*PaymentCodeOutput]{Result: []*PaymentCodeOutput{output}})
+	}, nil
 }
 
 type PaymentInput struct {
 	Code string `json:"code" cbor:"code" query:"code" validate:"required"`
+	// 到期前自动从钱包扣费续订，仅支持按周期订阅
+	AutoRenew bool `json:"auto_renew" cbor:"auto_renew"`
 }
 
 func (i *PaymentInput) Validate() error {
@@ -202,6 +326,19 @@ func (i *PaymentInput) Validate() error {
 	return nil
 }
 
+type RevokeCodeInput struct {
+	// 为空时撤销全部未使用的 code
+	Code string `json:"code" cbor:"code" query:"code" validate:"omitempty,max=1024"`
+}
+
+func (i *RevokeCodeInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	return nil
+}
+
 func (a *Payment) PayByCode(ctx *gear.Context) error {
 	input := &PaymentInput{}
 	if err := ctx.ParseBody(input); err != nil {
@@ -216,6 +353,24 @@ func (a *Payment) PayByCode(ctx *gear.Context) error {
 	if code.ExpireAt < now {
 		return gear.ErrBadRequest.WithMsg("code expired")
 	}
+	if input.AutoRenew && code.Period == "" {
+		return gear.ErrBadRequest.WithMsg("auto-renewal requires a period")
+	}
+
+	// the price may be changed after the code issued
+	current, _, _, err := a.newCode(ctx, &QueryPaymentCode{
+		Kind:   code.Kind,
+		CID:    code.CID,
+		GID:    code.GID,
+		Period: code.Period,
+		Coupon: code.Coupon,
+	})
+	if err != nil {
+		return err
+	}
+	if current.Amount != code.Amount || current.Duration != code.Duration {
+		return gear.ErrConflict.WithMsgf("price changed from %d to %d, please get a new payment code", code.Amount, current.Amount)
+	}
 
 	sess := gear.CtxValue[middleware.Session](ctx)
 	wallet, err := a.blls.Walletbase.Get(ctx, sess.UserID)
@@ -227,21 +382,50 @@ func (a *Payment) PayByCode(ctx *gear.Context) error {
 		return gear.ErrPaymentRequired.WithMsg("insufficient balance")
 	}
 
-	var subscription *bll.SubscriptionOutput
+	paid := false
+	if code.Coupon != "" && code.CouponBy != nil {
+		// the coupon may be changed or used up after the code issued
+		if err = a.blls.Coupon.Use(ctx, *code.CouponBy, code.Coupon, code.CID, now); err != nil {
+			if er := gear.ErrInternalServerError.From(err); er.Code == 404 {
+				return gear.ErrBadRequest.WithMsg("coupon is no longer valid")
+			}
+			return err
+		}
+
+		defer func() {
+			if !paid {
+				_ = a.blls.Coupon.Unuse(ctx, *code.CouponBy, code.Coupon)
+			}
+		}()
+	}
+
 	var logAction string
 	switch code.Kind {
 	default:
 		return gear.ErrBadRequest.WithMsg("invalid kind")
 	case 0, 1:
 		logAction = bll.LogActionCreationSubscribe
-		subscription, _ = a.blls.Writing.InternalGetCreationSubscription(ctx, code.CID)
 	case 2:
 		logAction = bll.LogActionCollectionSubscribe
-		subscription, _ = a.blls.Writing.InternalGetCollectionSubscription(ctx, code.CID)
+	case 3:
+		logAction = bll.LogActionGroupSubscribe
+	}
+	subscription, err := a.blls.Renewal.GetSubscription(ctx, code.Kind, code.UID, code.CID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
 	}
 	if subscription != nil && subscription.ExpireAt > (now+code.Duration/2) {
 		return gear.ErrBadRequest.WithMsg("already subscribed")
 	}
+	if err = a.blls.PayCode.Consume(ctx, code.UID, code.Nonce, code.Epoch); err != nil {
+		return err
+	}
+	defer func() {
+		if !paid {
+			// the code can be used again if the payment failed
+			_ = a.blls.PayCode.Restore(ctx, code.UID, code.Nonce, code.ExpireAt)
+		}
+	}()
 
 	subscriptionInput := &bll.SubscriptionInput{
 		UID:      code.UID,
@@ -288,15 +472,168 @@ func (a *Payment) PayByCode(ctx *gear.Context) error {
 		ID:  wallet.Txn,
 	}
 
-	switch code.Kind {
-	default:
-		err = gear.ErrBadRequest.WithMsg("invalid kind")
-	case 0:
-		subscription, err = a.blls.Writing.InternalUpdateCreationSubscription(ctx, subscriptionInput)
-	case 2:
-		subscription, err = a.blls.Writing.InternalUpdateCollectionSubscription(ctx, subscriptionInput)
+	subscription, err = a.blls.Renewal.UpdateSubscription(ctx, code.Kind, subscriptionInput)
+	if err == nil {
+		auditLog.Status = 1
+		err = a.blls.Walletbase.CommitTxn(ctx, txn)
+	}
+
+	if err != nil {
+		auditLog.Status = -1
+		auditLog.Error = util.Ptr(err.Error())
+		_ = a.blls.Walletbase.CancelTxn(ctx, txn)
+		a.blls.Logbase.Update(ctx, auditLog)
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	paid = true
+	a.blls.Logbase.Update(ctx, auditLog)
+	a.blls.Statistic.Incr(code.GID, code.CID, bll.StatisticSubscriptions, 1)
+	if input.AutoRenew {
+		err = a.blls.Renewal.Enable(ctx, &bll.RenewalPlan{
+			UID:      code.UID,
+			Kind:     code.Kind,
+			GID:      code.GID,
+			CID:      code.CID,
+			Payee:    code.Payee,
+			SubPayee: code.SubPayee,
+			Amount:   max(code.Amount, code.Price),
+			Duration: code.Duration,
+			Period:   code.Period,
+			ExpireAt: subscription.ExpireAt,
+		})
+		if err != nil {
+			logging.SetTo(ctx, "renewalError", err.Error())
+		}
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.SubscriptionOutput]{Result: subscription})
+}
+
+func (a *Payment) GetRenewal(ctx *gear.Context) error {
+	input := &bll.QueryRenewal{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	output, err := a.blls.Renewal.Get(ctx, sess.UserID, input.Kind, input.CID)
+	if err != nil {
+		return gear.ErrNotFound.From(err)
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.RenewalPlan]{Result: output})
+}
+
+func (a *Payment) CancelRenewal(ctx *gear.Context) error {
+	input := &bll.QueryRenewal{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if err := a.blls.Renewal.Cancel(ctx, sess.UserID, input.Kind, input.CID); err != nil {
+		return err
+	}
+	return ctx.OkSend(bll.SuccessResponse[bool]{Result: true})
+}
+
+func (a *Payment) ListReminders(ctx *gear.Context) error {
+	sess := gear.CtxValue[middleware.Session](ctx)
+	output, err := a.blls.Renewal.Reminders(ctx, sess.UserID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.OkSend(bll.SuccessResponse[[]bll.Reminder]{Result: output})
+}
+
+func (a *Payment) BuyGift(ctx *gear.Context) error {
+	input := &bll.CreateGiftInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	code, output, _, err := a.newCode(ctx, &QueryPaymentCode{
+		Kind:   input.Kind,
+		CID:    input.CID,
+		GID:    input.GID,
+		Period: input.Period,
+	})
+	if err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	total := code.Amount * int64(input.Count)
+	wallet, err := a.blls.Walletbase.Get(ctx, sess.UserID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	if wallet.Balance() < total {
+		return gear.ErrPaymentRequired.WithMsg("insufficient balance")
+	}
+
+	payload, err := util.Marshal(code)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	log, err := a.blls.Logbase.Log(ctx, bll.LogActionGiftPurchase, 0, code.GID, input)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	auditLog := &bll.UpdateLog{
+		UID: log.UID,
+		ID:  log.ID,
+	}
+
+	wallet, err = a.blls.Walletbase.Subscribe(ctx, &bll.SpendInput{
+		UID:         sess.UserID,
+		Amount:      total,
+		Payee:       &code.Payee,
+		SubPayee:    code.SubPayee,
+		Description: bll.LogActionGiftPurchase,
+		Payload:     payload,
+	})
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	now := time.Now().Unix()
+	batch := &bll.GiftBatch{
+		ID:        wallet.Txn,
+		UID:       sess.UserID,
+		Kind:      code.Kind,
+		GID:       code.GID,
+		CID:       code.CID,
+		Title:     output.Title,
+		Period:    code.Period,
+		Duration:  code.Duration,
+		Amount:    code.Amount,
+		Codes:     make([]string, 0, input.Count),
+		ExpireAt:  now + bll.GiftCodeDuration,
+		CreatedAt: now,
+	}
+	for i := uint16(0); i < input.Count && err == nil; i++ {
+		var gift string
+		gift, err = util.EncodeEncrypt0(a.blls.Encryptor, GiftCode{
+			ID:       util.NewID(),
+			Batch:    batch.ID,
+			Kind:     batch.Kind,
+			GID:      batch.GID,
+			CID:      batch.CID,
+			Duration: batch.Duration,
+			ExpireAt: batch.ExpireAt,
+		}, []byte("GiftCode"))
+		batch.Codes = append(batch.Codes, gift)
 	}
 
+	txn := &bll.TransactionPK{
+		UID: sess.UserID,
+		ID:  wallet.Txn,
+	}
+	if err == nil {
+		err = a.blls.Gift.SaveBatch(ctx, batch)
+	}
 	if err == nil {
 		auditLog.Status = 1
 		err = a.blls.Walletbase.CommitTxn(ctx, txn)
@@ -311,5 +648,114 @@ func (a *Payment) PayByCode(ctx *gear.Context) error {
 	}
 
 	a.blls.Logbase.Update(ctx, auditLog)
+	return ctx.OkSend(bll.SuccessResponse[*bll.GiftBatchOutput]{Result: &bll.GiftBatchOutput{GiftBatch: *batch}})
+}
+
+func (a *Payment) GetGift(ctx *gear.Context) error {
+	input := &bll.QueryID{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	output, err := a.blls.Gift.GetBatch(ctx, sess.UserID, input.ID)
+	if err != nil {
+		return gear.ErrNotFound.From(err)
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.GiftBatchOutput]{Result: output})
+}
+
+func (a *Payment) RedeemGift(ctx *gear.Context) error {
+	input := &PaymentInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	gift, err := util.DecodeEncrypt0[GiftCode](a.blls.Encryptor, input.Code, []byte("GiftCode"))
+	if err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+	now := time.Now().Unix()
+	if gift.ExpireAt < now {
+		return gear.ErrBadRequest.WithMsg("gift code expired")
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	ok, err := a.blls.Gift.Redeem(ctx, gift.ID, sess.UserID, gift.ExpireAt)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	if !ok {
+		return gear.ErrConflict.WithMsg("gift code already redeemed")
+	}
+
+	subscription, _ := a.blls.Renewal.GetSubscription(ctx, gift.Kind, sess.UserID, gift.CID)
+	subscriptionInput := &bll.SubscriptionInput{
+		UID:      sess.UserID,
+		CID:      gift.CID,
+		Txn:      gift.Batch,
+		ExpireAt: now + gift.Duration,
+	}
+	if subscription != nil {
+		subscriptionInput.UpdatedAt = subscription.UpdatedAt
+		if subscription.ExpireAt > now {
+			subscriptionInput.ExpireAt = subscription.ExpireAt + gift.Duration
+		}
+	}
+
+	log, err := a.blls.Logbase.Log(ctx, bll.LogActionGiftRedeem, 0, gift.GID, gift)
+	if err != nil {
+		_ = a.blls.Gift.Release(ctx, gift.ID)
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	auditLog := &bll.UpdateLog{
+		UID: log.UID,
+		ID:  log.ID,
+	}
+
+	subscription, err = a.blls.Renewal.UpdateSubscription(ctx, gift.Kind, subscriptionInput)
+	if err != nil {
+		auditLog.Status = -1
+		auditLog.Error = util.Ptr(err.Error())
+		_ = a.blls.Gift.Release(ctx, gift.ID)
+		a.blls.Logbase.Update(ctx, auditLog)
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	auditLog.Status = 1
+	if err = a.blls.Gift.Redeemed(ctx, gift.Batch, gift.ExpireAt); err != nil {
+		logging.SetTo(ctx, "giftError", err.Error())
+	}
+	a.blls.Logbase.Update(ctx, auditLog)
+	a.blls.Statistic.Incr(gift.GID, gift.CID, bll.StatisticSubscriptions, 1)
 	return ctx.OkSend(bll.SuccessResponse[*bll.SubscriptionOutput]{Result: subscription})
 }
+
+// RevokeCode revokes the given payment code, or all outstanding codes of the user.
+func (a *Payment) RevokeCode(ctx *gear.Context) error {
+	input := &RevokeCodeInput{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if input.Code == "" {
+		if err := a.blls.PayCode.RevokeAll(ctx, sess.UserID); err != nil {
+			return gear.ErrInternalServerError.From(err)
+		}
+		return ctx.OkSend(bll.SuccessResponse[bool]{Result: true})
+	}
+
+	code, err := util.DecodeEncrypt0[PaymentCode](a.blls.Encryptor, input.Code, []byte("PaymentCode"))
+	if err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+	if code.UID != sess.UserID {
+		return gear.ErrForbidden.WithMsg("no permission")
+	}
+	if err = a.blls.PayCode.Revoke(ctx, code.Nonce); err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.OkSend(bll.SuccessResponse[bool]{Result: true})
+}
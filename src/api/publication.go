@@ -29,10 +29,13 @@ type EstimateOutput struct {
 }
 
 type ModelCost struct {
-	ID    string  `json:"id" cbor:"id"`
-	Name  string  `json:"name" cbor:"name"`
-	Price float64 `json:"price" cbor:"price"`
-	Cost  int64   `json:"cost" cbor:"cost"`
+	ID        string              `json:"id" cbor:"id"`
+	Name      string              `json:"name" cbor:"name"`
+	Price     float64             `json:"price" cbor:"price"`
+	Cost      int64               `json:"cost" cbor:"cost"`
+	MinCost   int64               `json:"min_cost" cbor:"min_cost"`
+	MaxCost   int64               `json:"max_cost" cbor:"max_cost"`
+	Estimated *bll.TokensEstimate `json:"estimated" cbor:"estimated"` // tokens with confidence range
 }
 
 func (a *Publication) Estimate(ctx *gear.Context) error {
@@ -67,23 +70,27 @@ func (a *Publication) Estimate(ctx *gear.Context) error {
 		return gear.ErrInternalServerError.From(err)
 	}
 
-	tokens := a.blls.Jarvis.EstimateTranslatingTokens(trans, input.Language, toLang)
 	output := &EstimateOutput{
 		Balance: wallet.Balance(),
-		Tokens:  tokens,
 		Models:  make(map[string]ModelCost, len(bll.AIModels)),
 	}
 
-	models := bll.AIModels
-	if wallet.Level < 2 {
-		models = []bll.AIModel{bll.DefaultModel}
-	}
-	for _, md := range models {
+	for _, md := range bll.AIModels {
+		if md.Allow(wallet.Level, toLang) != nil {
+			continue
+		}
+		estimate := a.blls.Estimator.Estimate(ctx, &md, trans, input.Language, toLang)
+		if md.ID == bll.DefaultModel.ID {
+			output.Tokens = estimate.Tokens
+		}
 		output.Models[md.ID] = ModelCost{
-			ID:    md.ID,
-			Name:  md.Name,
-			Price: md.Price,
-			Cost:  md.CostWEN(tokens),
+			ID:        md.ID,
+			Name:      md.Name,
+			Price:     md.Price,
+			Cost:      md.CostWEN(estimate.Tokens),
+			MinCost:   md.CostWEN(estimate.Min),
+			MaxCost:   md.CostWEN(estimate.Max),
+			Estimated: estimate,
 		}
 	}
 
@@ -96,7 +103,10 @@ func (a *Publication) Create(ctx *gear.Context) error {
 		return err
 	}
 
-	model := bll.GetAIModel(input.Model)
+	model, err := bll.GetAIModel(input.Model)
+	if err != nil {
+		return err
+	}
 	input.Model = model.ID
 
 	if input.ToGID == nil {
@@ -125,8 +135,8 @@ func (a *Publication) Create(ctx *gear.Context) error {
 		return gear.ErrPaymentRequired.WithMsg("insufficient balance")
 	}
 
-	if wallet.Level < 2 && input.Model != bll.DefaultModel.ID {
-		return gear.ErrBadRequest.WithMsgf("model %q is not allowed for user level < 2", input.Model)
+	if err := model.Allow(wallet.Level, *input.ToLanguage); err != nil {
+		return err
 	}
 
 	src, err := a.tryReadOne(ctx, &bll.ImplicitQueryPublication{
@@ -157,13 +167,26 @@ func (a *Publication) Create(ctx *gear.Context) error {
 		return gear.ErrInternalServerError.From(err)
 	}
 
-	if tokens := util.Tiktokens(trans); tokens > util.MAX_TOKENS {
+	tokens := util.Tiktokens(trans)
+	if tokens > util.MAX_DOCUMENT_TOKENS {
 		return gear.ErrUnprocessableEntity.WithMsgf("too many tokens: %d, expected <= %d",
-			tokens, util.MAX_TOKENS)
+			tokens, util.MAX_DOCUMENT_TOKENS)
+	}
+
+	// long documents are translated in chunks
+	var chunks []content.TEContents
+	if tokens > util.MAX_TOKENS {
+		chunks = teContents.Split(util.MAX_CHUNK_TOKENS)
+		for _, chunk := range chunks {
+			if t := chunk.Tokens(); t > util.MAX_TOKENS {
+				return gear.ErrUnprocessableEntity.WithMsgf("too many tokens in a section: %d, expected <= %d",
+					t, util.MAX_TOKENS)
+			}
+		}
 	}
 
-	tokens := a.blls.Jarvis.EstimateTranslatingTokens(trans, input.Language, *input.ToLanguage)
-	estimate_cost := model.CostWEN(tokens)
+	estimate := a.blls.Estimator.Estimate(ctx, &model, trans, input.Language, *input.ToLanguage)
+	estimate_cost := model.CostWEN(estimate.Tokens)
 	if b := wallet.Balance(); b < estimate_cost {
 		return gear.ErrPaymentRequired.WithMsgf("insufficient balance, expected %d, got %d", estimate_cost, b)
 	}
@@ -194,11 +217,25 @@ func (a *Publication) Create(ctx *gear.Context) error {
 
 	gctx := middleware.WithGlobalCtx(ctx)
 	key := fmt.Sprintf("CP:%s:%s:%s:%d", input.ToGID.String(), input.CID.String(), *input.ToLanguage, input.Version)
-	locker, err := a.blls.Locker.Lock(gctx, key, 20*60*time.Second)
+	locker, err := a.blls.Locker.Lock(gctx, key, time.Duration(max(len(chunks), 1))*20*60*time.Second)
 	if err != nil {
 		return gear.ErrLocked.From(err)
 	}
 
+	hold, err := a.blls.Walletbase.Hold(ctx, sess.UserID, &bll.SpendPayload{
+		GID:      *input.ToGID,
+		CID:      &src.CID,
+		Action:   bll.LogActionPublicationCreate,
+		Language: *input.ToLanguage,
+		Version:  src.Version,
+		Model:    model.ID,
+		Tokens:   estimate.Tokens,
+	})
+	if err != nil {
+		locker.Release(gctx)
+		return gear.ErrInternalServerError.From(err)
+	}
+
 	payload := &bll.LogPayload{
 		GID:      *input.ToGID,
 		CID:      src.CID,
@@ -206,9 +243,13 @@ func (a *Publication) Create(ctx *gear.Context) error {
 		Version:  &src.Version,
 		Kind:     util.Ptr(int8(1)),
 	}
+	if len(chunks) > 1 {
+		payload.Chunks = util.Ptr(uint16(len(chunks)))
+	}
 
 	log, err := a.blls.Logbase.Log(ctx, bll.LogActionPublicationCreate, 0, payload.GID, payload)
 	if err != nil {
+		_ = a.blls.Walletbase.Release(gctx, hold)
 		locker.Release(gctx)
 		return gear.ErrInternalServerError.From(err)
 	}
@@ -224,7 +265,7 @@ func (a *Publication) Create(ctx *gear.Context) error {
 		defer locker.Release(gctx)
 
 		now := time.Now()
-		teOutput, err := a.blls.Jarvis.Translate(gctx, &bll.TEInput{
+		teInput := &bll.TEInput{
 			GID:          *input.ToGID,
 			CID:          src.CID,
 			Language:     *input.ToLanguage,
@@ -233,47 +274,89 @@ func (a *Publication) Create(ctx *gear.Context) error {
 			Context:      input.Context,
 			Model:        util.Ptr(input.Model),
 			Content:      util.Ptr(util.Bytes(teData)),
-		})
+		}
+
+		var err error
+		var teOutput *bll.TranslatingOutput
+		if len(chunks) > 1 {
+			teOutput, err = a.blls.Jarvis.TranslateChunks(gctx, teInput, chunks, func(done int) {
+				// update the job progress
+				payload.Chunk = util.Ptr(uint16(done))
+				if data, er := util.Marshal(payload); er == nil {
+					_, _ = a.blls.Logbase.Update(gctx, &bll.UpdateLog{UID: log.UID, ID: log.ID, Payload: &data})
+				}
+			})
+		} else {
+			teOutput, err = a.blls.Jarvis.Translate(gctx, teInput)
+		}
 
 		var draft *bll.PublicationDraft
-		if err == nil {
+		var quality *content.QualityReport
+		var review *bll.TaskOutput
+		if err != nil {
+			_ = a.blls.Walletbase.Release(gctx, hold)
+		} else {
 			auditLog.Tokens = util.Ptr(teOutput.Tokens)
+			_ = a.blls.Estimator.Record(gctx, model.ID, input.Language, *input.ToLanguage, estimate.Base, teOutput.Tokens)
 
-			exp := bll.SpendPayload{
-				GID:      *input.ToGID,
-				CID:      &src.CID,
-				Action:   bll.LogActionPublicationCreate,
-				Language: *input.ToLanguage,
-				Version:  src.Version,
-				Model:    model.ID,
-				Price:    model.Price,
-				Tokens:   teOutput.Tokens,
-			}
-
-			wallet, err = a.blls.Walletbase.Spend(gctx, sess.UserID, &exp)
+			draft, err = src.IntoPublicationDraft(payload.GID, *payload.Language, input.Model, teOutput.Content)
 			if err == nil {
-				txn := &bll.TransactionPK{
-					UID: sess.UserID,
-					ID:  wallet.Txn,
+				var dstContents content.TEContents
+				if er := cbor.Unmarshal(teOutput.Content, &dstContents); er == nil {
+					quality = content.CheckTranslation(teContents, dstContents)
 				}
 
-				draft, err = src.IntoPublicationDraft(payload.GID, *payload.Language, input.Model, teOutput.Content)
-				if err == nil {
-					_, err = a.blls.Writing.CreatePublication(gctx, &bll.CreatePublication{
-						GID:      src.GID,
-						CID:      src.CID,
-						Language: src.Language,
-						Version:  src.Version,
-						Draft:    draft,
+				if quality != nil && !quality.Passed() {
+					// hold the publication at status 0 for a review by the
+					// group owners or reviewers, not the requester
+					task := &bll.CreateTaskInput{
+						UID:       sess.UserID,
+						GID:       payload.GID,
+						Kind:      "publication.review",
+						Threshold: 1,
+						Approvers: make([]util.ID, 0),
+						Assignees: []util.ID{},
+						GroupRole: util.Ptr(int8(2)),
+						Message: fmt.Sprintf("translation quality score %.2f is lower than %.2f, %d issues found",
+							quality.Score, content.MinQualityScore, len(quality.Issues)),
+					}
+					for _, id := range conf.Current().Reviewers {
+						if id != sess.UserID {
+							task.Approvers = append(task.Approvers, id)
+						}
+					}
+					review, err = a.blls.Taskbase.CreateTask(gctx, task, &bll.ReviewTaskPayload{
+						LogPayload: *payload,
+						Quality:    quality,
 					})
+				}
+			}
 
-					if err == nil {
-						err = a.blls.Walletbase.CommitTxn(gctx, txn)
-					}
+			if err == nil {
+				create := &bll.CreatePublication{
+					GID:      src.GID,
+					CID:      src.CID,
+					Language: src.Language,
+					Version:  src.Version,
+					Draft:    draft,
+				}
+				if review != nil {
+					create.Review = &review.ID
 				}
+				_, err = a.blls.Writing.CreatePublication(gctx, create)
+			}
 
-				if err != nil {
-					_ = a.blls.Walletbase.CancelTxn(gctx, txn)
+			if err == nil {
+				err = a.blls.Walletbase.Settle(gctx, hold, teOutput.Tokens)
+			} else {
+				_ = a.blls.Walletbase.Release(gctx, hold)
+			}
+
+			if err == nil {
+				// record the transaction for refund
+				payload.Txn = &hold.ID
+				if data, er := util.Marshal(payload); er == nil {
+					auditLog.Payload = &data
 				}
 			}
 		}
@@ -299,15 +382,26 @@ func (a *Publication) Create(ctx *gear.Context) error {
 		} else {
 			auditLog.Status = 1
 			log["cost"] = model.CostWEN(*auditLog.Tokens)
+			a.blls.Statistic.Incr(src.GID, src.CID, bll.StatisticTranslations, 1)
 
-			go a.blls.Taskbase.Create(gctx, &bll.CreateTaskInput{
-				UID:       sess.UserID,
-				GID:       payload.GID,
-				Kind:      "publication.review",
-				Threshold: 2,
-				Approvers: []util.ID{util.JARVIS},
-				Assignees: []util.ID{},
-			}, payload)
+			if quality != nil {
+				log["quality"] = quality.Score
+			}
+			if review != nil {
+				log["review"] = review.ID.String()
+			} else {
+				go a.blls.Taskbase.Create(gctx, &bll.CreateTaskInput{
+					UID:       sess.UserID,
+					GID:       payload.GID,
+					Kind:      "publication.review",
+					Threshold: 2,
+					Approvers: []util.ID{util.JARVIS},
+					Assignees: []util.ID{},
+				}, &bll.ReviewTaskPayload{
+					LogPayload: *payload,
+					Quality:    quality,
+				})
+			}
 		}
 
 		go a.blls.Logbase.Update(gctx, auditLog)
@@ -328,14 +422,26 @@ func (a *Publication) Get(ctx *gear.Context) error {
 
 	now := time.Now().Unix()
 	subscription_in := &util.ZeroID
-	subtoken, err := util.DecodeMac0[SubscriptionToken](a.blls.MACer, input.SubToken, []byte("SubscriptionToken"))
+	var member *util.ID
+	subtoken, err := decodeSubToken(a.blls, input.SubToken)
 	if err == nil && subtoken.ExpireAt >= now {
-		// fast API calling with subtoken
-		subscription_in = &subtoken.GID
-		if input.Parent != nil && *input.Parent != subtoken.CID {
-			return gear.ErrBadRequest.WithMsg("invalid parent")
+		switch subtoken.Kind {
+		case 3:
+			// group membership unlocks all content of the group
+			if input.GID != nil && *input.GID != subtoken.GID {
+				return gear.ErrBadRequest.WithMsg("invalid gid")
+			}
+			input.GID = &subtoken.GID
+			member = &subtoken.GID
+			subscription_in = nil
+		default:
+			// fast API calling with subtoken
+			subscription_in = &subtoken.GID
+			if input.Parent != nil && *input.Parent != subtoken.CID {
+				return gear.ErrBadRequest.WithMsg("invalid parent")
+			}
+			input.Parent = &subtoken.CID
 		}
-		input.Parent = &subtoken.CID
 	}
 
 	sess := gear.CtxValue[middleware.Session](ctx)
@@ -354,15 +460,28 @@ func (a *Publication) Get(ctx *gear.Context) error {
 			err = gear.ErrForbidden.WithMsg("no permission")
 		}
 	} else {
-		if role < -1 {
+		if role < -1 && member == nil {
 			input.GID = nil
 		}
 		output, err = a.blls.Writing.ImplicitGetPublication(ctx, input, subscription_in)
+		// a member token reads the group's content without the role
+		if err == nil && role < -1 && member != nil && *output.Status < 2 {
+			err = gear.ErrForbidden.WithMsg("no permission")
+		}
 	}
 
 	if err != nil {
 		return gear.ErrBadRequest.From(err)
 	}
+	if member != nil && output.GID != *member {
+		return gear.ErrForbidden.WithMsg("no permission")
+	}
+
+	reader := ctx.IP().String()
+	if sess.UserID.Compare(util.MinID) > 0 {
+		reader = sess.UserID.String()
+	}
+	a.blls.Statistic.View(output.GID, output.CID, reader)
 
 	result := bll.PublicationOutputs{*output}
 	result.LoadGroups(func(ids ...util.ID) []bll.GroupInfo {
@@ -372,6 +491,52 @@ func (a *Publication) Get(ctx *gear.Context) error {
 	return ctx.OkSend(bll.SuccessResponse[*bll.PublicationOutput]{Result: &result[0]})
 }
 
+type PublicationStatisticOutput struct {
+	bll.StatisticCounters
+	Daily []bll.StatisticDaily `json:"daily,omitempty" cbor:"daily,omitempty"` // only for group owners
+}
+
+func (a *Publication) GetStatistic(ctx *gear.Context) error {
+	input := &bll.QueryStatistic{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	counters, err := a.blls.Statistic.PublicationCounters(ctx, input.CID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	output := &PublicationStatisticOutput{StatisticCounters: *counters}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if input.Days > 0 && sess.UserID.Compare(util.MinID) > 0 {
+		if role, _ := a.blls.Userbase.UserGroupRole(ctx, sess.UserID, input.GID); role >= 1 {
+			// the publication should belong to the group
+			list, err := a.blls.Writing.GetPublicationList(ctx, 0, &bll.QueryGidCid{GID: input.GID, CID: input.CID})
+			if err != nil {
+				return gear.ErrInternalServerError.From(err)
+			}
+			owned := false
+			for _, p := range list.Result {
+				if p.GID == input.GID {
+					owned = true
+					break
+				}
+			}
+			if !owned {
+				return gear.ErrForbidden.WithMsg("publication not in the group")
+			}
+
+			output.Daily, err = a.blls.Statistic.PublicationDaily(ctx, input.CID, input.Days)
+			if err != nil {
+				return gear.ErrInternalServerError.From(err)
+			}
+		}
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[*PublicationStatisticOutput]{Result: output})
+}
+
 func (a *Publication) GetByJob(ctx *gear.Context) error {
 	input := &bll.QueryJob{}
 	if err := ctx.ParseURL(input); err != nil {
@@ -431,7 +596,7 @@ func (a *Publication) GetByJob(ctx *gear.Context) error {
 					return er
 				}
 			} else if res != nil {
-				progress = res.Progress
+				progress = p.Progress(res.Progress)
 			}
 		}
 
@@ -532,7 +697,7 @@ func (a *Publication) ListJob(ctx *gear.Context) error {
 				if err != nil {
 					continue
 				}
-				job.Progress = res.Progress
+				job.Progress = p.Progress(res.Progress)
 			}
 		}
 
@@ -673,8 +838,9 @@ func (a *Publication) List(ctx *gear.Context) error {
 
 func (a *Publication) Recommendations(ctx *gear.Context) error {
 	sess := gear.CtxValue[middleware.Session](ctx)
-	res := make([]*bll.PublicationOutput, 0, len(a.blls.Writing.Recommendations))
-	for _, rr := range a.blls.Writing.Recommendations {
+	recommendations := a.blls.Writing.Recommendations()
+	res := make([]*bll.PublicationOutput, 0, len(recommendations))
+	for _, rr := range recommendations {
 		if r := rr.PreferVersion(sess.Lang); r != nil {
 			res = append(res, r)
 		}
@@ -882,6 +1048,14 @@ func (a *Publication) Publish(ctx *gear.Context) error {
 	}
 
 	if status == 0 {
+		task, err := a.blls.Taskbase.PublicationHold(ctx, publication)
+		if err != nil {
+			return gear.ErrInternalServerError.From(err)
+		}
+		if task != nil {
+			return gear.ErrForbidden.WithMsgf("publication is held for quality review, task %s", task.ID.String())
+		}
+
 		input.Status = 1
 		output, err := a.blls.Writing.UpdatePublicationStatus(ctx, input)
 		if err != nil {
@@ -985,6 +1159,7 @@ func (a *Publication) Bookmark(ctx *gear.Context) error {
 	if err != nil {
 		return gear.ErrInternalServerError.From(err)
 	}
+	a.blls.Statistic.Incr(input.GID, input.CID, bll.StatisticBookmarks, 1)
 
 	sess := gear.CtxValue[middleware.Session](ctx)
 	if _, err = a.blls.Logbase.Log(ctx, bll.LogActionUserBookmark, 1, sess.UserID, &bll.LogPayload{
@@ -1075,7 +1250,7 @@ func (a *Publication) checkWritePermission(ctx *gear.Context, gid, cid util.ID,
 		CID:      cid,
 		Language: language,
 		Version:  version,
-		Fields:   "status,creator,updated_at",
+		Fields:   "status,creator,updated_at,review",
 	}, nil)
 
 	if err != nil {
@@ -18,32 +18,40 @@ func init() {
 // APIs ..
 type APIs struct {
 	Healthz     *Healthz
+	Admin       *Admin
 	Bookmark    *Bookmark
 	Collection  *Collection
 	Creation    *Creation
 	Group       *Group
 	Jarvis      *Jarvis
+	Keys        *Keys
 	Log         *Log
 	Message     *Message
 	Payment     *Payment
 	Publication *Publication
 	Scraping    *Scraping
+	Translation *TranslationRequest
+	Wallet      *Wallet
 	Wechat      *Wechat
 }
 
 func newAPIs(blls *bll.Blls) *APIs {
 	return &APIs{
 		Healthz:     &Healthz{blls},
+		Admin:       &Admin{blls},
 		Bookmark:    &Bookmark{blls},
 		Collection:  &Collection{blls},
 		Creation:    &Creation{blls},
 		Group:       &Group{blls},
 		Jarvis:      &Jarvis{blls},
+		Keys:        &Keys{blls},
 		Log:         &Log{blls},
 		Message:     &Message{blls},
 		Payment:     &Payment{blls},
 		Publication: &Publication{blls},
 		Scraping:    &Scraping{blls},
+		Translation: &TranslationRequest{blls},
+		Wallet:      &Wallet{blls},
 		Wechat:      &Wechat{blls},
 	}
 }
@@ -68,6 +76,8 @@ func newRouters(apis *APIs) []*gear.Router {
 	})
 
 	router.Get("/healthz", apis.Healthz.Get)
+	router.Get("/.well-known/jwks.json", apis.Keys.JWKS)
+	router.Get("/.well-known/cose-keys", apis.Keys.COSEKeys)
 
 	// 允许匿名访问
 	router.Get("/languages", middleware.AuthAllowAnon.Auth, apis.Jarvis.ListLanguages)
@@ -78,6 +88,7 @@ func newRouters(apis *APIs) []*gear.Router {
 	router.Get("/v1/publication", middleware.AuthAllowAnon.Auth, apis.Publication.Get)
 	router.Get("/v1/publication/recommendations", middleware.AuthAllowAnon.Auth, apis.Publication.Recommendations)
 	router.Get("/v1/publication/publish", middleware.AuthAllowAnon.Auth, apis.Publication.GetPublishList)
+	router.Get("/v1/publication/statistic", middleware.AuthAllowAnon.Auth, apis.Publication.GetStatistic)
 	router.Get("/v1/publication/list_published", middleware.AuthAllowAnon.Auth, apis.Publication.ListPublished)
 	router.Post("/v1/publication/list_published", middleware.AuthAllowAnon.Auth, apis.Publication.ListPublished)
 	router.Get("/v1/publication/list", middleware.AuthAllowAnon.Auth, apis.Publication.List)  // 匿名时等价于 list_published
@@ -92,6 +103,7 @@ func newRouters(apis *APIs) []*gear.Router {
 	router.Post("/v1/collection/list_children", middleware.AuthAllowAnon.Auth, apis.Collection.ListChildren)
 	router.Get("/v1/group/info", middleware.AuthAllowAnon.Auth, apis.Group.GetInfo)
 	router.Get("/v1/group/statistic", middleware.AuthAllowAnon.Auth, apis.Group.GetStatistic)
+	router.Get("/v1/group/membership", middleware.AuthAllowAnon.Auth, apis.Group.GetMembership)
 
 	router.Post("/v1/wechat/jsapi_ticket", middleware.AuthAllowAnon.Auth, apis.Wechat.JsapiTicket)
 
@@ -117,6 +129,9 @@ func newRouters(apis *APIs) []*gear.Router {
 	router.Patch("/v1/creation/review", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), todo)  // 暂不实现
 	router.Patch("/v1/creation/approve", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), todo) // 暂不实现
 	router.Post("/v1/creation/release", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Creation.Release)
+	router.Post("/v1/creation/summarize", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Creation.Summarize)
+	router.Get("/v1/creation/summarize/by_job", middleware.AuthToken.Auth, apis.Creation.GetSummarizeByJob)
+	router.Post("/v1/creation/summarize/apply", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Creation.ApplySummarize)
 	router.Put("/v1/creation/update_content", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Creation.UpdateContent)
 	router.Patch("/v1/creation/update_content", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), todo) // 暂不实现
 	router.Post("/v1/creation/assist", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), todo)          // 暂不实现
@@ -154,6 +169,8 @@ func newRouters(apis *APIs) []*gear.Router {
 	router.Post("/v1/collection/child", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Collection.AddChildren)
 	router.Patch("/v1/collection/child", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Collection.UpdateChild)
 	router.Delete("/v1/collection/child", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Collection.RemoveChild)
+	router.Post("/v1/collection/translate_children", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Collection.TranslateChildren)
+	router.Get("/v1/collection/translate_children/by_job", middleware.AuthToken.Auth, apis.Collection.GetTranslateChildrenByJob)
 	router.Post("/v1/collection/bookmark", middleware.AuthToken.Auth, apis.Collection.Bookmark)
 	router.Get("/v1/collection/upload", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Collection.UploadFile)
 
@@ -175,12 +192,44 @@ func newRouters(apis *APIs) []*gear.Router {
 	router.Post("/v1/group/list_my", middleware.AuthToken.Auth, apis.Group.ListMy)
 	router.Get("/v1/group/list_following", middleware.AuthToken.Auth, apis.Group.ListFollowing)
 	router.Post("/v1/group/list_following", middleware.AuthToken.Auth, apis.Group.ListFollowing)
-	router.Post("/v1/group/list_subscribing", middleware.AuthToken.Auth, todo) // 暂不实现
+	router.Get("/v1/group/list_subscribing", middleware.AuthToken.Auth, apis.Group.ListSubscribing)
+	router.Post("/v1/group/list_subscribing", middleware.AuthToken.Auth, apis.Group.ListSubscribing)
 	router.Patch("/v1/group", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Group.UpdateInfo)
+	router.Get("/v1/group/budget", middleware.AuthToken.Auth, apis.Group.GetBudget)
+	router.Put("/v1/group/budget", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Group.UpdateBudget)
+	router.Put("/v1/group/membership", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Group.UpdateMembership)
+	router.Get("/v1/group/coupons", middleware.AuthToken.Auth, apis.Group.ListCoupons)
+	router.Put("/v1/group/coupon", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Group.UpdateCoupon)
+	router.Delete("/v1/group/coupon", middleware.AuthToken.Auth, apis.Group.DeleteCoupon)
 	router.Get("/v1/group/upload_logo", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Group.UploadPicture)
 
+	router.Post("/v1/translation_request", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Translation.Create)
+	router.Get("/v1/translation_request", middleware.AuthToken.Auth, apis.Translation.Get)
+	router.Delete("/v1/translation_request", middleware.AuthToken.Auth, apis.Translation.Cancel)
+	router.Post("/v1/translation_request/list", middleware.AuthToken.Auth, apis.Translation.List)
+	router.Post("/v1/translation_request/claim", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Translation.Claim)
+	router.Post("/v1/translation_request/submit", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Translation.Submit)
+	router.Post("/v1/translation_request/reject", middleware.AuthToken.Auth, apis.Translation.Reject)
+	router.Post("/v1/translation_request/accept", middleware.AuthToken.Auth, apis.Translation.Accept)
+
 	router.Get("/v1/payment/code", middleware.AuthToken.Auth, apis.Payment.GetCode)
 	router.Post("/v1/payment/code", middleware.AuthToken.Auth, apis.Payment.PayByCode)
+	router.Delete("/v1/payment/code", middleware.AuthToken.Auth, apis.Payment.RevokeCode)
+	router.Get("/v1/payment/renewal", middleware.AuthToken.Auth, apis.Payment.GetRenewal)
+	router.Delete("/v1/payment/renewal", middleware.AuthToken.Auth, apis.Payment.CancelRenewal)
+	router.Get("/v1/payment/reminders", middleware.AuthToken.Auth, apis.Payment.ListReminders)
+	router.Get("/v1/payment/gift", middleware.AuthToken.Auth, apis.Payment.GetGift)
+	router.Post("/v1/payment/gift", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Payment.BuyGift)
+	router.Post("/v1/payment/gift/redeem", middleware.AuthToken.Auth, apis.Payment.RedeemGift)
+
+	router.Get("/v1/wallet", middleware.AuthToken.Auth, apis.Wallet.Get)
+	router.Get("/v1/wallet/transactions", middleware.AuthToken.Auth, apis.Wallet.ListTransactions)
+	router.Get("/v1/wallet/transaction", middleware.AuthToken.Auth, apis.Wallet.GetTransaction)
+	router.Get("/v1/wallet/revenue", middleware.AuthToken.Auth, apis.Wallet.Revenue)
+	router.Post("/v1/wallet/refund", middleware.AuthToken.Auth, middleware.CheckUserStatus(0), apis.Wallet.RequestRefund)
+	router.Post("/v1/wallet/refund/review", middleware.AuthToken.Auth, apis.Wallet.ReviewRefund)
+
+	router.Get("/v1/admin/config", middleware.AuthToken.Auth, apis.Admin.GetConfig)
 
 	router.Get("/v1/log/list_recently", middleware.AuthToken.Auth, apis.Log.ListRecently)
 
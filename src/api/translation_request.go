@@ -0,0 +1,601 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/bll"
+	"github.com/yiwen-ai/yiwen-api/src/logging"
+	"github.com/yiwen-ai/yiwen-api/src/middleware"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+// TranslationRequest is the marketplace of human translations: a group posts
+// a translation request with a bounty, a member claims it, translates in a
+// draft publication and submits it, the bounty is paid when accepted.
+type TranslationRequest struct {
+	blls *bll.Blls
+}
+
+type CreateTranslationRequestInput struct {
+	GID        util.ID `json:"gid" cbor:"gid" validate:"required"`
+	CID        util.ID `json:"cid" cbor:"cid" validate:"required"`
+	Language   string  `json:"language" cbor:"language" validate:"required"`
+	Version    uint16  `json:"version" cbor:"version" validate:"gte=1,lte=10000"`
+	ToLanguage string  `json:"to_language" cbor:"to_language" validate:"required"`
+	Bounty     int64   `json:"bounty" cbor:"bounty" validate:"gte=1,lte=1000000"`
+	Message    string  `json:"message" cbor:"message" validate:"lte=1024"`
+}
+
+func (i *CreateTranslationRequestInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+	if i.Language == i.ToLanguage {
+		return gear.ErrBadRequest.WithMsg("to_language is same as language")
+	}
+
+	return nil
+}
+
+type TranslationRequestActionInput struct {
+	GID     util.ID `json:"gid" cbor:"gid" query:"gid" validate:"required"`
+	ID      util.ID `json:"id" cbor:"id" query:"id" validate:"required"`
+	Message string  `json:"message,omitempty" cbor:"message,omitempty" validate:"lte=1024"`
+}
+
+func (i *TranslationRequestActionInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	return nil
+}
+
+func (a *TranslationRequest) Create(ctx *gear.Context) error {
+	input := &CreateTranslationRequestInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if _, err := a.checkRole(ctx, input.GID, 1); err != nil {
+		return err
+	}
+
+	src, err := a.blls.Writing.GetPublication(ctx, &bll.ImplicitQueryPublication{
+		GID:      &input.GID,
+		CID:      input.CID,
+		Language: input.Language,
+		Version:  input.Version,
+		Fields:   "status,updated_at",
+	}, nil)
+	if err != nil {
+		return gear.ErrNotFound.From(err)
+	}
+	if src.Status == nil || *src.Status < 0 {
+		return gear.ErrBadRequest.WithMsg("cannot translate publication, status is -1")
+	}
+
+	dst, _ := a.blls.Writing.GetPublication(ctx, &bll.ImplicitQueryPublication{
+		GID:      &input.GID,
+		CID:      input.CID,
+		Language: input.ToLanguage,
+		Version:  input.Version,
+		Fields:   "status",
+	}, nil)
+	if dst != nil && dst.Status != nil && *dst.Status >= 0 {
+		return gear.ErrConflict.WithMsgf("%s publication already exists", input.ToLanguage)
+	}
+
+	wallet, err := a.blls.Walletbase.Get(ctx, sess.UserID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	if b := wallet.Balance(); b < input.Bounty {
+		return gear.ErrPaymentRequired.WithMsgf("insufficient balance, expected %d, got %d", input.Bounty, b)
+	}
+
+	payload := &bll.TranslationRequestPayload{
+		GID:        input.GID,
+		CID:        input.CID,
+		Language:   input.Language,
+		Version:    input.Version,
+		ToLanguage: input.ToLanguage,
+		Bounty:     input.Bounty,
+		State:      bll.TranslationRequestOpen,
+	}
+	data, err := util.Marshal(payload)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	// hold the bounty until the request is accepted or cancelled
+	wallet, err = a.blls.Walletbase.HoldFunds(ctx, input.GID, &bll.SpendInput{
+		UID:         sess.UserID,
+		Amount:      input.Bounty,
+		Description: bll.LogActionTranslationRequest,
+		Payload:     data,
+	})
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	hold := &bll.TransactionPK{UID: sess.UserID, ID: wallet.Txn}
+	payload.Hold = &hold.ID
+
+	task, err := a.blls.Taskbase.CreateTask(ctx, &bll.CreateTaskInput{
+		UID:       sess.UserID,
+		GID:       input.GID,
+		Kind:      bll.TaskKindTranslationRequest,
+		Threshold: 1,
+		Approvers: []util.ID{sess.UserID},
+		Assignees: []util.ID{},
+		Message:   input.Message,
+	}, payload)
+	if err != nil {
+		_ = a.blls.Walletbase.CancelTxn(ctx, hold)
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	if _, err = a.blls.Logbase.Log(ctx, bll.LogActionTranslationRequest, 1, input.GID, payload); err != nil {
+		logging.SetTo(ctx, "writeLogError", err.Error())
+	}
+
+	output, err := bll.TranslationRequestFrom(task)
+	if err != nil {
+		return err
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.TranslationRequestOutput]{Result: output})
+}
+
+func (a *TranslationRequest) Get(ctx *gear.Context) error {
+	input := &bll.QueryGidID{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	if _, err := a.checkRole(ctx, input.GID, 0); err != nil {
+		return err
+	}
+
+	output, err := a.get(ctx, input.GID, input.ID)
+	if err != nil {
+		return err
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.TranslationRequestOutput]{Result: output})
+}
+
+func (a *TranslationRequest) List(ctx *gear.Context) error {
+	input := &bll.GIDPagination{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	if _, err := a.checkRole(ctx, input.GID, 0); err != nil {
+		return err
+	}
+
+	tasks, err := a.blls.Taskbase.ListByGID(ctx, &bll.ListTasksInput{
+		GID:       input.GID,
+		Kind:      bll.TaskKindTranslationRequest,
+		PageToken: input.PageToken,
+		PageSize:  input.PageSize,
+		Status:    input.Status,
+	})
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	output := make([]*bll.TranslationRequestOutput, 0, len(tasks.Result))
+	for i := range tasks.Result {
+		if item, err := bll.TranslationRequestFrom(&tasks.Result[i]); err == nil {
+			output = append(output, item)
+		}
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[[]*bll.TranslationRequestOutput]{
+		Result:        output,
+		NextPageToken: tasks.NextPageToken,
+	})
+}
+
+// Claim claims an open request, and creates a draft publication from the
+// source publication for translating.
+func (a *TranslationRequest) Claim(ctx *gear.Context) error {
+	input := &TranslationRequestActionInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if _, err := a.checkRole(ctx, input.GID, 0); err != nil {
+		return err
+	}
+
+	return a.transitAndSend(ctx, input, bll.TranslationRequestClaimed, func(req *bll.TranslationRequestOutput, update *bll.UpdateTaskInput) error {
+		if req.UID == sess.UserID {
+			return gear.ErrForbidden.WithMsg("cannot claim your own request")
+		}
+
+		src, err := a.blls.Writing.GetPublication(ctx, &bll.ImplicitQueryPublication{
+			GID:      &req.Request.GID,
+			CID:      req.Request.CID,
+			Language: req.Request.Language,
+			Version:  req.Request.Version,
+		}, nil)
+		if err != nil {
+			return gear.ErrNotFound.From(err)
+		}
+
+		teContents, err := src.ToTEContents()
+		if err != nil {
+			return err
+		}
+		teData, err := cbor.Marshal(teContents)
+		if err != nil {
+			return gear.ErrInternalServerError.From(err)
+		}
+
+		// the draft starts with the source texts, the claimer will translate it
+		draft, err := src.IntoPublicationDraft(req.GID, req.Request.ToLanguage, "human", teData)
+		if err != nil {
+			return err
+		}
+		if _, err = a.blls.Writing.CreatePublication(ctx, &bll.CreatePublication{
+			GID:      src.GID,
+			CID:      src.CID,
+			Language: src.Language,
+			Version:  src.Version,
+			Draft:    draft,
+		}); err != nil {
+			return gear.ErrInternalServerError.From(err)
+		}
+
+		req.Request.Claimer = &sess.UserID
+		req.Request.ClaimedAt = time.Now().Unix()
+		update.Assignees = &[]util.ID{sess.UserID}
+		return nil
+	})
+}
+
+// Submit submits the translated draft publication for acceptance.
+func (a *TranslationRequest) Submit(ctx *gear.Context) error {
+	input := &TranslationRequestActionInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	return a.transitAndSend(ctx, input, bll.TranslationRequestSubmitted, func(req *bll.TranslationRequestOutput, update *bll.UpdateTaskInput) error {
+		if req.Request.Claimer == nil || *req.Request.Claimer != sess.UserID {
+			return gear.ErrForbidden.WithMsg("no permission")
+		}
+
+		if _, err := a.draft(ctx, req); err != nil {
+			return err
+		}
+
+		req.Request.SubmittedAt = time.Now().Unix()
+		return nil
+	})
+}
+
+// Reject returns the submitted translation to the claimer for revision.
+func (a *TranslationRequest) Reject(ctx *gear.Context) error {
+	input := &TranslationRequestActionInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	return a.transitAndSend(ctx, input, bll.TranslationRequestClaimed, func(req *bll.TranslationRequestOutput, update *bll.UpdateTaskInput) error {
+		if err := a.checkReviewer(ctx, req); err != nil {
+			return err
+		}
+
+		if input.Message != "" {
+			update.Message = &input.Message
+		}
+		return nil
+	})
+}
+
+// Accept accepts the submitted translation, pays the bounty from the poster to
+// the claimer and marks the publication as reviewed. The accepted state is
+// written before paying, so accepting again retries the payment if failed.
+func (a *TranslationRequest) Accept(ctx *gear.Context) error {
+	input := &TranslationRequestActionInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	gctx := middleware.WithGlobalCtx(ctx)
+	locker, err := a.blls.Locker.Lock(gctx, translationRequestLockKey(input), 60*time.Second)
+	if err != nil {
+		return gear.ErrLocked.From(err)
+	}
+	defer locker.Release(gctx)
+
+	req, err := a.get(ctx, input.GID, input.ID)
+	if err != nil {
+		return err
+	}
+	if err := a.checkReviewer(ctx, req); err != nil {
+		return err
+	}
+
+	p := req.Request
+	if p.State != bll.TranslationRequestAccepted {
+		if err = p.Transit(bll.TranslationRequestAccepted); err != nil {
+			return err
+		}
+
+		dst, err := a.draft(ctx, req)
+		if err != nil {
+			return err
+		}
+		if *dst.Status == 0 {
+			if _, err = a.blls.Writing.UpdatePublicationStatus(ctx, &bll.UpdatePublicationStatusInput{
+				GID:       dst.GID,
+				CID:       dst.CID,
+				Language:  dst.Language,
+				Version:   dst.Version,
+				UpdatedAt: *dst.UpdatedAt,
+				Status:    1,
+			}); err != nil {
+				return gear.ErrInternalServerError.From(err)
+			}
+		}
+
+		if req, err = a.update(ctx, req, nil); err != nil {
+			return err
+		}
+	}
+
+	if req.Status != 1 {
+		if req, err = a.pay(ctx, req); err != nil {
+			return err
+		}
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.TranslationRequestOutput]{Result: req})
+}
+
+// pay pays the bounty of the accepted request, the transaction is recorded in
+// the request before committed, so that it is paid only once.
+// pay pays the bounty to the claimer. Every step is recorded in the request
+// before the next one, so a failed accepting can be retried from where it stopped.
+func (a *TranslationRequest) pay(ctx *gear.Context, req *bll.TranslationRequestOutput) (*bll.TranslationRequestOutput, error) {
+	p := req.Request
+	committed := false
+	if p.Txn != nil {
+		txn, err := a.blls.Walletbase.GetTxn(ctx, &bll.TransactionPK{UID: req.UID, ID: *p.Txn})
+		if err != nil {
+			return nil, gear.ErrInternalServerError.From(err)
+		}
+		switch {
+		case txn.Status < 0:
+			p.Txn = nil
+		case txn.Status == 1:
+			committed = true
+		}
+	}
+
+	if p.Txn == nil && p.Hold != nil {
+		// the balance is held by the bounty, release it before paying
+		hold := &bll.TransactionPK{UID: req.UID, ID: *p.Hold}
+		if err := a.blls.Walletbase.CancelTxn(ctx, hold); err != nil {
+			// the hold may be cancelled by a previous attempt
+			txn, er := a.blls.Walletbase.GetTxn(ctx, hold)
+			if er != nil || txn.Status >= 0 {
+				return nil, gear.ErrInternalServerError.From(err)
+			}
+		}
+
+		p.Hold = nil
+		var err error
+		if req, err = a.update(ctx, req, nil); err != nil {
+			return nil, err
+		}
+		p = req.Request
+	}
+
+	if p.Txn == nil {
+		data, err := util.Marshal(p)
+		if err != nil {
+			return nil, gear.ErrInternalServerError.From(err)
+		}
+		spend := &bll.SpendInput{
+			UID:         req.UID,
+			Amount:      p.Bounty,
+			Payee:       p.Claimer,
+			Description: bll.LogActionTranslationAccept,
+			Payload:     data,
+		}
+		wallet, err := a.blls.Walletbase.Pay(ctx, req.GID, spend)
+		if err != nil {
+			return nil, gear.ErrInternalServerError.From(err)
+		}
+
+		p.Txn = &wallet.Txn
+		if req, err = a.update(ctx, req, nil); err != nil {
+			_ = a.blls.Walletbase.CancelTxn(ctx, &bll.TransactionPK{UID: spend.UID, ID: wallet.Txn})
+			return nil, err
+		}
+		p = req.Request
+	}
+
+	if !committed {
+		if err := a.blls.Walletbase.CommitTxn(ctx, &bll.TransactionPK{UID: req.UID, ID: *p.Txn}); err != nil {
+			return nil, gear.ErrInternalServerError.From(err)
+		}
+	}
+
+	req, err := a.update(ctx, req, util.Ptr(int8(1)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = a.blls.Logbase.Log(ctx, bll.LogActionTranslationAccept, 1, req.GID, req.Request); err != nil {
+		logging.SetTo(ctx, "writeLogError", err.Error())
+	}
+	return req, nil
+}
+
+// Cancel cancels an open or claimed request and releases the bounty, the draft
+// publication is kept.
+func (a *TranslationRequest) Cancel(ctx *gear.Context) error {
+	input := &TranslationRequestActionInput{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	output, err := a.transit(ctx, input, bll.TranslationRequestCancelled, func(req *bll.TranslationRequestOutput, update *bll.UpdateTaskInput) error {
+		if err := a.checkPoster(ctx, req); err != nil {
+			return err
+		}
+
+		update.Status = util.Ptr(int8(-1))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if hold := output.Request.Hold; hold != nil {
+		if err := a.blls.Walletbase.CancelTxn(ctx, &bll.TransactionPK{UID: output.UID, ID: *hold}); err != nil {
+			logging.SetTo(ctx, "releaseHoldError", err.Error())
+		}
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.TranslationRequestOutput]{Result: output})
+}
+
+func (a *TranslationRequest) transitAndSend(ctx *gear.Context, input *TranslationRequestActionInput, to int8,
+	fn func(req *bll.TranslationRequestOutput, update *bll.UpdateTaskInput) error) error {
+	output, err := a.transit(ctx, input, to, fn)
+	if err != nil {
+		return err
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.TranslationRequestOutput]{Result: output})
+}
+
+// transit changes the state of the request with a lock, fn will be called
+// before the task updated to do the side effects of the state.
+func (a *TranslationRequest) transit(ctx *gear.Context, input *TranslationRequestActionInput, to int8,
+	fn func(req *bll.TranslationRequestOutput, update *bll.UpdateTaskInput) error) (*bll.TranslationRequestOutput, error) {
+	gctx := middleware.WithGlobalCtx(ctx)
+	locker, err := a.blls.Locker.Lock(gctx, translationRequestLockKey(input), 60*time.Second)
+	if err != nil {
+		return nil, gear.ErrLocked.From(err)
+	}
+	defer locker.Release(gctx)
+
+	req, err := a.get(ctx, input.GID, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err = req.Request.Transit(to); err != nil {
+		return nil, err
+	}
+
+	update := &bll.UpdateTaskInput{
+		GID:       req.GID,
+		ID:        req.ID,
+		UpdatedAt: req.UpdatedAt,
+	}
+	if err = fn(req, update); err != nil {
+		return nil, err
+	}
+	return a.updateTask(ctx, req, update)
+}
+
+// update writes the request payload with the task status if not nil.
+func (a *TranslationRequest) update(ctx *gear.Context, req *bll.TranslationRequestOutput, status *int8) (*bll.TranslationRequestOutput, error) {
+	return a.updateTask(ctx, req, &bll.UpdateTaskInput{
+		GID:       req.GID,
+		ID:        req.ID,
+		UpdatedAt: req.UpdatedAt,
+		Status:    status,
+	})
+}
+
+func (a *TranslationRequest) updateTask(ctx *gear.Context, req *bll.TranslationRequestOutput, update *bll.UpdateTaskInput) (*bll.TranslationRequestOutput, error) {
+	data, err := util.Marshal(req.Request)
+	if err != nil {
+		return nil, gear.ErrInternalServerError.From(err)
+	}
+	update.Payload = &data
+	task, err := a.blls.Taskbase.Update(ctx, update)
+	if err != nil {
+		return nil, gear.ErrInternalServerError.From(err)
+	}
+
+	return bll.TranslationRequestFrom(task)
+}
+
+func translationRequestLockKey(input *TranslationRequestActionInput) string {
+	return fmt.Sprintf("TR:%s:%s", input.GID.String(), input.ID.String())
+}
+
+func (a *TranslationRequest) get(ctx *gear.Context, gid, id util.ID) (*bll.TranslationRequestOutput, error) {
+	task, err := a.blls.Taskbase.Get(ctx, gid, id)
+	if err != nil {
+		return nil, gear.ErrNotFound.From(err)
+	}
+
+	return bll.TranslationRequestFrom(task)
+}
+
+// draft returns the draft publication of the request.
+func (a *TranslationRequest) draft(ctx *gear.Context, req *bll.TranslationRequestOutput) (*bll.PublicationOutput, error) {
+	dst, err := a.blls.Writing.GetPublication(ctx, &bll.ImplicitQueryPublication{
+		GID:      &req.GID,
+		CID:      req.Request.CID,
+		Language: req.Request.ToLanguage,
+		Version:  req.Request.Version,
+		Fields:   "status,creator,updated_at",
+	}, nil)
+	if err != nil {
+		return nil, gear.ErrNotFound.From(err)
+	}
+	if dst.Status == nil || dst.UpdatedAt == nil || *dst.Status < 0 {
+		return nil, gear.ErrBadRequest.WithMsg("invalid draft publication")
+	}
+
+	return dst, nil
+}
+
+func (a *TranslationRequest) checkRole(ctx *gear.Context, gid util.ID, min int8) (int8, error) {
+	sess := gear.CtxValue[middleware.Session](ctx)
+	role, err := a.blls.Userbase.UserGroupRole(ctx, sess.UserID, gid)
+	if err != nil {
+		return role, gear.ErrInternalServerError.From(err)
+	}
+	if role < min {
+		return role, gear.ErrForbidden.WithMsg("no permission")
+	}
+
+	return role, nil
+}
+
+// checkReviewer checks that the user can accept or reject the submitted
+// translation, the claimer can not review the translation of their own.
+func (a *TranslationRequest) checkReviewer(ctx *gear.Context, req *bll.TranslationRequestOutput) error {
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if req.Request.Claimer != nil && *req.Request.Claimer == sess.UserID {
+		return gear.ErrForbidden.WithMsg("cannot review your own translation")
+	}
+
+	return a.checkPoster(ctx, req)
+}
+
+// checkPoster checks that the user is the poster of the request or a group manager.
+func (a *TranslationRequest) checkPoster(ctx *gear.Context, req *bll.TranslationRequestOutput) error {
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if req.UID == sess.UserID {
+		return nil
+	}
+
+	_, err := a.checkRole(ctx, req.GID, 1)
+	return err
+}
@@ -0,0 +1,470 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/bll"
+	"github.com/yiwen-ai/yiwen-api/src/conf"
+	"github.com/yiwen-ai/yiwen-api/src/logging"
+	"github.com/yiwen-ai/yiwen-api/src/middleware"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+type Wallet struct {
+	blls *bll.Blls
+}
+
+type WalletInfo struct {
+	Balance int64  `json:"balance" cbor:"balance"`
+	Award   int64  `json:"award" cbor:"award"`
+	Topup   int64  `json:"topup" cbor:"topup"`
+	Income  int64  `json:"income" cbor:"income"`
+	Credits uint64 `json:"credits" cbor:"credits"`
+	Level   uint8  `json:"level" cbor:"level"`
+}
+
+func (a *Wallet) Get(ctx *gear.Context) error {
+	sess := gear.CtxValue[middleware.Session](ctx)
+	wallet, err := a.blls.Walletbase.Get(ctx, sess.UserID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[*WalletInfo]{Result: &WalletInfo{
+		Balance: wallet.Balance(),
+		Award:   wallet.Award,
+		Topup:   wallet.Topup,
+		Income:  wallet.Income,
+		Credits: wallet.Credits,
+		Level:   wallet.Level,
+	}})
+}
+
+type QueryTransactions struct {
+	PageToken *string `json:"page_token,omitempty" cbor:"page_token,omitempty" query:"page_token"`
+	PageSize  *uint16 `json:"page_size,omitempty" cbor:"page_size,omitempty" query:"page_size" validate:"omitempty,gte=5,lte=100"`
+	Kind      *string `json:"kind,omitempty" cbor:"kind,omitempty" query:"kind"`
+	Incoming  bool    `json:"incoming,omitempty" cbor:"incoming,omitempty" query:"incoming"` // list income instead of payment
+	pageToken *util.Bytes
+}
+
+func (i *QueryTransactions) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+	if i.PageToken != nil {
+		i.pageToken = &util.Bytes{}
+		if err := i.pageToken.UnmarshalText([]byte(*i.PageToken)); err != nil {
+			return gear.ErrBadRequest.From(err)
+		}
+	}
+
+	return nil
+}
+
+func (a *Wallet) ListTransactions(ctx *gear.Context) error {
+	input := &QueryTransactions{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	page := &bll.TransactionPagination{
+		UID:       sess.UserID,
+		PageToken: input.pageToken,
+		PageSize:  input.PageSize,
+		Kind:      input.Kind,
+	}
+
+	var output *bll.SuccessResponse[[]bll.TransactionOutput]
+	var err error
+	if input.Incoming {
+		output, err = a.blls.Walletbase.ListIncoming(ctx, page)
+	} else {
+		output, err = a.blls.Walletbase.ListOutgoing(ctx, page)
+	}
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	result := make([]bll.TransactionInfo, 0, len(output.Result))
+	for i := range output.Result {
+		result = append(result, output.Result[i].Info())
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[[]bll.TransactionInfo]{
+		NextPageToken: output.NextPageToken,
+		Result:        result,
+	})
+}
+
+func (a *Wallet) GetTransaction(ctx *gear.Context) error {
+	input := &bll.QueryID{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	output, err := a.blls.Walletbase.GetTxn(ctx, &bll.TransactionPK{
+		UID: sess.UserID,
+		ID:  input.ID,
+	})
+	if err != nil {
+		return gear.ErrNotFound.From(err)
+	}
+
+	return ctx.OkSend(bll.SuccessResponse[bll.TransactionInfo]{Result: output.Info()})
+}
+
+func (a *Wallet) Revenue(ctx *gear.Context) error {
+	input := &bll.QueryRevenue{}
+	if err := ctx.ParseURL(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	output, next, err := a.blls.Walletbase.Revenue(ctx, sess.UserID, input)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	if input.Format != "csv" {
+		return ctx.OkSend(bll.SuccessResponse[[]bll.RevenueItem]{Result: output, NextPageToken: next})
+	}
+
+	if len(next) > 0 {
+		// the result is truncated, query again with the token for older revenue
+		ctx.SetHeader("x-next-page-token", next.String())
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	_ = w.Write([]string{"period", "action", "cid", "kind", "gid", "sub_payee", "count", "amount", "sys_fee", "sub_shares", "net"})
+	for _, item := range output {
+		_ = w.Write([]string{
+			item.Period,
+			item.Action,
+			item.CID.String(),
+			strconv.Itoa(int(item.Kind)),
+			item.GID.String(),
+			item.SubPayee.String(),
+			strconv.FormatUint(uint64(item.Count), 10),
+			strconv.FormatInt(item.Amount, 10),
+			strconv.FormatInt(item.SysFee, 10),
+			strconv.FormatInt(item.SubShares, 10),
+			strconv.FormatInt(item.Net, 10),
+		})
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	ctx.SetHeader(gear.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="revenue-%s.csv"`, input.Period))
+	ctx.Type("text/csv; charset=utf-8")
+	return ctx.End(http.StatusOK, buf.Bytes())
+}
+
+type RefundInput struct {
+	Job    util.ID `json:"job" cbor:"job" validate:"required"`
+	Reason string  `json:"reason" cbor:"reason" validate:"required,gte=4,lte=1024"`
+}
+
+func (i *RefundInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	return nil
+}
+
+// RequestRefund opens a refund request for a publication.create job, it will be reviewed by reviewers.
+func (a *Wallet) RequestRefund(ctx *gear.Context) error {
+	input := &RefundInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	job, err := a.blls.Logbase.Get(ctx, sess.UserID, input.Job, "")
+	if err != nil {
+		return gear.ErrNotFound.WithMsgf("invalid job: %s", err.Error())
+	}
+	if job.Action != bll.LogActionPublicationCreate || job.Status != 1 {
+		return gear.ErrBadRequest.WithMsgf("job %s can not be refunded", job.ID.String())
+	}
+
+	p, err := util.Unmarshal[bll.LogPayload](job.Payload)
+	if err != nil || p.Txn == nil {
+		return gear.ErrBadRequest.WithMsg("no transaction in the job")
+	}
+
+	txn, err := a.blls.Walletbase.GetTxn(ctx, &bll.TransactionPK{UID: sess.UserID, ID: *p.Txn})
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	if txn.Status != 1 {
+		return gear.ErrBadRequest.WithMsg("transaction is not committed")
+	}
+
+	approvers := conf.Current().Reviewers
+	if len(approvers) == 0 {
+		return gear.ErrBadRequest.WithMsg("no reviewers for refund requests")
+	}
+
+	gctx := middleware.WithGlobalCtx(ctx)
+	locker, err := a.blls.Locker.Lock(gctx, "RF:"+job.ID.String(), 10*time.Second)
+	if err != nil {
+		return gear.ErrLocked.From(err)
+	}
+	defer locker.Release(gctx)
+
+	if p.Refund != nil {
+		refund, err := a.blls.Logbase.Get(ctx, sess.UserID, *p.Refund, "status")
+		if err != nil && gear.ErrInternalServerError.From(err).Code != 404 {
+			return gear.ErrInternalServerError.From(err)
+		}
+		// a rejected request can be requested again
+		if refund != nil && refund.Status >= 0 {
+			return gear.ErrConflict.WithMsgf("refund already requested: %s", p.Refund.String())
+		}
+	}
+
+	payload := &bll.RefundPayload{
+		Job:      job.ID,
+		Txn:      txn.ID,
+		GID:      p.GID,
+		CID:      p.CID,
+		Language: p.Language,
+		Version:  p.Version,
+		Amount:   txn.Amount,
+		Reason:   input.Reason,
+	}
+	log, err := a.blls.Logbase.Log(ctx, bll.LogActionUserRefund, 0, p.GID, payload)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	payload.Refund = &log.ID
+
+	// link the request to the job, so that the job is refunded only once
+	p.Refund = &log.ID
+	data, err := util.Marshal(p)
+	if err == nil {
+		_, err = a.blls.Logbase.Update(ctx, &bll.UpdateLog{
+			UID:     sess.UserID,
+			ID:      job.ID,
+			Status:  job.Status,
+			Payload: &data,
+		})
+	}
+	if err != nil {
+		_, _ = a.blls.Logbase.Update(ctx, &bll.UpdateLog{
+			UID:    sess.UserID,
+			ID:     log.ID,
+			Status: -1,
+			Error:  util.Ptr(err.Error()),
+		})
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	task, err := a.blls.Taskbase.CreateTask(ctx, &bll.CreateTaskInput{
+		UID:       sess.UserID,
+		GID:       p.GID,
+		Kind:      "user.refund",
+		Threshold: 1,
+		Approvers: approvers,
+		Assignees: []util.ID{},
+		Message:   input.Reason,
+	}, payload)
+	if err != nil {
+		logging.SetTo(ctx, "createTaskError", err.Error())
+	} else {
+		// keep the task id in the request, so that the task is resolved on review
+		payload.Task = &task.ID
+		data, err := util.Marshal(payload)
+		if err == nil {
+			_, err = a.blls.Logbase.Update(ctx, &bll.UpdateLog{
+				UID:     sess.UserID,
+				ID:      log.ID,
+				Status:  0,
+				Payload: &data,
+			})
+		}
+		if err != nil {
+			logging.SetTo(ctx, "writeLogError", err.Error())
+		}
+	}
+
+	return ctx.Send(http.StatusAccepted, bll.SuccessResponse[*bll.RefundPayload]{
+		Job:    log.ID.String(),
+		Result: payload,
+	})
+}
+
+type ReviewRefundInput struct {
+	UID      util.ID `json:"uid" cbor:"uid" validate:"required"` // the user who requested refund
+	ID       util.ID `json:"id" cbor:"id" validate:"required"`   // the refund request log id
+	Approved bool    `json:"approved" cbor:"approved"`
+	Message  string  `json:"message,omitempty" cbor:"message,omitempty" validate:"lte=1024"`
+}
+
+func (i *ReviewRefundInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	return nil
+}
+
+// ReviewRefund approves or rejects a refund request, only for reviewers.
+func (a *Wallet) ReviewRefund(ctx *gear.Context) error {
+	input := &ReviewRefundInput{}
+	if err := ctx.ParseBody(input); err != nil {
+		return err
+	}
+
+	sess := gear.CtxValue[middleware.Session](ctx)
+	if !util.SliceHas(conf.Current().Reviewers, sess.UserID) {
+		return gear.ErrForbidden.WithMsg("no permission")
+	}
+	if input.UID == sess.UserID {
+		return gear.ErrForbidden.WithMsg("can not review your own refund request")
+	}
+
+	gctx := middleware.WithGlobalCtx(ctx)
+	locker, err := a.blls.Locker.Lock(gctx, "RF:"+input.ID.String(), 60*time.Second)
+	if err != nil {
+		return gear.ErrLocked.From(err)
+	}
+	defer locker.Release(gctx)
+
+	refund, err := a.blls.Logbase.Get(ctx, input.UID, input.ID, "")
+	if err != nil {
+		return gear.ErrNotFound.From(err)
+	}
+	if refund.Action != bll.LogActionUserRefund {
+		return gear.ErrBadRequest.WithMsgf("invalid refund request: %s", refund.Action)
+	}
+	processing := false
+	switch refund.Status {
+	case 0:
+	case refundProcessing:
+		processing = true
+	default:
+		return gear.ErrConflict.WithMsg("refund request already reviewed")
+	}
+
+	payload, err := util.Unmarshal[bll.RefundPayload](refund.Payload)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	payload.Refund = &refund.ID
+
+	refunded := false
+	if processing {
+		// the previous refunding failed or was interrupted, reconcile it with
+		// the refund transaction before reviewing again.
+		txn, err := a.blls.Walletbase.FindRefund(ctx, input.UID, refund.ID)
+		if err != nil {
+			return gear.ErrInternalServerError.From(err)
+		}
+		if txn != nil {
+			refunded = true
+			input.Approved = true
+		}
+	}
+
+	status := int8(-1)
+	var refundErr error
+	if input.Approved {
+		status = 1
+		// mark the request as processing before refunding, it will not be
+		// refunded twice even if the final update fails.
+		if !processing {
+			if _, err := a.blls.Logbase.Update(ctx, &bll.UpdateLog{
+				UID:    input.UID,
+				ID:     input.ID,
+				Status: refundProcessing,
+			}); err != nil {
+				return gear.ErrInternalServerError.From(err)
+			}
+		}
+
+		if !refunded {
+			data, er := util.Marshal(payload)
+			if er == nil {
+				_, er = a.blls.Walletbase.Refund(ctx, &bll.RefundInput{
+					UID:         input.UID,
+					Txn:         payload.Txn,
+					Amount:      payload.Amount,
+					Description: bll.LogActionUserRefund,
+					Payload:     data,
+				})
+			}
+			refundErr = er
+		}
+	}
+
+	// the review log of reviewer, with the original job id in payload
+	reviewStatus := status
+	if refundErr != nil {
+		reviewStatus = -1
+	}
+	if _, err := a.blls.Logbase.Log(ctx, bll.LogActionUserRefund, reviewStatus, payload.GID, payload); err != nil {
+		logging.SetTo(ctx, "writeLogError", err.Error())
+	}
+
+	if refundErr != nil {
+		// the request keeps processing status, reviewing it again will check the
+		// refund transaction and retry.
+		return gear.ErrInternalServerError.From(refundErr)
+	}
+
+	update := &bll.UpdateLog{
+		UID:    input.UID,
+		ID:     input.ID,
+		Status: status,
+	}
+	if !input.Approved && input.Message != "" {
+		update.Error = util.Ptr(input.Message)
+	}
+	if _, err := a.blls.Logbase.Update(ctx, update); err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	if payload.Task != nil {
+		a.resolveRefundTask(ctx, payload, status, input.Message)
+	}
+	return ctx.OkSend(bll.SuccessResponse[*bll.RefundPayload]{Result: payload})
+}
+
+// refundProcessing is the status of a refund request approved but not refunded yet.
+const refundProcessing int8 = 2
+
+// resolveRefundTask closes the "user.refund" task of the request with the review result.
+func (a *Wallet) resolveRefundTask(ctx *gear.Context, payload *bll.RefundPayload, status int8, message string) {
+	task, err := a.blls.Taskbase.Get(ctx, payload.GID, *payload.Task)
+	if err == nil {
+		update := &bll.UpdateTaskInput{
+			GID:       task.GID,
+			ID:        task.ID,
+			UpdatedAt: task.UpdatedAt,
+			Status:    &status,
+		}
+		if message != "" {
+			update.Message = &message
+		}
+		_, err = a.blls.Taskbase.Update(ctx, update)
+	}
+	if err != nil {
+		logging.SetTo(ctx, "updateTaskError", err.Error())
+	}
+}
@@ -56,7 +56,7 @@ func (a *Wechat) JsapiTicket(ctx *gear.Context) error {
 
 	output := &WechatTicketOutput{
 		Url:       input.Url,
-		AppID:     conf.Config.Wechat.AppID,
+		AppID:     conf.Current().Wechat.AppID,
 		NonceStr:  util.RandString(8),
 		Timestamp: uint(time.Now().Unix()),
 	}
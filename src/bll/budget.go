@@ -0,0 +1,326 @@
+package bll
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/logging"
+	"github.com/yiwen-ai/yiwen-api/src/service"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+const (
+	budgetDailyTTL      = 3600 * 24 * 2  // seconds
+	budgetMonthlyTTL    = 3600 * 24 * 32 // seconds
+	defaultAlertPercent = 80
+)
+
+// Budget tracks the spending of groups and their members, and enforces the
+// spending limits set by group admins.
+type Budget struct {
+	redis   *service.Redis
+	writing *Writing
+}
+
+type BudgetLimits struct {
+	Daily         int64 `json:"daily" cbor:"daily" validate:"gte=0"`                   // group total per day, 0 means no limit
+	Monthly       int64 `json:"monthly" cbor:"monthly" validate:"gte=0"`               // group total per month
+	MemberDaily   int64 `json:"member_daily" cbor:"member_daily" validate:"gte=0"`     // each member per day
+	MemberMonthly int64 `json:"member_monthly" cbor:"member_monthly" validate:"gte=0"` // each member per month
+	AlertPercent  uint8 `json:"alert_percent" cbor:"alert_percent" validate:"lte=100"` // alert when usage reaches the percent, default 80
+}
+
+type BudgetUsage struct {
+	Daily         int64 `json:"daily" cbor:"daily"`
+	Monthly       int64 `json:"monthly" cbor:"monthly"`
+	MemberDaily   int64 `json:"member_daily" cbor:"member_daily"`
+	MemberMonthly int64 `json:"member_monthly" cbor:"member_monthly"`
+}
+
+type UpdateBudgetInput struct {
+	GID util.ID `json:"gid" cbor:"gid" validate:"required"`
+	BudgetLimits
+}
+
+func (i *UpdateBudgetInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	return nil
+}
+
+type BudgetOutput struct {
+	GID    util.ID      `json:"gid" cbor:"gid"`
+	Limits BudgetLimits `json:"limits" cbor:"limits"`
+	Usage  BudgetUsage  `json:"usage" cbor:"usage"`
+}
+
+func (l *BudgetLimits) IsZero() bool {
+	return l.Daily == 0 && l.Monthly == 0 && l.MemberDaily == 0 && l.MemberMonthly == 0
+}
+
+func (b *Budget) GetLimits(ctx context.Context, gid util.ID) (*BudgetLimits, error) {
+	output := &BudgetLimits{}
+	if err := b.redis.GetCBOR(ctx, budgetLimitsKey(gid), output); err != nil {
+		if er := gear.ErrInternalServerError.From(err); er.Code == 404 {
+			return output, nil
+		}
+		return nil, err
+	}
+
+	return output, nil
+}
+
+func (b *Budget) SetLimits(ctx context.Context, gid util.ID, limits *BudgetLimits) error {
+	if limits.AlertPercent == 0 {
+		limits.AlertPercent = defaultAlertPercent
+	}
+	return b.redis.SetCBOR(ctx, budgetLimitsKey(gid), limits, 0)
+}
+
+func (b *Budget) Usage(ctx context.Context, gid, uid util.ID) (*BudgetUsage, error) {
+	vals, err := b.redis.GetInts(ctx, budgetUsageKeys(gid, uid, time.Now())...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BudgetUsage{
+		Daily:         vals[0],
+		Monthly:       vals[1],
+		MemberDaily:   vals[2],
+		MemberMonthly: vals[3],
+	}, nil
+}
+
+// Check returns 402 error if the cost would exceed any spending limit, without
+// reserving it. It is used to reject a batch of spendings up front, each of
+// them is reserved when spending.
+func (b *Budget) Check(ctx context.Context, gid, uid util.ID, cost int64) error {
+	if cost <= 0 {
+		return nil
+	}
+
+	limits, err := b.GetLimits(ctx, gid)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	if limits.IsZero() {
+		return nil
+	}
+
+	usage, err := b.Usage(ctx, gid, uid)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	for _, v := range budgetItems(limits, usage) {
+		if v.limit > 0 && v.used+cost > v.limit {
+			return gear.ErrPaymentRequired.WithMsgf("%s spending limit exceeded, limit %d, used %d, expected %d",
+				v.name, v.limit, v.used, cost)
+		}
+	}
+	return nil
+}
+
+// Reserve adds the cost to spending counters before spending, and returns 402
+// error if the cost exceeds any spending limit. The counters are incremented
+// before checking, so concurrent reservations can not overrun the limits, and
+// rolled back if exceeded.
+func (b *Budget) Reserve(ctx context.Context, gid, uid util.ID, cost int64) error {
+	if cost <= 0 {
+		return nil
+	}
+
+	limits, err := b.GetLimits(ctx, gid)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	usage, err := b.incr(ctx, gid, uid, cost)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	if limits.IsZero() {
+		return nil
+	}
+
+	for _, v := range budgetItems(limits, usage) {
+		if v.limit > 0 && v.used > v.limit {
+			if _, err := b.incr(ctx, gid, uid, -cost); err != nil {
+				logging.Warningf("Budget.Reserve: failed to roll back for group %s: %v", gid.String(), err)
+			}
+			return gear.ErrPaymentRequired.WithMsgf("%s spending limit exceeded, limit %d, used %d, expected %d",
+				v.name, v.limit, v.used-cost, cost)
+		}
+	}
+
+	b.check(ctx, gid, uid, limits, usage, cost)
+	return nil
+}
+
+// Adjust changes the reserved spending by delta, such as the difference
+// between the actual cost and the reserved cost, or -cost when the spending is
+// cancelled. It sends alert message to the group when the usage crosses the
+// alert threshold or the limit.
+func (b *Budget) Adjust(ctx context.Context, gid, uid util.ID, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+
+	usage, err := b.incr(ctx, gid, uid, delta)
+	if err != nil || delta < 0 {
+		return err
+	}
+
+	limits, err := b.GetLimits(ctx, gid)
+	if err != nil || limits.IsZero() {
+		return err
+	}
+	b.check(ctx, gid, uid, limits, usage, delta)
+	return nil
+}
+
+// budgetReservation is the cost reserved for a pending transaction.
+type budgetReservation struct {
+	GID    util.ID `cbor:"gid"`
+	UID    util.ID `cbor:"uid"`
+	Amount int64   `cbor:"amount"`
+	At     int64   `cbor:"at"` // unix seconds
+}
+
+// Track keeps the reserved cost with the pending transaction until it is
+// committed or cancelled. It is stored in Redis so that any replica can roll
+// it back, and expires with the monthly counters.
+func (b *Budget) Track(ctx context.Context, txn, gid, uid util.ID, cost int64) error {
+	if cost <= 0 {
+		return nil
+	}
+	r := &budgetReservation{GID: gid, UID: uid, Amount: cost, At: time.Now().Unix()}
+	return b.redis.SetCBOR(ctx, budgetTxnKey(txn), r, budgetMonthlyTTL)
+}
+
+// Confirm drops the reservation of the committed transaction.
+func (b *Budget) Confirm(ctx context.Context, txn util.ID) error {
+	return b.redis.Del(ctx, budgetTxnKey(txn))
+}
+
+// Rollback rolls back the reservation of the cancelled transaction from the
+// counters of the day it was reserved, only once.
+func (b *Budget) Rollback(ctx context.Context, txn util.ID) error {
+	r := &budgetReservation{}
+	ok, err := b.redis.TakeCBOR(ctx, budgetTxnKey(txn), r)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = b.incrAt(ctx, r.GID, r.UID, -r.Amount, time.Unix(r.At, 0))
+	return err
+}
+
+func (b *Budget) incr(ctx context.Context, gid, uid util.ID, n int64) (*BudgetUsage, error) {
+	return b.incrAt(ctx, gid, uid, n, time.Now())
+}
+
+func (b *Budget) incrAt(ctx context.Context, gid, uid util.ID, n int64, at time.Time) (*BudgetUsage, error) {
+	keys := budgetUsageKeys(gid, uid, at)
+	daily, err := b.redis.IncrByMulti(ctx, []string{keys[0], keys[2]}, n, budgetDailyTTL)
+	if err != nil {
+		return nil, err
+	}
+	monthly, err := b.redis.IncrByMulti(ctx, []string{keys[1], keys[3]}, n, budgetMonthlyTTL)
+	if err != nil {
+		if _, er := b.redis.IncrByMulti(ctx, []string{keys[0], keys[2]}, -n, budgetDailyTTL); er != nil {
+			logging.Warningf("Budget.incr: failed to roll back for group %s: %v", gid.String(), er)
+		}
+		return nil, err
+	}
+
+	return &BudgetUsage{
+		Daily:         daily[0],
+		Monthly:       monthly[0],
+		MemberDaily:   daily[1],
+		MemberMonthly: monthly[1],
+	}, nil
+}
+
+// check sends alert message when the usage crosses the alert threshold or the
+// limit by the amount.
+func (b *Budget) check(ctx context.Context, gid, uid util.ID, limits *BudgetLimits, usage *BudgetUsage, amount int64) {
+	percent := int64(limits.AlertPercent)
+	if percent == 0 {
+		percent = defaultAlertPercent
+	}
+	for _, v := range budgetItems(limits, usage) {
+		if v.limit <= 0 {
+			continue
+		}
+		prev := v.used - amount
+		threshold := v.limit * percent / 100
+		switch {
+		case prev < v.limit && v.used >= v.limit:
+			b.alert(ctx, gid, uid, v.name, v.used, v.limit)
+		case prev < threshold && v.used >= threshold:
+			b.alert(ctx, gid, uid, v.name, v.used, v.limit)
+		}
+	}
+}
+
+func (b *Budget) alert(ctx context.Context, gid, uid util.ID, name string, used, limit int64) {
+	msg := KVMessage{
+		"title":   fmt.Sprintf("The %s spending has reached %d%% of the limit", name, used*100/limit),
+		"content": fmt.Sprintf("User %s spent, %s spending is %d, limit is %d.", uid.String(), name, used, limit),
+	}
+	data, err := cbor.Marshal(msg)
+	if err == nil {
+		_, err = b.writing.CreateMessage(ctx, &CreateMessageInput{
+			AttachTo: gid,
+			Kind:     "budget.alert",
+			Language: "eng",
+			Message:  data,
+		})
+	}
+
+	if err != nil {
+		logging.Warningf("Budget.alert: failed to create message for group %s: %v", gid.String(), err)
+	}
+}
+
+type budgetItem struct {
+	name  string
+	limit int64
+	used  int64
+}
+
+func budgetItems(limits *BudgetLimits, usage *BudgetUsage) []budgetItem {
+	return []budgetItem{
+		{"group daily", limits.Daily, usage.Daily},
+		{"group monthly", limits.Monthly, usage.Monthly},
+		{"member daily", limits.MemberDaily, usage.MemberDaily},
+		{"member monthly", limits.MemberMonthly, usage.MemberMonthly},
+	}
+}
+
+func budgetLimitsKey(gid util.ID) string {
+	return "budget:" + gid.String()
+}
+
+func budgetTxnKey(txn util.ID) string {
+	return "budget:txn:" + txn.String()
+}
+
+// group daily, group monthly, member daily, member monthly
+func budgetUsageKeys(gid, uid util.ID, now time.Time) []string {
+	now = now.UTC()
+	day := now.Format("20060102")
+	month := now.Format("200601")
+	prefix := "spend:" + gid.String()
+	return []string{
+		prefix + ":d:" + day,
+		prefix + ":m:" + month,
+		prefix + ":" + uid.String() + ":d:" + day,
+		prefix + ":" + uid.String() + ":m:" + month,
+	}
+}
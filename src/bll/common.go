@@ -2,7 +2,11 @@ package bll
 
 import (
 	"context"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/ldclabs/cose/key"
 	_ "github.com/ldclabs/cose/key/aesgcm"
@@ -10,6 +14,7 @@ import (
 	"github.com/teambition/gear"
 
 	"github.com/yiwen-ai/yiwen-api/src/conf"
+	"github.com/yiwen-ai/yiwen-api/src/logging"
 	"github.com/yiwen-ai/yiwen-api/src/service"
 	"github.com/yiwen-ai/yiwen-api/src/util"
 )
@@ -20,11 +25,21 @@ func init() {
 
 // Blls ...
 type Blls struct {
-	MACer      key.MACer
-	Encryptor  key.Encryptor
+	MACer      *util.MACerRing
+	Encryptor  *util.EncryptorRing
+	Signer     *util.SignerRing // nil if no signing key configured
+	Verifier   key.Verifier     // verifies with the public keys of the Signer
 	Locker     *service.Locker
+	Budget     *Budget
+	Coupon     *Coupon
+	Estimator  *Estimator
+	Gift       *Gift
 	Jarvis     *Jarvis
 	Logbase    *Logbase
+	Membership *Membership
+	PayCode    *PayCode
+	Renewal    *Renewal
+	Statistic  *Statistic
 	Taskbase   *Taskbase
 	Userbase   *Userbase
 	Walletbase *Walletbase
@@ -36,30 +51,113 @@ type Blls struct {
 // NewBlls ...
 func NewBlls(oss *service.OSS, redis *service.Redis, locker *service.Locker) *Blls {
 	cfg := conf.Config.Base
-	macer, err := conf.Config.COSEKeys.Hmac.MACer()
+	macer, err := util.NewMACerRing(conf.Config.COSEKeys.Hmac)
 	if err != nil {
 		panic(err)
 	}
-	encryptor, err := conf.Config.COSEKeys.Aesgcm.Encryptor()
+	encryptor, err := util.NewEncryptorRing(conf.Config.COSEKeys.Aesgcm)
 	if err != nil {
 		panic(err)
 	}
+	var signer *util.SignerRing
+	var verifier key.Verifier
+	if conf.Config.COSEKeys.Signing != nil {
+		if signer, err = util.NewSignerRing(conf.Config.COSEKeys.Signing); err != nil {
+			panic(err)
+		}
+		verifier = signer.Verifier()
+	}
 
+	writing := &Writing{svc: service.APIHost(cfg.Writing), oss: oss}
+	budget := &Budget{redis: redis, writing: writing}
+	jarvis := &Jarvis{providers: NewProviders(redis, conf.Config.Providers, conf.Config.Routes)}
+	membership := &Membership{redis: redis, writing: writing}
+	walletbase := &Walletbase{svc: service.APIHost(cfg.Walletbase), budget: budget}
 	return &Blls{
 		MACer:      macer,
 		Encryptor:  encryptor,
+		Signer:     signer,
+		Verifier:   verifier,
 		Locker:     locker,
-		Jarvis:     &Jarvis{svc: service.APIHost(cfg.Jarvis)},
+		Budget:     budget,
+		Coupon:     &Coupon{redis: redis, writing: writing},
+		Estimator:  &Estimator{redis: redis, jarvis: jarvis},
+		Gift:       &Gift{redis: redis},
+		Jarvis:     jarvis,
 		Logbase:    &Logbase{svc: service.APIHost(cfg.Logbase)},
+		Membership: membership,
+		PayCode:    &PayCode{redis: redis},
+		Renewal: &Renewal{
+			redis:      redis,
+			locker:     locker,
+			membership: membership,
+			walletbase: walletbase,
+			writing:    writing,
+		},
+		Statistic:  NewStatistic(redis),
 		Taskbase:   &Taskbase{svc: service.APIHost(cfg.Taskbase)},
 		Userbase:   &Userbase{svc: service.APIHost(cfg.Userbase), oss: oss},
-		Walletbase: &Walletbase{svc: service.APIHost(cfg.Walletbase)},
+		Walletbase: walletbase,
 		Webscraper: &Webscraper{svc: service.APIHost(cfg.Webscraper)},
 		Wechat:     &Wechat{redis: redis},
-		Writing:    &Writing{svc: service.APIHost(cfg.Writing), oss: oss},
+		Writing:    writing,
 	}
 }
 
+const configWatchInterval = 10 * time.Second
+
+// InitApp reloads the config and the key rings on SIGHUP, or when the config
+// file is modified.
+func (b *Blls) InitApp(ctx context.Context, _ *gear.App) error {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		ticker := time.NewTicker(configWatchInterval)
+		defer ticker.Stop()
+
+		modTime := conf.Current().ModTime()
+		for {
+			select {
+			case <-ch:
+			case <-ticker.C:
+				t, err := conf.FileModTime()
+				if err != nil || t.Equal(modTime) {
+					continue
+				}
+				modTime = t
+			}
+			logging.CtxRun(context.Background(), "Blls.Reload", b.Reload)
+		}
+	}()
+	return nil
+}
+
+// Reload reloads the config, and the key rings from the key files so that
+// added, rotated and retired keys take effect without restart.
+func (b *Blls) Reload(ctx context.Context) error {
+	cfg, ignored, err := conf.Reload()
+	if err != nil {
+		return err
+	}
+	if len(ignored) > 0 {
+		logging.Warningf("config %s: restart to apply changes of %s", cfg.Version, strings.Join(ignored, ", "))
+	}
+
+	if err = b.MACer.Load(cfg.COSEKeys.Hmac); err != nil {
+		return err
+	}
+	if err = b.Encryptor.Load(cfg.COSEKeys.Aesgcm); err != nil {
+		return err
+	}
+	if b.Signer != nil {
+		if err = b.Signer.Load(cfg.COSEKeys.Signing); err != nil {
+			return err
+		}
+	}
+	logging.Infof("config %s reloaded", cfg.Version)
+	return nil
+}
+
 func (b *Blls) Stats(ctx context.Context) (res map[string]any, err error) {
 	return b.Userbase.svc.Stats(ctx)
 }
@@ -0,0 +1,252 @@
+package bll
+
+import (
+	"context"
+	"strings"
+
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/logging"
+	"github.com/yiwen-ai/yiwen-api/src/service"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+const (
+	CouponPercentOff int8 = 0
+	CouponFixedOff   int8 = 1
+
+	maxGroupCoupons  = 100
+	maxCouponRetries = 3
+	couponCacheTTL   = 600 // seconds
+)
+
+// Coupon manages the discount coupons of groups. A coupon applies to the
+// subscriptions of the group's content, or only to the given cids. The
+// coupons and their uses are stored in the writing service, and cached in redis.
+type Coupon struct {
+	redis   *service.Redis
+	writing *Writing
+}
+
+type CouponInfo struct {
+	Code     string    `json:"code" cbor:"code" validate:"required,alphanum,min=4,max=32"`
+	Kind     int8      `json:"kind" cbor:"kind" validate:"gte=0,lte=1"`
+	Value    int64     `json:"value" cbor:"value" validate:"gte=1"` // percent off (1~99) or amount off
+	CIDs     []util.ID `json:"cids,omitempty" cbor:"cids,omitempty" validate:"omitempty,lte=100"`
+	MaxUses  int64     `json:"max_uses" cbor:"max_uses" validate:"gte=0"`   // 0 means unlimited
+	ExpireAt int64     `json:"expire_at" cbor:"expire_at" validate:"gte=0"` // unix seconds, 0 means never
+}
+
+type UpdateCouponInput struct {
+	GID util.ID `json:"gid" cbor:"gid" validate:"required"`
+	CouponInfo
+}
+
+func (i *UpdateCouponInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+	if i.Kind == CouponPercentOff && i.Value > 99 {
+		return gear.ErrBadRequest.WithMsg("percent off should be less than 100")
+	}
+
+	i.Code = strings.ToUpper(i.Code)
+	return nil
+}
+
+type QueryCoupon struct {
+	GID  util.ID `json:"gid" cbor:"gid" query:"gid" validate:"required"`
+	Code string  `json:"code" cbor:"code" query:"code"`
+}
+
+func (i *QueryCoupon) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	i.Code = strings.ToUpper(i.Code)
+	return nil
+}
+
+type CouponOutput struct {
+	CouponInfo
+	Uses      int64 `json:"uses" cbor:"uses"`
+	UpdatedAt int64 `json:"updated_at" cbor:"updated_at"`
+}
+
+// Apply returns the discounted amount, the amount is at least 1.
+func (c *CouponInfo) Apply(cid util.ID, amount, now int64) (int64, error) {
+	if err := c.check(cid, now); err != nil {
+		return 0, err
+	}
+
+	switch c.Kind {
+	case CouponPercentOff:
+		amount -= amount * c.Value / 100
+	case CouponFixedOff:
+		amount -= c.Value
+	default:
+		return 0, gear.ErrBadRequest.WithMsg("invalid coupon")
+	}
+	if amount < 1 {
+		amount = 1
+	}
+	return amount, nil
+}
+
+func (c *CouponInfo) check(cid util.ID, now int64) error {
+	if c.ExpireAt > 0 && c.ExpireAt < now {
+		return gear.ErrBadRequest.WithMsg("coupon expired")
+	}
+	if len(c.CIDs) > 0 && !util.SliceHas(c.CIDs, cid) {
+		return gear.ErrBadRequest.WithMsg("coupon is not applicable")
+	}
+	return nil
+}
+
+// use counts a use of the coupon for the cid, it fails if the coupon is
+// expired, not applicable or used up.
+func (c *CouponOutput) use(cid util.ID, now int64) error {
+	if err := c.check(cid, now); err != nil {
+		return err
+	}
+	if c.MaxUses > 0 && c.Uses >= c.MaxUses {
+		return gear.ErrBadRequest.WithMsg("coupon has been used up")
+	}
+	c.Uses++
+	return nil
+}
+
+// unuse reverts a use of the coupon.
+func (c *CouponOutput) unuse() {
+	if c.Uses > 0 {
+		c.Uses--
+	}
+}
+
+func (b *Coupon) List(ctx context.Context, gid util.ID) ([]CouponOutput, error) {
+	output := []CouponOutput{}
+	key := couponsKey(gid)
+	if err := b.redis.GetCBOR(ctx, key, &output); err == nil {
+		return output, nil
+	}
+
+	output, err := b.writing.InternalListCoupons(ctx, gid)
+	if err != nil {
+		if er := gear.ErrInternalServerError.From(err); er.Code != 404 {
+			return nil, err
+		}
+	}
+	if output == nil {
+		output = []CouponOutput{}
+	}
+
+	if err = b.redis.SetCBOR(ctx, key, output, couponCacheTTL); err != nil {
+		logging.Warningf("Coupon: failed to cache %s: %v", key, err)
+	}
+	return output, nil
+}
+
+func (b *Coupon) Get(ctx context.Context, gid util.ID, code string) (*CouponOutput, error) {
+	list, err := b.List(ctx, gid)
+	if err != nil {
+		return nil, err
+	}
+
+	code = strings.ToUpper(code)
+	for i := range list {
+		if list[i].Code == code {
+			return &list[i], nil
+		}
+	}
+	return nil, gear.ErrNotFound.WithMsgf("coupon %q not found", code)
+}
+
+// Save creates or updates the coupon, the uses are kept when updating.
+func (b *Coupon) Save(ctx context.Context, gid util.ID, coupon *CouponInfo) (*CouponOutput, error) {
+	coupons, err := b.writing.InternalListCoupons(ctx, gid)
+	if err != nil {
+		if er := gear.ErrInternalServerError.From(err); er.Code != 404 {
+			return nil, err
+		}
+	}
+
+	n := 0
+	for _, c := range coupons {
+		if c.Code != coupon.Code {
+			n++
+		}
+	}
+	if n >= maxGroupCoupons {
+		return nil, gear.ErrBadRequest.WithMsgf("too many coupons, max %d", maxGroupCoupons)
+	}
+
+	output, err := b.writing.InternalUpdateCoupon(ctx, &UpdateCouponInput{GID: gid, CouponInfo: *coupon})
+	if err != nil {
+		return nil, err
+	}
+	b.uncache(ctx, gid)
+	return output, nil
+}
+
+func (b *Coupon) Delete(ctx context.Context, gid util.ID, code string) error {
+	if _, err := b.writing.InternalDeleteCoupon(ctx, gid, strings.ToUpper(code)); err != nil {
+		return err
+	}
+	b.uncache(ctx, gid)
+	return nil
+}
+
+// Use counts a use of the coupon for the cid, it fails if the coupon is
+// expired, not applicable or used up. Should call Unuse if the payment failed.
+func (b *Coupon) Use(ctx context.Context, gid util.ID, code string, cid util.ID, now int64) error {
+	return b.update(ctx, gid, code, func(c *CouponOutput) error {
+		return c.use(cid, now)
+	})
+}
+
+func (b *Coupon) Unuse(ctx context.Context, gid util.ID, code string) error {
+	return b.update(ctx, gid, code, func(c *CouponOutput) error {
+		c.unuse()
+		return nil
+	})
+}
+
+// update changes the uses of the latest coupon, and retries if the coupon is
+// changed by others meanwhile.
+func (b *Coupon) update(ctx context.Context, gid util.ID, code string, fn func(c *CouponOutput) error) error {
+	code = strings.ToUpper(code)
+	for i := 0; ; i++ {
+		coupon, err := b.writing.InternalGetCoupon(ctx, gid, code)
+		if err != nil {
+			return err
+		}
+		if err = fn(coupon); err != nil {
+			return err
+		}
+
+		_, err = b.writing.InternalUpdateCouponUses(ctx, &UpdateCouponUsesInput{
+			GID:       gid,
+			Code:      code,
+			Uses:      coupon.Uses,
+			UpdatedAt: coupon.UpdatedAt,
+		})
+		if err == nil {
+			b.uncache(ctx, gid)
+			return nil
+		}
+		if er := gear.ErrInternalServerError.From(err); er.Code != 409 || i+1 >= maxCouponRetries {
+			return err
+		}
+	}
+}
+
+func (b *Coupon) uncache(ctx context.Context, gid util.ID) {
+	if err := b.redis.Del(ctx, couponsKey(gid)); err != nil {
+		logging.Warningf("Coupon: failed to uncache %s: %v", gid.String(), err)
+	}
+}
+
+func couponsKey(gid util.ID) string {
+	return "cp:" + gid.String()
+}
@@ -0,0 +1,96 @@
+package bll
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+func TestCouponApply(t *testing.T) {
+	assert := assert.New(t)
+	cid := util.NewID()
+
+	c := &CouponInfo{Code: "SPRING", Kind: CouponPercentOff, Value: 20}
+	amount, err := c.Apply(cid, 100, 1000)
+	assert.NoError(err)
+	assert.Equal(int64(80), amount)
+
+	c = &CouponInfo{Code: "SPRING", Kind: CouponFixedOff, Value: 30}
+	amount, err = c.Apply(cid, 100, 1000)
+	assert.NoError(err)
+	assert.Equal(int64(70), amount)
+
+	amount, err = c.Apply(cid, 10, 1000)
+	assert.NoError(err)
+	assert.Equal(int64(1), amount)
+
+	c.ExpireAt = 999
+	_, err = c.Apply(cid, 100, 1000)
+	assert.Error(err)
+
+	c.ExpireAt = 0
+	c.CIDs = []util.ID{util.NewID()}
+	_, err = c.Apply(cid, 100, 1000)
+	assert.Error(err)
+
+	c.CIDs = append(c.CIDs, cid)
+	amount, err = c.Apply(cid, 100, 1000)
+	assert.NoError(err)
+	assert.Equal(int64(70), amount)
+}
+
+func TestCouponUse(t *testing.T) {
+	assert := assert.New(t)
+	cid := util.NewID()
+
+	c := &CouponOutput{CouponInfo: CouponInfo{Code: "SPRING", Kind: CouponPercentOff, Value: 20, MaxUses: 2}}
+	assert.NoError(c.use(cid, 1000))
+	assert.NoError(c.use(cid, 1000))
+	assert.Equal(int64(2), c.Uses)
+
+	err := c.use(cid, 1000)
+	assert.ErrorContains(err, "used up")
+	assert.Equal(int64(2), c.Uses)
+
+	c.unuse()
+	assert.Equal(int64(1), c.Uses)
+	assert.NoError(c.use(cid, 1000))
+	assert.Equal(int64(2), c.Uses)
+
+	c.MaxUses = 0
+	assert.NoError(c.use(cid, 1000))
+	assert.Equal(int64(3), c.Uses)
+
+	c.ExpireAt = 999
+	err = c.use(cid, 1000)
+	assert.ErrorContains(err, "expired")
+	assert.Equal(int64(3), c.Uses)
+	assert.NoError(c.use(cid, 999))
+	assert.Equal(int64(4), c.Uses)
+
+	c.ExpireAt = 0
+	c.CIDs = []util.ID{util.NewID()}
+	err = c.use(cid, 1000)
+	assert.ErrorContains(err, "not applicable")
+	assert.Equal(int64(4), c.Uses)
+
+	c.CIDs = append(c.CIDs, cid)
+	assert.NoError(c.use(cid, 1000))
+	assert.Equal(int64(5), c.Uses)
+}
+
+func TestCouponUnuse(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &CouponOutput{CouponInfo: CouponInfo{Code: "SPRING", Kind: CouponFixedOff, Value: 10, MaxUses: 1}, Uses: 1}
+	c.unuse()
+	assert.Equal(int64(0), c.Uses)
+
+	c.unuse()
+	assert.Equal(int64(0), c.Uses)
+
+	assert.NoError(c.use(util.NewID(), 1000))
+	assert.Equal(int64(1), c.Uses)
+}
@@ -0,0 +1,119 @@
+package bll
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/logging"
+	"github.com/yiwen-ai/yiwen-api/src/service"
+)
+
+const (
+	estimatorTTL        = 3600 * 24 * 90 // seconds
+	estimatorMinSamples = 5
+	estimatorMinAlpha   = 0.05
+	estimatorSpread     = 0.3 // default spread of the range when there are not enough samples
+)
+
+// Estimator estimates the tokens of translating jobs, it learns the correction
+// factor for each model and language pair from completed jobs.
+type Estimator struct {
+	redis  *service.Redis
+	jarvis *Jarvis
+}
+
+// EstimatorStats is the exponentially weighted mean and variance of the
+// ratio of actual tokens to estimated tokens.
+type EstimatorStats struct {
+	Count    uint32  `json:"count" cbor:"count"`
+	Mean     float64 `json:"mean" cbor:"mean"`
+	Variance float64 `json:"variance" cbor:"variance"`
+}
+
+type TokensEstimate struct {
+	Base   uint32  `json:"-" cbor:"-"` // uncorrected estimation, should be recorded with the actual tokens
+	Tokens uint32  `json:"tokens" cbor:"tokens"`
+	Min    uint32  `json:"min" cbor:"min"`
+	Max    uint32  `json:"max" cbor:"max"`
+	Factor float64 `json:"factor" cbor:"factor"`
+}
+
+func (s *EstimatorStats) Add(ratio float64) {
+	// ignore outliers, they are most likely failed jobs
+	if ratio < 0.1 || ratio > 10 {
+		return
+	}
+
+	s.Count++
+	if s.Count == 1 {
+		s.Mean = ratio
+		s.Variance = 0
+		return
+	}
+
+	alpha := math.Max(1/float64(s.Count), estimatorMinAlpha)
+	diff := ratio - s.Mean
+	s.Mean += alpha * diff
+	s.Variance = (1 - alpha) * (s.Variance + alpha*diff*diff)
+}
+
+func (s *EstimatorStats) Apply(base uint32) *TokensEstimate {
+	output := &TokensEstimate{Base: base, Tokens: base, Factor: 1}
+	if s.Count < estimatorMinSamples {
+		output.Min = uint32(float64(base) * (1 - estimatorSpread))
+		output.Max = uint32(math.Ceil(float64(base) * (1 + estimatorSpread)))
+		return output
+	}
+
+	std := math.Sqrt(s.Variance)
+	output.Factor = s.Mean
+	output.Tokens = uint32(math.Ceil(float64(base) * s.Mean))
+	output.Min = uint32(math.Max(float64(base)*(s.Mean-2*std), 0))
+	output.Max = uint32(math.Ceil(float64(base) * (s.Mean + 2*std)))
+	return output
+}
+
+func (b *Estimator) Estimate(ctx context.Context, model *AIModel, text, srcLang, dstLang string) *TokensEstimate {
+	base := b.jarvis.EstimateTranslatingTokens(model.Tokenizer, text, srcLang, dstLang)
+	stats, err := b.Stats(ctx, model.ID, srcLang, dstLang)
+	if err != nil {
+		logging.Warningf("Estimator.Estimate: %v", err)
+		stats = &EstimatorStats{}
+	}
+
+	return stats.Apply(base)
+}
+
+func (b *Estimator) Stats(ctx context.Context, model, srcLang, dstLang string) (*EstimatorStats, error) {
+	output := &EstimatorStats{}
+	if err := b.redis.GetCBOR(ctx, estimatorKey(model, srcLang, dstLang), output); err != nil {
+		if er := gear.ErrInternalServerError.From(err); er.Code == 404 {
+			return output, nil
+		}
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// Record adds the (estimated, actual) pair of a completed job to the stats.
+func (b *Estimator) Record(ctx context.Context, model, srcLang, dstLang string, estimated, actual uint32) error {
+	if estimated == 0 || actual == 0 {
+		return nil
+	}
+
+	stats, err := b.Stats(ctx, model, srcLang, dstLang)
+	if err != nil {
+		return err
+	}
+
+	stats.Add(float64(actual) / float64(estimated))
+	return b.redis.SetCBOR(ctx, estimatorKey(model, srcLang, dstLang), stats, estimatorTTL)
+}
+
+func estimatorKey(model, srcLang, dstLang string) string {
+	return "est:" + model + ":" + strings.ToLower(srcLang) + ":" + strings.ToLower(dstLang)
+}
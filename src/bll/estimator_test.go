@@ -0,0 +1,34 @@
+package bll
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimatorStats(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &EstimatorStats{}
+	e := s.Apply(1000)
+	assert.Equal(uint32(1000), e.Tokens)
+	assert.Equal(uint32(700), e.Min)
+	assert.Equal(uint32(1300), e.Max)
+	assert.Equal(float64(1), e.Factor)
+
+	s.Add(20) // outlier
+	assert.Equal(uint32(0), s.Count)
+
+	for _, r := range []float64{0.8, 0.82, 0.78, 0.8, 0.81, 0.79} {
+		s.Add(r)
+	}
+	assert.Equal(uint32(6), s.Count)
+	assert.InDelta(0.8, s.Mean, 0.01)
+
+	e = s.Apply(1000)
+	assert.Equal(uint32(1000), e.Base)
+	assert.InDelta(800, float64(e.Tokens), 10)
+	assert.True(e.Min < e.Tokens)
+	assert.True(e.Max > e.Tokens)
+	assert.True(e.Max-e.Min < 200)
+}
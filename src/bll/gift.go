@@ -0,0 +1,112 @@
+package bll
+
+import (
+	"context"
+	"time"
+
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/service"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+// gift codes should be redeemed in one year
+const GiftCodeDuration = 3600 * 24 * 365 // seconds
+
+// Gift tracks the gift code batches bought by payers and makes sure that
+// every code is redeemed only once.
+type Gift struct {
+	redis *service.Redis
+}
+
+type CreateGiftInput struct {
+	Kind   int8    `json:"kind" cbor:"kind" validate:"gte=0,lte=2"`
+	CID    util.ID `json:"cid" cbor:"cid" validate:"required"`
+	GID    util.ID `json:"gid" cbor:"gid" validate:"required"`
+	Period string  `json:"period" cbor:"period" validate:"omitempty,oneof=month year"`
+	Count  uint16  `json:"count" cbor:"count" validate:"gte=1,lte=100"`
+}
+
+func (i *CreateGiftInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	return nil
+}
+
+type GiftBatch struct {
+	ID        util.ID  `json:"id" cbor:"id"` // the transaction of the purchase
+	UID       util.ID  `json:"uid" cbor:"uid"`
+	Kind      int8     `json:"kind" cbor:"kind"`
+	GID       util.ID  `json:"gid" cbor:"gid"`
+	CID       util.ID  `json:"cid" cbor:"cid"`
+	Title     string   `json:"title" cbor:"title"`
+	Period    string   `json:"period,omitempty" cbor:"period,omitempty"`
+	Duration  int64    `json:"duration" cbor:"duration"`
+	Amount    int64    `json:"amount" cbor:"amount"` // amount per code
+	Codes     []string `json:"codes" cbor:"codes"`
+	ExpireAt  int64    `json:"expire_at" cbor:"expire_at"`
+	CreatedAt int64    `json:"created_at" cbor:"created_at"`
+}
+
+type GiftBatchOutput struct {
+	GiftBatch
+	Redeemed int64 `json:"redeemed" cbor:"redeemed"`
+}
+
+func (b *Gift) SaveBatch(ctx context.Context, batch *GiftBatch) error {
+	return b.redis.SetCBOR(ctx, giftBatchKey(batch.UID, batch.ID), batch, giftTTL(batch.ExpireAt))
+}
+
+func (b *Gift) GetBatch(ctx context.Context, uid, id util.ID) (*GiftBatchOutput, error) {
+	output := &GiftBatchOutput{}
+	if err := b.redis.GetCBOR(ctx, giftBatchKey(uid, id), &output.GiftBatch); err != nil {
+		return nil, err
+	}
+
+	counts, err := b.redis.GetInts(ctx, giftRedeemedKey(id))
+	if err != nil {
+		return nil, err
+	}
+	output.Redeemed = counts[0]
+	return output, nil
+}
+
+// Redeem marks the code as redeemed by uid, it returns false if the code
+// has been redeemed already.
+func (b *Gift) Redeem(ctx context.Context, id, uid util.ID, expireAt int64) (bool, error) {
+	return b.redis.SetCBORNX(ctx, giftUsedKey(id), uid, giftTTL(expireAt))
+}
+
+// Redeemed counts the redemption of the batch after the subscription updated.
+func (b *Gift) Redeemed(ctx context.Context, batch util.ID, expireAt int64) error {
+	_, err := b.redis.IncrByMulti(ctx, []string{giftRedeemedKey(batch)}, 1, giftTTL(expireAt))
+	return err
+}
+
+// Release reverts the redemption when the subscription failed to update.
+func (b *Gift) Release(ctx context.Context, id util.ID) error {
+	return b.redis.Del(ctx, giftUsedKey(id))
+}
+
+// keeps the records a while after the codes expired.
+func giftTTL(expireAt int64) uint {
+	ttl := expireAt - time.Now().Unix() + 3600*24*30
+	if ttl < 1 {
+		ttl = 1
+	}
+	return uint(ttl)
+}
+
+func giftBatchKey(uid, id util.ID) string {
+	return "gift:batch:" + uid.String() + ":" + id.String()
+}
+
+func giftUsedKey(id util.ID) string {
+	return "gift:used:" + id.String()
+}
+
+func giftRedeemedKey(batch util.ID) string {
+	return "gift:redeemed:" + batch.String()
+}
@@ -3,56 +3,71 @@ package bll
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/teambition/gear"
+
 	"github.com/yiwen-ai/yiwen-api/src/conf"
-	"github.com/yiwen-ai/yiwen-api/src/service"
+	"github.com/yiwen-ai/yiwen-api/src/content"
+	"github.com/yiwen-ai/yiwen-api/src/logging"
 	"github.com/yiwen-ai/yiwen-api/src/util"
 )
 
+// Jarvis runs AI jobs with the providers, Jarvis service is the default provider.
 type Jarvis struct {
-	svc        service.APIHost
-	tokensRate map[string]float32
+	providers  *Providers
+	tokensRate atomic.Pointer[map[string]float32]
 	Languages  [][]string
 }
 
 func (b *Jarvis) InitApp(ctx context.Context, app *gear.App) error {
-	output, err := b.ListLanguages(context.Background())
+	output, err := b.ListLanguages(ctx)
 	if err != nil {
 		return err
 	}
 	b.Languages = output
-	b.tokensRate = make(map[string]float32, len(conf.Config.TokensRate))
-	for _, vv := range output {
-		if f, ok := conf.Config.TokensRate[vv[1]]; ok {
-			b.tokensRate[vv[0]] = f
-		}
-	}
+	b.loadTokensRate(conf.Config.TokensRate)
+	conf.OnReload(func(cfg *conf.ConfigTpl) {
+		b.loadTokensRate(cfg.TokensRate)
+	})
 
 	return nil
 }
 
+// loadTokensRate maps the tokens rate of language names to language codes.
+func (b *Jarvis) loadTokensRate(rates map[string]float32) {
+	tokensRate := make(map[string]float32, len(rates))
+	for _, vv := range b.Languages {
+		if f, ok := rates[vv[1]]; ok {
+			tokensRate[vv[0]] = f
+		}
+	}
+	b.tokensRate.Store(&tokensRate)
+}
+
 func (b *Jarvis) getTokensRate(lang string) float32 {
-	if v, ok := b.tokensRate[strings.ToLower(lang)]; ok {
-		return v
+	if rates := b.tokensRate.Load(); rates != nil {
+		if v, ok := (*rates)[strings.ToLower(lang)]; ok {
+			return v
+		}
 	}
 	return 1.0
 }
 
-func (b *Jarvis) EstimateTranslatingTokens(text, srcLang, dstLang string) uint32 {
-	tokens := util.Tiktokens(text) + 100
+func (b *Jarvis) EstimateTranslatingTokens(tokenizer, text, srcLang, dstLang string) uint32 {
+	tokens := util.TiktokensWith(tokenizer, text) + 100
 	return tokens + uint32(float32(tokens)*b.getTokensRate(dstLang)/b.getTokensRate(srcLang))
 }
 
 func (b *Jarvis) ListLanguages(ctx context.Context) ([][]string, error) {
-	output := SuccessResponse[[][]string]{}
-	if err := b.svc.Get(ctx, "/v1/translating/list_languages", &output); err != nil {
-		return nil, err
-	}
-
-	return output.Result, nil
+	return runWithFailover(ctx, b.providers, b.providers.Default(), "",
+		func(p Provider) ([][]string, error) {
+			return p.ListLanguages(ctx)
+		})
 }
 
 type DetectLangInput struct {
@@ -77,21 +92,57 @@ type TEInput struct {
 	FromLanguage *string     `json:"from_language,omitempty" cbor:"from_language,omitempty"`
 	Context      *string     `json:"context,omitempty" cbor:"context,omitempty"`
 	Model        *string     `json:"model,omitempty" cbor:"model,omitempty"`
+	MaxLength    *uint16     `json:"max_length,omitempty" cbor:"max_length,omitempty"` // target length of summary
 	Content      *util.Bytes `json:"content,omitempty" cbor:"content,omitempty"`
 }
 
 type TEOutput struct {
-	CID      util.ID `json:"cid" cbor:"cid"`
-	Language string  `json:"detected_language" cbor:"detected_language"`
+	CID        util.ID `json:"cid" cbor:"cid"`
+	Language   string  `json:"detected_language" cbor:"detected_language"`
+	Confidence float64 `json:"confidence,omitempty" cbor:"confidence,omitempty"` // only for local detection
 }
 
+// the local detection result is used directly when its confidence is high enough
+const localDetectConfidence = 0.8
+
+// DetectLang detects the language in process first, and only calls the
+// providers for ambiguous text. The local result is used as a fallback when
+// all providers failed.
 func (b *Jarvis) DetectLang(ctx context.Context, input *DetectLangInput) (*TEOutput, error) {
-	output := SuccessResponse[TEOutput]{}
-	if err := b.svc.Post(ctx, "/v1/translating/detect_language", input, &output); err != nil {
-		return nil, err
+	local := DetectLangLocal(input.Content)
+	if local != nil && local.Confidence >= localDetectConfidence {
+		return local, nil
 	}
 
-	return &output.Result, nil
+	providers := b.providers.Route(&RouteInput{GID: input.GID, From: input.Language})
+	output, err := runWithFailover(ctx, b.providers, providers, "",
+		func(p Provider) (*TEOutput, error) {
+			return p.DetectLang(ctx, input)
+		})
+	if err != nil && local != nil {
+		logging.Warningf("Jarvis.DetectLang: fallback to local detection: %v", err)
+		return local, nil
+	}
+	return output, err
+}
+
+// DetectLangLocal detects the language of the TEContents data in process,
+// returns nil if the language can not be detected.
+func DetectLangLocal(data []byte) *TEOutput {
+	te := content.TEContents{}
+	if err := cbor.Unmarshal(data, &te); err != nil {
+		return nil
+	}
+
+	texts := make([]string, 0, len(te))
+	for _, v := range te {
+		texts = append(texts, v.Texts...)
+	}
+	lang, confidence := util.DetectLang(strings.Join(texts, "\n"))
+	if lang == "" {
+		return nil
+	}
+	return &TEOutput{Language: lang, Confidence: confidence}
 }
 
 type SummarizingOutput struct {
@@ -108,12 +159,47 @@ type SummarizingOutput struct {
 	Error     string   `json:"error" cbor:"error"`
 }
 
-func (b *Jarvis) Summarize(ctx context.Context, input *TEInput) (*SummarizingOutput, error) {
-	o0 := SuccessResponse[TEOutput]{}
-	if err := b.svc.Post(ctx, "/v1/summarizing", input, &o0); err != nil {
-		return nil, err
+// SummarizeInput is the input of summarizing a creation, publication or collection on demand.
+type SummarizeInput struct {
+	GID       util.ID `json:"gid" cbor:"gid" validate:"required"`
+	ID        util.ID `json:"id" cbor:"id" validate:"required"`            // creation id, publication cid or collection id
+	Kind      int8    `json:"kind" cbor:"kind" validate:"gte=0,lte=2"`     // 0: creation, 1: publication, 2: collection
+	Language  string  `json:"language" cbor:"language"`                    // required for publication
+	Version   uint16  `json:"version" cbor:"version" validate:"lte=10000"` // required for publication
+	Model     *string `json:"model,omitempty" cbor:"model,omitempty" validate:"omitempty,gte=2,lte=16"`
+	MaxLength uint16  `json:"max_length" cbor:"max_length" validate:"omitempty,gte=20,lte=2048"`
+	Preview   bool    `json:"preview" cbor:"preview"` // do not save the result to the record
+}
+
+func (i *SummarizeInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	if i.Kind == 1 && (i.Language == "" || i.Version == 0) {
+		return gear.ErrBadRequest.WithMsg("language and version are required for publication")
 	}
+	if i.MaxLength == 0 {
+		i.MaxLength = 512
+	}
+	return nil
+}
 
+type SummarizeOutput struct {
+	GID       util.ID  `json:"gid" cbor:"gid"`
+	ID        util.ID  `json:"id" cbor:"id"`
+	Kind      int8     `json:"kind" cbor:"kind"`
+	Language  string   `json:"language" cbor:"language"`
+	Version   uint16   `json:"version" cbor:"version"`
+	Model     string   `json:"model" cbor:"model"`
+	Tokens    uint32   `json:"tokens" cbor:"tokens"`
+	Summary   string   `json:"summary" cbor:"summary"`
+	Keywords  []string `json:"keywords" cbor:"keywords"`
+	Preview   bool     `json:"preview" cbor:"preview"`
+	UpdatedAt *int64   `json:"updated_at,omitempty" cbor:"updated_at,omitempty"` // updated_at of the record if saved
+}
+
+func (b *Jarvis) Summarize(ctx context.Context, input *TEInput) (*SummarizingOutput, error) {
 	getInput := &TEInput{
 		GID:      input.GID,
 		CID:      input.CID,
@@ -121,37 +207,25 @@ func (b *Jarvis) Summarize(ctx context.Context, input *TEInput) (*SummarizingOut
 		Version:  input.Version,
 	}
 
-	i := 0
-	for {
-		i += 1
-		if i > 1200 {
-			return nil, errors.New("summarizing timeout")
-		}
-
-		time.Sleep(time.Second * 3)
-		output, err := b.GetSummary(ctx, getInput)
-		if err != nil {
-			return nil, err
-		}
-
-		if output.Progress == 100 && output.Summary != "" {
-			return output, nil
-		}
-	}
+	providers := b.providers.Route(routeOf(input))
+	return runWithFailover(ctx, b.providers, providers, summarizingJob(input),
+		func(p Provider) (*SummarizingOutput, error) {
+			if err := p.Summarize(ctx, input); err != nil {
+				return nil, err
+			}
+
+			return poll(func() (*SummarizingOutput, error) {
+				output, err := p.GetSummary(ctx, getInput)
+				if err != nil || (output.Progress == 100 && output.Summary != "") {
+					return output, err
+				}
+				return nil, nil
+			}, "summarizing timeout")
+		})
 }
 
 func (b *Jarvis) GetSummary(ctx context.Context, input *TEInput) (*SummarizingOutput, error) {
-	output := SuccessResponse[SummarizingOutput]{}
-
-	err := b.svc.Post(ctx, "/v1/summarizing/get", input, &output)
-	if err != nil {
-		return nil, err
-	}
-	if output.Result.Error != "" {
-		return nil, errors.New(output.Result.Error)
-	}
-
-	return &output.Result, nil
+	return b.providers.Job(ctx, summarizingJob(input)).GetSummary(ctx, input)
 }
 
 type TranslatingOutput struct {
@@ -168,11 +242,95 @@ type TranslatingOutput struct {
 }
 
 func (b *Jarvis) Translate(ctx context.Context, input *TEInput) (*TranslatingOutput, error) {
-	o0 := SuccessResponse[TEOutput]{}
-	if err := b.svc.Post(ctx, "/v1/translating", input, &o0); err != nil {
+	return b.translate(ctx, input, 0, translatingJob(input))
+}
+
+// TranslateChunks translates the chunks one by one, and reassembles the
+// translated contents in order. Each chunk is a job of its own, with the tail
+// of the previous translated chunk as context to keep the terms consistent.
+// onChunk will be called after each chunk translated.
+func (b *Jarvis) TranslateChunks(ctx context.Context, input *TEInput, chunks []content.TEContents, onChunk func(done int)) (*TranslatingOutput, error) {
+	var output *TranslatingOutput
+	contents := make(content.TEContents, 0)
+	tokens := uint32(0)
+	for i, chunk := range chunks {
+		data, err := cbor.Marshal(chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		ci := *input
+		ci.Content = util.Ptr(util.Bytes(data))
+		after := int64(0)
+		if output != nil {
+			// the chunks are written to the same translation, skip the previous result
+			after = output.UpdatedAt
+		}
+
+		var te content.TEContents
+		ci.Context = chunkContext(input.Context, contents)
+		output, err = b.translate(ctx, &ci, after, fmt.Sprintf("%s:%d", translatingJob(input), i))
+		if err == nil {
+			err = cbor.Unmarshal(output.Content, &te)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d/%d: %w", i+1, len(chunks), err)
+		}
+
+		contents = append(contents, te...)
+		tokens += output.Tokens
+		if onChunk != nil {
+			onChunk(i + 1)
+		}
+	}
+
+	if output == nil {
+		return nil, errors.New("no content to translate")
+	}
+
+	data, err := cbor.Marshal(contents)
+	if err != nil {
 		return nil, err
 	}
+	output.Tokens = tokens
+	output.Content = data
+	return output, nil
+}
 
+// maxChunkContext is the max length in runes of the previous translation
+// passed as context of the next chunk.
+const maxChunkContext = 1000
+
+// chunkContext returns the context of the user with the tail of the translated
+// contents.
+func chunkContext(userContext *string, translated content.TEContents) *string {
+	texts := make([]string, 0)
+	size := 0
+	for i := len(translated) - 1; i >= 0 && size < maxChunkContext; i-- {
+		for j := len(translated[i].Texts) - 1; j >= 0 && size < maxChunkContext; j-- {
+			text := []rune(translated[i].Texts[j])
+			if n := maxChunkContext - size; len(text) > n {
+				text = text[len(text)-n:]
+			}
+			texts = append(texts, string(text))
+			size += len(text)
+		}
+	}
+	if len(texts) == 0 {
+		return userContext
+	}
+
+	for i, j := 0, len(texts)-1; i < j; i, j = i+1, j-1 {
+		texts[i], texts[j] = texts[j], texts[i]
+	}
+	ctx := "Previous translated text:\n" + strings.Join(texts, "\n")
+	if userContext != nil && *userContext != "" {
+		ctx = *userContext + "\n\n" + ctx
+	}
+	return &ctx
+}
+
+func (b *Jarvis) translate(ctx context.Context, input *TEInput, after int64, job string) (*TranslatingOutput, error) {
 	getInput := &TEInput{
 		GID:      input.GID,
 		CID:      input.CID,
@@ -180,23 +338,26 @@ func (b *Jarvis) Translate(ctx context.Context, input *TEInput) (*TranslatingOut
 		Version:  input.Version,
 	}
 
-	i := 0
-	for {
-		i += 1
-		if i > 1200 {
-			return nil, errors.New("translating timeout")
-		}
-
-		time.Sleep(time.Second * 3)
-		output, err := b.GetTranslation(ctx, getInput)
-		if err != nil {
-			return nil, err
-		}
-
-		if output.Progress == 100 && len(output.Content) > 0 {
-			return output, nil
-		}
-	}
+	base := translatingJob(input)
+	providers := b.providers.Route(routeOf(input))
+	return runWithFailover(ctx, b.providers, providers, job,
+		func(p Provider) (*TranslatingOutput, error) {
+			if job != base {
+				// the translation is read by the base job
+				b.providers.setJob(ctx, base, p)
+			}
+			if err := p.Translate(ctx, input); err != nil {
+				return nil, err
+			}
+
+			return poll(func() (*TranslatingOutput, error) {
+				output, err := p.GetTranslation(ctx, getInput)
+				if err != nil || (output.Progress == 100 && len(output.Content) > 0 && output.UpdatedAt > after) {
+					return output, err
+				}
+				return nil, nil
+			}, "translating timeout")
+		})
 }
 
 type TMInput struct {
@@ -218,62 +379,75 @@ type TMOutput struct {
 }
 
 func (b *Jarvis) TranslateMessage(ctx context.Context, input *TMInput) (*TMOutput, error) {
-	o0 := SuccessResponse[TMOutput]{}
-	if err := b.svc.Post(ctx, "/v1/message/translating", input, &o0); err != nil {
-		return nil, err
-	}
-
 	getInput := &TMInput{
 		ID:       input.ID,
 		Language: input.Language,
 		Version:  input.Version,
 	}
 
-	i := 0
-	for {
-		i += 1
-		if i > 1200 {
-			return nil, errors.New("translating timeout")
-		}
-
-		time.Sleep(time.Second * 3)
-		output, err := b.GetMessageTranslation(ctx, getInput)
-		if err != nil {
-			return nil, err
-		}
-
-		if output.Progress == 100 && len(output.Content) > 0 {
-			return output, nil
-		}
+	route := &RouteInput{To: input.Language}
+	if input.Model != nil {
+		route.Model = *input.Model
 	}
+	if input.FromLanguage != nil {
+		route.From = *input.FromLanguage
+	}
+	return runWithFailover(ctx, b.providers, b.providers.Route(route), messageTranslatingJob(input),
+		func(p Provider) (*TMOutput, error) {
+			if err := p.TranslateMessage(ctx, input); err != nil {
+				return nil, err
+			}
+
+			return poll(func() (*TMOutput, error) {
+				output, err := p.GetMessageTranslation(ctx, getInput)
+				if err != nil || (output.Progress == 100 && len(output.Content) > 0) {
+					return output, err
+				}
+				return nil, nil
+			}, "translating timeout")
+		})
 }
 
 func (b *Jarvis) GetMessageTranslation(ctx context.Context, input *TMInput) (*TMOutput, error) {
-	output := SuccessResponse[TMOutput]{}
-
-	err := b.svc.Post(ctx, "/v1/message/translating/get", input, &output)
-	if err != nil {
-		return nil, err
-	}
-	if output.Result.Error != "" {
-		return nil, errors.New(output.Result.Error)
-	}
-
-	return &output.Result, nil
+	return b.providers.Job(ctx, messageTranslatingJob(input)).GetMessageTranslation(ctx, input)
 }
 
 func (b *Jarvis) GetTranslation(ctx context.Context, input *TEInput) (*TranslatingOutput, error) {
-	output := SuccessResponse[TranslatingOutput]{}
+	return b.providers.Job(ctx, translatingJob(input)).GetTranslation(ctx, input)
+}
 
-	err := b.svc.Post(ctx, "/v1/translating/get", input, &output)
-	if err != nil {
-		return nil, err
+func routeOf(input *TEInput) *RouteInput {
+	route := &RouteInput{GID: input.GID, To: input.Language}
+	if input.Model != nil {
+		route.Model = *input.Model
 	}
-	if output.Result.Error != "" {
-		return nil, errors.New(output.Result.Error)
+	if input.FromLanguage != nil {
+		route.From = *input.FromLanguage
 	}
+	return route
+}
 
-	return &output.Result, nil
+// poll calls fn every 3 seconds until it returns an output or error, up to 1 hour.
+// errPollTimeout is returned when a job is not finished in time. The job is not
+// failed over then, the lock of the job may have expired and it may be still
+// running on the provider.
+var errPollTimeout = errors.New("poll timeout")
+
+func poll[T any](fn func() (*T, error), timeoutMsg string) (*T, error) {
+	for i := 0; i < 1200; i++ {
+		if i > 0 {
+			time.Sleep(time.Second * 3)
+		}
+
+		output, err := fn()
+		if err != nil {
+			return nil, err
+		}
+		if output != nil {
+			return output, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", timeoutMsg, errPollTimeout)
 }
 
 // func (b *Jarvis) Embedding(ctx context.Context, input *TEInput) (*TEOutput, error) {
@@ -44,6 +44,7 @@ const (
 	LogActionCreationUpdate           = "creation.update"
 	LogActionCreationUpdateContent    = "creation.update.content"
 	LogActionCreationRelease          = "creation.release"
+	LogActionCreationSummarize        = "creation.summarize"
 	LogActionCreationDelete           = "creation.delete"
 	LogActionCreationAssist           = "creation.assist"
 	LogActionCreationTransfer         = "creation.transfer"
@@ -54,14 +55,21 @@ const (
 	LogActionPublicationPublish       = "publication.publish"
 	LogActionPublicationDelete        = "publication.delete"
 	LogActionPublicationAssist        = "publication.assist"
+	LogActionTranslationRequest       = "translation.request"
+	LogActionTranslationAccept        = "translation.accept"
 	LogActionMessageCreate            = "message.create"
 	LogActionMessageUpdate            = "message.update"
 	LogActionMessageDelete            = "message.delete"
 	LogActionCollectionCreate         = "collection.create"
 	LogActionCollectionUpdate         = "collection.update"
 	LogActionCollectionUpdateChildren = "collection.update.children"
+	LogActionCollectionTranslate      = "collection.translate.children"
 	LogActionCollectionDelete         = "collection.delete"
 	LogActionCollectionSubscribe      = "collection.subscribe"
+	LogActionGroupSubscribe           = "group.subscribe"
+	LogActionSubscriptionRenew        = "subscription.renew"
+	LogActionGiftPurchase             = "gift.purchase"
+	LogActionGiftRedeem               = "gift.redeem"
 )
 
 type Logbase struct {
@@ -108,14 +116,104 @@ type CreateLogInput struct {
 }
 
 type LogPayload struct {
-	GID      util.ID `json:"gid" cbor:"gid"`
-	CID      util.ID `json:"cid" cbor:"cid"`
-	Version  *uint16 `json:"version,omitempty" cbor:"version,omitempty"`
-	Language *string `json:"language,omitempty" cbor:"language,omitempty"`
-	Kind     *int8   `json:"kind,omitempty" cbor:"kind,omitempty"`
-	Status   *int8   `json:"status,omitempty" cbor:"status,omitempty"`
-	Rating   *int8   `json:"rating,omitempty" cbor:"rating,omitempty"`
-	Price    *int64  `json:"price,omitempty" cbor:"price,omitempty"`
+	GID      util.ID  `json:"gid" cbor:"gid"`
+	CID      util.ID  `json:"cid" cbor:"cid"`
+	Version  *uint16  `json:"version,omitempty" cbor:"version,omitempty"`
+	Language *string  `json:"language,omitempty" cbor:"language,omitempty"`
+	Kind     *int8    `json:"kind,omitempty" cbor:"kind,omitempty"`
+	Status   *int8    `json:"status,omitempty" cbor:"status,omitempty"`
+	Rating   *int8    `json:"rating,omitempty" cbor:"rating,omitempty"`
+	Price    *int64   `json:"price,omitempty" cbor:"price,omitempty"`
+	Txn      *util.ID `json:"txn,omitempty" cbor:"txn,omitempty"`
+	Chunks   *uint16  `json:"chunks,omitempty" cbor:"chunks,omitempty"` // total chunks of a chunked translating job
+	Chunk    *uint16  `json:"chunk,omitempty" cbor:"chunk,omitempty"`   // translated chunks
+	Refund   *util.ID `json:"refund,omitempty" cbor:"refund,omitempty"` // the latest refund request log id of the job
+}
+
+// Progress returns the combined progress of a chunked job from the progress
+// of the translating chunk.
+func (p *LogPayload) Progress(progress int8) int8 {
+	if p.Chunks == nil || *p.Chunks <= 1 {
+		return progress
+	}
+
+	total := int(*p.Chunks)
+	done := 0
+	if p.Chunk != nil {
+		done = int(*p.Chunk)
+	}
+	if done >= total {
+		return progress
+	}
+	return int8((done*100 + int(progress)) / total)
+}
+
+// SummarizePayload is the payload of a summarizing job, the result is saved
+// to it when the job is done so that a preview can be polled by the job.
+type SummarizePayload struct {
+	LogPayload
+	Model     string           `json:"model" cbor:"model"`
+	MaxLength uint16           `json:"max_length" cbor:"max_length"`
+	Preview   bool             `json:"preview" cbor:"preview"`
+	Result    *SummarizeOutput `json:"result,omitempty" cbor:"result,omitempty"`
+}
+
+const (
+	BatchItemPending int8 = 0
+	BatchItemDone    int8 = 1
+	BatchItemFailed  int8 = -1
+	BatchItemSkipped int8 = 2
+)
+
+// BatchPayload is the payload of a batch translating job, with the status of each item.
+type BatchPayload struct {
+	GID       util.ID     `json:"gid" cbor:"gid"`
+	ID        util.ID     `json:"id" cbor:"id"`
+	Language  string      `json:"language" cbor:"language"` // target language
+	Model     string      `json:"model" cbor:"model"`
+	Items     []BatchItem `json:"items" cbor:"items"`
+	Truncated bool        `json:"truncated,omitempty" cbor:"truncated,omitempty"` // more items are left for a new job
+	Tokens    uint32      `json:"tokens,omitempty" cbor:"tokens,omitempty"`       // estimated tokens of the pending items
+	Cost      int64       `json:"cost,omitempty" cbor:"cost,omitempty"`           // estimated cost in WEN of the pending items
+}
+
+type BatchItem struct {
+	GID      util.ID  `json:"gid" cbor:"gid"`
+	CID      util.ID  `json:"cid" cbor:"cid"`
+	Language string   `json:"language" cbor:"language"`
+	Version  uint16   `json:"version" cbor:"version"`
+	Status   int8     `json:"status" cbor:"status"`
+	Tokens   uint32   `json:"tokens,omitempty" cbor:"tokens,omitempty"`
+	Txn      *util.ID `json:"txn,omitempty" cbor:"txn,omitempty"`
+	Error    string   `json:"error,omitempty" cbor:"error,omitempty"`
+}
+
+// Progress returns the percentage of finished items.
+func (p *BatchPayload) Progress() int8 {
+	if len(p.Items) == 0 {
+		return 100
+	}
+
+	done := 0
+	for _, item := range p.Items {
+		if item.Status != BatchItemPending {
+			done++
+		}
+	}
+	return int8(done * 100 / len(p.Items))
+}
+
+type RefundPayload struct {
+	Job      util.ID  `json:"job" cbor:"job"` // the original job log id
+	Txn      util.ID  `json:"txn" cbor:"txn"`
+	GID      util.ID  `json:"gid" cbor:"gid"`
+	CID      util.ID  `json:"cid" cbor:"cid"`
+	Language *string  `json:"language,omitempty" cbor:"language,omitempty"`
+	Version  *uint16  `json:"version,omitempty" cbor:"version,omitempty"`
+	Amount   int64    `json:"amount" cbor:"amount"`
+	Reason   string   `json:"reason" cbor:"reason"`
+	Refund   *util.ID `json:"refund,omitempty" cbor:"refund,omitempty"` // the refund request log id, for review log
+	Task     *util.ID `json:"task,omitempty" cbor:"task,omitempty"`     // the "user.refund" task id
 }
 
 type LogMessage struct {
@@ -0,0 +1,186 @@
+package bll
+
+import (
+	"context"
+	"sort"
+
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/logging"
+	"github.com/yiwen-ai/yiwen-api/src/service"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+// Membership manages the paid membership of groups. A member who subscribed
+// a group can read all paid content of the group.
+type Membership struct {
+	redis   *service.Redis
+	writing *Writing
+}
+
+const membershipCacheTTL = 600 // seconds
+
+type MembershipPlan struct {
+	Price    int64 `json:"price" cbor:"price" validate:"gte=0,lte=1000000"`        // 0 means membership is disabled
+	Duration int64 `json:"duration" cbor:"duration" validate:"gte=0,lte=94608000"` // seconds, max 3 years
+}
+
+type UpdateMembershipPlanInput struct {
+	GID util.ID `json:"gid" cbor:"gid" validate:"required"`
+	MembershipPlan
+}
+
+func (i *UpdateMembershipPlanInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+	if i.Price > 0 && i.Duration < 3600*24 {
+		return gear.ErrBadRequest.WithMsg("duration should be at least one day")
+	}
+
+	return nil
+}
+
+type MembershipOutput struct {
+	GID util.ID `json:"gid" cbor:"gid"`
+	MembershipPlan
+	Subscription *SubscriptionOutput `json:"subscription,omitempty" cbor:"subscription,omitempty"`
+	SubToken     *string             `json:"subtoken,omitempty" cbor:"subtoken,omitempty"`
+}
+
+type GroupSubscriptionOutput struct {
+	SubscriptionOutput
+	GroupInfo *GroupInfo `json:"group_info,omitempty" cbor:"group_info,omitempty"`
+	SubToken  *string    `json:"subtoken,omitempty" cbor:"subtoken,omitempty"`
+}
+
+type GroupSubscriptionOutputs []GroupSubscriptionOutput
+
+func (list *GroupSubscriptionOutputs) LoadGroups(loader func(ids ...util.ID) []GroupInfo) {
+	if len(*list) == 0 {
+		return
+	}
+
+	ids := make([]util.ID, 0, len(*list))
+	for _, v := range *list {
+		ids = append(ids, v.GID)
+	}
+
+	groups := loader(ids...)
+	if len(groups) == 0 {
+		return
+	}
+
+	infoMap := make(map[util.ID]*GroupInfo, len(groups))
+	for i := range groups {
+		infoMap[groups[i].ID] = &groups[i]
+	}
+
+	for i := range *list {
+		(*list)[i].GroupInfo = infoMap[(*list)[i].GID]
+	}
+}
+
+func (b *Membership) GetPlan(ctx context.Context, gid util.ID) (*MembershipPlan, error) {
+	output := &MembershipPlan{}
+	key := membershipPlanKey(gid)
+	if err := b.redis.GetCBOR(ctx, key, output); err == nil {
+		return output, nil
+	}
+
+	plan, err := b.writing.InternalGetGroupMembership(ctx, gid)
+	if err != nil {
+		if er := gear.ErrInternalServerError.From(err); er.Code != 404 {
+			return nil, err
+		}
+		plan = output
+	}
+
+	b.cache(ctx, key, plan)
+	return plan, nil
+}
+
+func (b *Membership) SetPlan(ctx context.Context, gid util.ID, plan *MembershipPlan) error {
+	if _, err := b.writing.InternalUpdateGroupMembership(ctx, &UpdateMembershipPlanInput{
+		GID:            gid,
+		MembershipPlan: *plan,
+	}); err != nil {
+		return err
+	}
+
+	b.uncache(ctx, membershipPlanKey(gid))
+	return nil
+}
+
+// Subscriptions returns the group subscriptions of the user, the latest expired first.
+func (b *Membership) Subscriptions(ctx context.Context, uid util.ID) ([]SubscriptionOutput, error) {
+	output := []SubscriptionOutput{}
+	key := membershipSubsKey(uid)
+	if err := b.redis.GetCBOR(ctx, key, &output); err == nil {
+		return output, nil
+	}
+
+	output, err := b.writing.InternalListGroupSubscriptions(ctx, uid)
+	if err != nil {
+		if er := gear.ErrInternalServerError.From(err); er.Code != 404 {
+			return nil, err
+		}
+	}
+	if output == nil {
+		output = []SubscriptionOutput{}
+	}
+
+	sort.SliceStable(output, func(i, j int) bool { return output[i].ExpireAt > output[j].ExpireAt })
+	b.cache(ctx, key, output)
+	return output, nil
+}
+
+// Get returns the user's subscription of the group, or nil if not subscribed.
+func (b *Membership) Get(ctx context.Context, uid, gid util.ID) (*SubscriptionOutput, error) {
+	subs, err := b.Subscriptions(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range subs {
+		if subs[i].GID == gid {
+			return &subs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Subscribe creates or renews the user's subscription of the group,
+// input.CID is the group id. input.UpdatedAt should be the UpdatedAt of the
+// subscription read before, it fails with 409 if the subscription is changed.
+func (b *Membership) Subscribe(ctx context.Context, input *SubscriptionInput) (*SubscriptionOutput, error) {
+	output, err := b.writing.InternalUpdateGroupSubscription(ctx, input)
+	// the cached subscriptions may be stale even if failed
+	b.uncache(ctx, membershipSubsKey(input.UID))
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// cache and uncache keep the plans and subscriptions stored in the writing
+// service in redis for a while, a failure only costs a reload.
+func (b *Membership) cache(ctx context.Context, key string, val any) {
+	if err := b.redis.SetCBOR(ctx, key, val, membershipCacheTTL); err != nil {
+		logging.Warningf("Membership: failed to cache %s: %v", key, err)
+	}
+}
+
+func (b *Membership) uncache(ctx context.Context, key string) {
+	if err := b.redis.Del(ctx, key); err != nil {
+		logging.Warningf("Membership: failed to uncache %s: %v", key, err)
+	}
+}
+
+func membershipPlanKey(gid util.ID) string {
+	return "gm:plan:" + gid.String()
+}
+
+func membershipSubsKey(uid util.ID) string {
+	return "gm:subs:" + uid.String()
+}
@@ -0,0 +1,79 @@
+package bll
+
+import (
+	"context"
+	"time"
+
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/service"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+// PayCode tracks the nonces of the issued payment codes, so that a code
+// can be used only once and can be revoked before it expires.
+type PayCode struct {
+	redis *service.Redis
+}
+
+// Issue returns a new nonce and the current epoch of the user.
+func (b *PayCode) Issue(ctx context.Context, uid util.ID, ttl uint) (util.ID, int64, error) {
+	epochs, err := b.redis.GetInts(ctx, payCodeEpochKey(uid))
+	if err != nil {
+		return util.ZeroID, 0, err
+	}
+
+	nonce := util.NewID()
+	if err = b.redis.SetCBOR(ctx, payCodeNonceKey(nonce), uid, ttl); err != nil {
+		return util.ZeroID, 0, err
+	}
+	return nonce, epochs[0], nil
+}
+
+// Consume uses up the nonce atomically, it fails if the code has been used or revoked.
+func (b *PayCode) Consume(ctx context.Context, uid, nonce util.ID, epoch int64) error {
+	epochs, err := b.redis.GetInts(ctx, payCodeEpochKey(uid))
+	if err != nil {
+		return err
+	}
+	if epochs[0] != epoch {
+		return gear.ErrConflict.WithMsg("payment code revoked")
+	}
+
+	ok, err := b.redis.Take(ctx, payCodeNonceKey(nonce))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return gear.ErrConflict.WithMsg("payment code used or revoked")
+	}
+	return nil
+}
+
+// Restore puts back the consumed nonce if the payment failed, unless the code expired.
+func (b *PayCode) Restore(ctx context.Context, uid, nonce util.ID, expireAt int64) error {
+	ttl := expireAt - time.Now().Unix()
+	if ttl <= 0 {
+		return nil
+	}
+	_, err := b.redis.SetCBORNX(ctx, payCodeNonceKey(nonce), uid, uint(ttl))
+	return err
+}
+
+func (b *PayCode) Revoke(ctx context.Context, nonce util.ID) error {
+	return b.redis.Del(ctx, payCodeNonceKey(nonce))
+}
+
+// RevokeAll revokes all outstanding codes of the user.
+func (b *PayCode) RevokeAll(ctx context.Context, uid util.ID) error {
+	_, err := b.redis.IncrByMulti(ctx, []string{payCodeEpochKey(uid)}, 1, 0)
+	return err
+}
+
+func payCodeNonceKey(nonce util.ID) string {
+	return "pc:nonce:" + nonce.String()
+}
+
+func payCodeEpochKey(uid util.ID) string {
+	return "pc:epoch:" + uid.String()
+}
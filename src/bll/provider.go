@@ -0,0 +1,254 @@
+package bll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yiwen-ai/yiwen-api/src/conf"
+	"github.com/yiwen-ai/yiwen-api/src/logging"
+	"github.com/yiwen-ai/yiwen-api/src/service"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+const providerJobTTL = 3600 * 24 * 3 // seconds
+
+// Provider is a backend of AI jobs: translating, summarizing and language detection.
+// Translate, TranslateMessage and Summarize start an async job, the result
+// should be polled by the Get methods.
+type Provider interface {
+	Name() string
+	ListLanguages(ctx context.Context) ([][]string, error)
+	DetectLang(ctx context.Context, input *DetectLangInput) (*TEOutput, error)
+	Translate(ctx context.Context, input *TEInput) error
+	GetTranslation(ctx context.Context, input *TEInput) (*TranslatingOutput, error)
+	TranslateMessage(ctx context.Context, input *TMInput) error
+	GetMessageTranslation(ctx context.Context, input *TMInput) (*TMOutput, error)
+	Summarize(ctx context.Context, input *TEInput) error
+	GetSummary(ctx context.Context, input *TEInput) (*SummarizingOutput, error)
+}
+
+func NewProvider(cfg conf.Provider) Provider {
+	switch cfg.Kind {
+	case "fake":
+		return NewFakeProvider(cfg.Name)
+	default:
+		return &jarvisProvider{name: cfg.Name, svc: service.APIHost(cfg.Endpoint)}
+	}
+}
+
+// RouteInput is the conditions to pick providers for a job.
+type RouteInput struct {
+	GID   util.ID
+	Model string
+	From  string
+	To    string
+}
+
+// Providers picks providers by routing rules, and remembers the provider of
+// each job so that the job can be polled from any instance.
+type Providers struct {
+	redis  *service.Redis
+	list   []Provider
+	byName map[string]Provider
+	routes []conf.ProviderRoute
+}
+
+func NewProviders(redis *service.Redis, providers []conf.Provider, routes []conf.ProviderRoute) *Providers {
+	ps := &Providers{
+		redis:  redis,
+		list:   make([]Provider, 0, len(providers)),
+		byName: make(map[string]Provider, len(providers)),
+		routes: routes,
+	}
+	for _, cfg := range providers {
+		p := NewProvider(cfg)
+		ps.list = append(ps.list, p)
+		ps.byName[cfg.Name] = p
+	}
+	return ps
+}
+
+// Default returns all providers in failover order.
+func (ps *Providers) Default() []Provider {
+	return ps.list
+}
+
+// Route returns the providers of the first matched rule in failover order,
+// or all providers if no rule matched.
+func (ps *Providers) Route(input *RouteInput) []Provider {
+	for _, r := range ps.routes {
+		if !routeHas(r.Models, input.Model) ||
+			!routeHas(r.FromLanguages, input.From) ||
+			!routeHas(r.ToLanguages, input.To) ||
+			(len(r.Groups) > 0 && !util.SliceHas(r.Groups, input.GID)) {
+			continue
+		}
+
+		list := make([]Provider, 0, len(r.Providers))
+		for _, name := range r.Providers {
+			if p, ok := ps.byName[name]; ok {
+				list = append(list, p)
+			}
+		}
+		if len(list) > 0 {
+			return list
+		}
+	}
+	return ps.list
+}
+
+// Job returns the provider that is running the job, or the default provider.
+func (ps *Providers) Job(ctx context.Context, job string) Provider {
+	var name string
+	if ps.redis != nil {
+		if err := ps.redis.GetCBOR(ctx, providerJobKey(job), &name); err == nil {
+			if p, ok := ps.byName[name]; ok {
+				return p
+			}
+		}
+	}
+	return ps.list[0]
+}
+
+func (ps *Providers) setJob(ctx context.Context, job string, p Provider) {
+	if ps.redis == nil {
+		return
+	}
+	if err := ps.redis.SetCBOR(ctx, providerJobKey(job), p.Name(), providerJobTTL); err != nil {
+		logging.Warningf("Providers.setJob: %v", err)
+	}
+}
+
+// runWithFailover runs fn with the providers in order until one succeeded.
+// It does not fail over when polling timeout.
+func runWithFailover[T any](ctx context.Context, ps *Providers, providers []Provider, job string, fn func(p Provider) (T, error)) (T, error) {
+	var output T
+	err := errors.New("no provider available")
+	for i, p := range providers {
+		if i > 0 {
+			logging.Warningf("Providers: %s failed, failover to %s: %v", providers[i-1].Name(), p.Name(), err)
+		}
+		if job != "" {
+			ps.setJob(ctx, job, p)
+		}
+
+		output, err = fn(p)
+		if err == nil || ctx.Err() != nil || errors.Is(err, errPollTimeout) {
+			break
+		}
+	}
+	return output, err
+}
+
+func routeHas(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	return util.SliceHas(list, strings.ToLower(v))
+}
+
+func providerJobKey(job string) string {
+	return "aijob:" + job
+}
+
+func translatingJob(input *TEInput) string {
+	return fmt.Sprintf("te:%s:%s:%s:%d", input.GID.String(), input.CID.String(), input.Language, input.Version)
+}
+
+func messageTranslatingJob(input *TMInput) string {
+	return fmt.Sprintf("tm:%s:%s:%d", input.ID.String(), input.Language, input.Version)
+}
+
+func summarizingJob(input *TEInput) string {
+	return fmt.Sprintf("su:%s:%s:%s:%d", input.GID.String(), input.CID.String(), input.Language, input.Version)
+}
+
+// jarvisProvider is the provider backed by the Jarvis service.
+type jarvisProvider struct {
+	name string
+	svc  service.APIHost
+}
+
+func (p *jarvisProvider) Name() string {
+	return p.name
+}
+
+func (p *jarvisProvider) ListLanguages(ctx context.Context) ([][]string, error) {
+	output := SuccessResponse[[][]string]{}
+	if err := p.svc.Get(ctx, "/v1/translating/list_languages", &output); err != nil {
+		return nil, err
+	}
+
+	return output.Result, nil
+}
+
+func (p *jarvisProvider) DetectLang(ctx context.Context, input *DetectLangInput) (*TEOutput, error) {
+	output := SuccessResponse[TEOutput]{}
+	if err := p.svc.Post(ctx, "/v1/translating/detect_language", input, &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+func (p *jarvisProvider) Translate(ctx context.Context, input *TEInput) error {
+	output := SuccessResponse[TEOutput]{}
+	return p.svc.Post(ctx, "/v1/translating", input, &output)
+}
+
+func (p *jarvisProvider) GetTranslation(ctx context.Context, input *TEInput) (*TranslatingOutput, error) {
+	output := SuccessResponse[TranslatingOutput]{}
+
+	err := p.svc.Post(ctx, "/v1/translating/get", input, &output)
+	if err != nil {
+		return nil, err
+	}
+	if output.Result.Error != "" {
+		return nil, errors.New(output.Result.Error)
+	}
+
+	return &output.Result, nil
+}
+
+func (p *jarvisProvider) TranslateMessage(ctx context.Context, input *TMInput) error {
+	output := SuccessResponse[TMOutput]{}
+	return p.svc.Post(ctx, "/v1/message/translating", input, &output)
+}
+
+func (p *jarvisProvider) GetMessageTranslation(ctx context.Context, input *TMInput) (*TMOutput, error) {
+	output := SuccessResponse[TMOutput]{}
+
+	err := p.svc.Post(ctx, "/v1/message/translating/get", input, &output)
+	if err != nil {
+		return nil, err
+	}
+	if output.Result.Error != "" {
+		return nil, errors.New(output.Result.Error)
+	}
+
+	return &output.Result, nil
+}
+
+func (p *jarvisProvider) Summarize(ctx context.Context, input *TEInput) error {
+	output := SuccessResponse[TEOutput]{}
+	return p.svc.Post(ctx, "/v1/summarizing", input, &output)
+}
+
+func (p *jarvisProvider) GetSummary(ctx context.Context, input *TEInput) (*SummarizingOutput, error) {
+	output := SuccessResponse[SummarizingOutput]{}
+
+	err := p.svc.Post(ctx, "/v1/summarizing/get", input, &output)
+	if err != nil {
+		return nil, err
+	}
+	if output.Result.Error != "" {
+		return nil, errors.New(output.Result.Error)
+	}
+
+	return &output.Result, nil
+}
+
+var _ Provider = (*jarvisProvider)(nil)
+var _ Provider = (*FakeProvider)(nil)
@@ -0,0 +1,212 @@
+package bll
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/content"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+// FakeProvider is a deterministic local provider for tests and development,
+// it completes jobs immediately without network access.
+// Translated texts are prefixed with the target language, e.g. "[zho] Hello".
+type FakeProvider struct {
+	name    string
+	mu      sync.Mutex
+	updated int64
+	jobs    map[string]any
+}
+
+func NewFakeProvider(name string) *FakeProvider {
+	return &FakeProvider{name: name, jobs: make(map[string]any)}
+}
+
+func (p *FakeProvider) Name() string {
+	return p.name
+}
+
+func (p *FakeProvider) ListLanguages(ctx context.Context) ([][]string, error) {
+	output := make([][]string, 0, len(util.Languages))
+	for _, vv := range util.Languages {
+		output = append(output, []string{vv[1], vv[2], vv[3]})
+	}
+	return output, nil
+}
+
+func (p *FakeProvider) DetectLang(ctx context.Context, input *DetectLangInput) (*TEOutput, error) {
+	if output := DetectLangLocal(input.Content); output != nil {
+		return output, nil
+	}
+	lang := util.Lang639_3(input.Language)
+	if lang == "" {
+		lang = "eng"
+	}
+	return &TEOutput{Language: lang}, nil
+}
+
+func (p *FakeProvider) Translate(ctx context.Context, input *TEInput) error {
+	te, tokens, err := fakeTranslate(input.Content, input.Language)
+	if err != nil {
+		return err
+	}
+	data, err := cbor.Marshal(te)
+	if err != nil {
+		return err
+	}
+
+	p.set(translatingJob(input), &TranslatingOutput{
+		GID:       input.GID,
+		CID:       input.CID,
+		Language:  input.Language,
+		Version:   input.Version,
+		Model:     fakeModel(input.Model),
+		Progress:  100,
+		UpdatedAt: p.now(),
+		Tokens:    tokens,
+		Content:   data,
+	})
+	return nil
+}
+
+func (p *FakeProvider) GetTranslation(ctx context.Context, input *TEInput) (*TranslatingOutput, error) {
+	if v, ok := p.get(translatingJob(input)).(*TranslatingOutput); ok {
+		return v, nil
+	}
+	return nil, gear.ErrNotFound.WithMsg("translating job not found")
+}
+
+func (p *FakeProvider) TranslateMessage(ctx context.Context, input *TMInput) error {
+	te, tokens, err := fakeTranslate(input.Content, input.Language)
+	if err != nil {
+		return err
+	}
+	data, err := cbor.Marshal(te)
+	if err != nil {
+		return err
+	}
+
+	p.set(messageTranslatingJob(input), &TMOutput{
+		Model:    fakeModel(input.Model),
+		Progress: 100,
+		Tokens:   tokens,
+		Content:  data,
+	})
+	return nil
+}
+
+func (p *FakeProvider) GetMessageTranslation(ctx context.Context, input *TMInput) (*TMOutput, error) {
+	if v, ok := p.get(messageTranslatingJob(input)).(*TMOutput); ok {
+		return v, nil
+	}
+	return nil, gear.ErrNotFound.WithMsg("translating job not found")
+}
+
+func (p *FakeProvider) Summarize(ctx context.Context, input *TEInput) error {
+	te := content.TEContents{}
+	if input.Content != nil {
+		if err := cbor.Unmarshal(*input.Content, &te); err != nil {
+			return gear.ErrBadRequest.From(err)
+		}
+	}
+
+	texts := make([]string, 0)
+	keywords := make([]string, 0, 5)
+	for _, v := range te {
+		for _, text := range v.Texts {
+			texts = append(texts, text)
+			for _, w := range strings.Fields(text) {
+				if len(keywords) < 5 && utf8.RuneCountInString(w) > 3 && !util.SliceHas(keywords, w) {
+					keywords = append(keywords, w)
+				}
+			}
+		}
+	}
+
+	maxLength := 200
+	if input.MaxLength != nil && *input.MaxLength > 0 {
+		maxLength = int(*input.MaxLength)
+	}
+	summary := []rune(strings.Join(texts, " "))
+	if len(summary) > maxLength {
+		summary = summary[:maxLength]
+	}
+	if len(summary) == 0 {
+		summary = []rune("empty")
+	}
+
+	p.set(summarizingJob(input), &SummarizingOutput{
+		GID:       input.GID,
+		CID:       input.CID,
+		Language:  input.Language,
+		Version:   input.Version,
+		Model:     fakeModel(input.Model),
+		Tokens:    te.Tokens(),
+		Progress:  100,
+		UpdatedAt: p.now(),
+		Summary:   string(summary),
+		Keywords:  keywords,
+	})
+	return nil
+}
+
+func (p *FakeProvider) GetSummary(ctx context.Context, input *TEInput) (*SummarizingOutput, error) {
+	if v, ok := p.get(summarizingJob(input)).(*SummarizingOutput); ok {
+		return v, nil
+	}
+	return nil, gear.ErrNotFound.WithMsg("summarizing job not found")
+}
+
+func (p *FakeProvider) set(job string, output any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jobs[job] = output
+}
+
+func (p *FakeProvider) get(job string) any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jobs[job]
+}
+
+// now returns a strictly increasing timestamp in milliseconds.
+func (p *FakeProvider) now() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now().UnixMilli()
+	if now <= p.updated {
+		now = p.updated + 1
+	}
+	p.updated = now
+	return now
+}
+
+func fakeModel(model *string) string {
+	if model != nil && *model != "" {
+		return *model
+	}
+	return DefaultModel.ID
+}
+
+func fakeTranslate(data *util.Bytes, language string) (content.TEContents, uint32, error) {
+	te := content.TEContents{}
+	if data != nil {
+		if err := cbor.Unmarshal(*data, &te); err != nil {
+			return nil, 0, gear.ErrBadRequest.From(err)
+		}
+	}
+
+	tokens := te.Tokens()
+	for _, v := range te {
+		for i := range v.Texts {
+			v.Texts[i] = "[" + language + "] " + v.Texts[i]
+		}
+	}
+	return te, tokens + te.Tokens(), nil
+}
@@ -0,0 +1,158 @@
+package bll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yiwen-ai/yiwen-api/src/conf"
+	"github.com/yiwen-ai/yiwen-api/src/content"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+type failingProvider struct {
+	*FakeProvider
+}
+
+func (p *failingProvider) Translate(ctx context.Context, input *TEInput) error {
+	return errors.New("provider unavailable")
+}
+
+func TestProviders(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	gid := util.NewID()
+	ps := NewProviders(nil, []conf.Provider{
+		{Name: "a", Kind: "fake"},
+		{Name: "b", Kind: "fake"},
+	}, []conf.ProviderRoute{
+		{Providers: []string{"b"}, ToLanguages: []string{"zho"}},
+		{Providers: []string{"b", "a"}, Groups: []util.ID{gid}},
+	})
+
+	route := ps.Route(&RouteInput{To: "zho"})
+	assert.Equal(1, len(route))
+	assert.Equal("b", route[0].Name())
+	route = ps.Route(&RouteInput{GID: gid, To: "eng"})
+	assert.Equal(2, len(route))
+	assert.Equal("b", route[0].Name())
+	route = ps.Route(&RouteInput{To: "eng"})
+	assert.Equal("a", route[0].Name())
+
+	// failover from the failing provider
+	ps.list[0] = &failingProvider{NewFakeProvider("a")}
+	ps.byName["a"] = ps.list[0]
+	jarvis := &Jarvis{providers: ps}
+
+	te := content.TEContents{
+		{ID: "title", Texts: []string{"Hello"}},
+		{ID: "p1", Texts: []string{"some text"}},
+	}
+	data, err := cbor.Marshal(te)
+	require.NoError(t, err)
+
+	input := &TEInput{
+		GID:      util.NewID(),
+		CID:      util.NewID(),
+		Language: "fra",
+		Version:  1,
+		Content:  util.Ptr(util.Bytes(data)),
+	}
+	output, err := jarvis.Translate(ctx, input)
+	require.NoError(t, err)
+	assert.Equal(int8(100), output.Progress)
+	assert.True(output.Tokens > 0)
+
+	var res content.TEContents
+	require.NoError(t, cbor.Unmarshal(output.Content, &res))
+	assert.Equal([]string{"[fra] Hello"}, res[0].Texts)
+	assert.Equal([]string{"[fra] some text"}, res[1].Texts)
+
+	// chunks are reassembled in order
+	output, err = jarvis.TranslateChunks(ctx, input, []content.TEContents{te[:1], te[1:]}, nil)
+	require.NoError(t, err)
+	res = nil
+	require.NoError(t, cbor.Unmarshal(output.Content, &res))
+	assert.Equal(2, len(res))
+	assert.Equal("title", res[0].ID)
+	assert.Equal([]string{"[fra] some text"}, res[1].Texts)
+
+	summary, err := jarvis.Summarize(ctx, input)
+	require.NoError(t, err)
+	assert.Equal("Hello some text", summary.Summary)
+
+	input.MaxLength = util.Ptr(uint16(5))
+	summary, err = jarvis.Summarize(ctx, input)
+	require.NoError(t, err)
+	assert.Equal("Hello", summary.Summary)
+
+	data, err = cbor.Marshal(content.TEContents{
+		{ID: "p1", Texts: []string{"All human beings are born free and equal in dignity and rights."}},
+	})
+	require.NoError(t, err)
+	lang, err := jarvis.DetectLang(ctx, &DetectLangInput{GID: util.ANON, Content: data})
+	require.NoError(t, err)
+	assert.Equal("eng", lang.Language)
+	// Latin is shared by many languages, detected by the providers
+	assert.True(lang.Confidence < localDetectConfidence)
+
+	data, err = cbor.Marshal(content.TEContents{
+		{ID: "p1", Texts: []string{"모든 인간은 태어날 때부터 자유로우며 그 존엄과 권리에 있어 동등하다."}},
+	})
+	require.NoError(t, err)
+	lang, err = jarvis.DetectLang(ctx, &DetectLangInput{GID: util.ANON, Content: data})
+	require.NoError(t, err)
+	assert.Equal("kor", lang.Language)
+	assert.True(lang.Confidence >= localDetectConfidence)
+}
+
+func TestChunkContext(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(chunkContext(nil, nil))
+	uc := "a blog about Go"
+	assert.Equal(&uc, chunkContext(&uc, nil))
+
+	te := content.TEContents{
+		{ID: "p1", Texts: []string{"first"}},
+		{ID: "p2", Texts: []string{"second", "third"}},
+	}
+	ctx := chunkContext(&uc, te)
+	assert.Equal("a blog about Go\n\nPrevious translated text:\nfirst\nsecond\nthird", *ctx)
+
+	te = content.TEContents{{ID: "p1", Texts: []string{strings.Repeat("x", maxChunkContext+10)}}}
+	ctx = chunkContext(nil, te)
+	assert.Equal(len("Previous translated text:\n")+maxChunkContext, len(*ctx))
+}
+
+func TestRunWithFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	ps := NewProviders(nil, []conf.Provider{
+		{Name: "a", Kind: "fake"},
+		{Name: "b", Kind: "fake"},
+	}, nil)
+
+	called := make([]string, 0)
+	_, err := runWithFailover(context.Background(), ps, ps.list, "", func(p Provider) (int, error) {
+		called = append(called, p.Name())
+		return 0, errors.New("provider unavailable")
+	})
+	assert.Error(err)
+	assert.Equal([]string{"a", "b"}, called)
+
+	called = called[:0]
+	_, err = runWithFailover(context.Background(), ps, ps.list, "", func(p Provider) (int, error) {
+		called = append(called, p.Name())
+		return 0, fmt.Errorf("translating timeout: %w", errPollTimeout)
+	})
+	assert.ErrorIs(err, errPollTimeout)
+	assert.Equal([]string{"a"}, called)
+}
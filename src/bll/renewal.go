@@ -0,0 +1,444 @@
+package bll
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/logging"
+	"github.com/yiwen-ai/yiwen-api/src/service"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+const (
+	SubscriptionPeriodMonth = "month"
+	SubscriptionPeriodYear  = "year"
+
+	// one-time purchase when no period is given
+	SubscriptionDefaultDuration = 3600 * 24 * 365 * 3 // seconds
+
+	ReminderExpiring = "expiring"
+	ReminderRenewed  = "renewed"
+	ReminderFailed   = "failed"
+
+	renewalInterval     = time.Minute
+	renewalRemindBefore = 3600 * 24 * 3 // seconds
+	renewalChargeBefore = 3600 * 24     // seconds
+	renewalRetryAfter   = 3600 * 6      // seconds
+	renewalMaxFailures  = 3
+	renewalBatchSize    = 100
+	renewalMaxReminders = 20
+)
+
+var subscriptionPeriods = map[string]int64{
+	SubscriptionPeriodMonth: 3600 * 24 * 30,
+	SubscriptionPeriodYear:  3600 * 24 * 365,
+}
+
+// SubscriptionPeriod returns the duration and amount of the period, where
+// price is the amount for base duration. The amount is rounded up.
+func SubscriptionPeriod(period string, price, base int64) (int64, int64, error) {
+	if period == "" {
+		return base, price, nil
+	}
+
+	duration, ok := subscriptionPeriods[period]
+	if !ok || base <= 0 {
+		return 0, 0, gear.ErrBadRequest.WithMsgf("invalid period %q", period)
+	}
+	amount := (price*duration + base - 1) / base
+	if amount < 1 {
+		amount = 1
+	}
+	return duration, amount, nil
+}
+
+// Renewal charges the wallet for auto-renewal subscriptions before they expire,
+// and leaves reminder messages to the subscribers. The plans are stored in the
+// writing service, which also serves the due queue.
+type Renewal struct {
+	redis      *service.Redis
+	locker     *service.Locker
+	membership *Membership
+	walletbase *Walletbase
+	writing    *Writing
+}
+
+// RenewalPlan is the opt-in auto-renewal of a subscription.
+type RenewalPlan struct {
+	UID        util.ID  `json:"uid" cbor:"uid"`
+	Kind       int8     `json:"kind" cbor:"kind"` // same as payment code kind
+	GID        util.ID  `json:"gid" cbor:"gid"`
+	CID        util.ID  `json:"cid" cbor:"cid"`
+	Payee      util.ID  `json:"-" cbor:"payee"`
+	SubPayee   *util.ID `json:"-" cbor:"sub_payee,omitempty"`
+	Amount     int64    `json:"amount" cbor:"amount"`
+	Duration   int64    `json:"duration" cbor:"duration"`
+	Period     string   `json:"period" cbor:"period"`
+	ExpireAt   int64    `json:"expire_at" cbor:"expire_at"`
+	RemindedAt int64    `json:"reminded_at" cbor:"reminded_at"`
+	Failures   int8     `json:"failures" cbor:"failures"`
+	DueAt      int64    `json:"due_at" cbor:"due_at"` // next processing time
+}
+
+type QueryRenewal struct {
+	Kind int8    `json:"kind" cbor:"kind" query:"kind" validate:"gte=0,lte=3"`
+	CID  util.ID `json:"cid" cbor:"cid" query:"cid" validate:"required"`
+}
+
+func (i *QueryRenewal) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	return nil
+}
+
+type Reminder struct {
+	Kind      string  `json:"kind" cbor:"kind"`
+	SubKind   int8    `json:"sub_kind" cbor:"sub_kind"`
+	GID       util.ID `json:"gid" cbor:"gid"`
+	CID       util.ID `json:"cid" cbor:"cid"`
+	Amount    int64   `json:"amount" cbor:"amount"`
+	ExpireAt  int64   `json:"expire_at" cbor:"expire_at"`
+	Message   string  `json:"message" cbor:"message"`
+	CreatedAt int64   `json:"created_at" cbor:"created_at"`
+}
+
+func (b *Renewal) InitApp(ctx context.Context, _ *gear.App) error {
+	go func() {
+		ticker := time.NewTicker(renewalInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			logging.CtxRun(context.Background(), "Renewal.Run", b.Run)
+		}
+	}()
+	return nil
+}
+
+// GetSubscription returns the user's subscription of the payment code kind,
+// or nil if not subscribed.
+func (b *Renewal) GetSubscription(ctx context.Context, kind int8, uid, cid util.ID) (*SubscriptionOutput, error) {
+	var output *SubscriptionOutput
+	var err error
+	switch kind {
+	case 0, 1:
+		output, err = b.writing.InternalGetCreationSubscription(ctx, cid)
+	case 2:
+		output, err = b.writing.InternalGetCollectionSubscription(ctx, cid)
+	case 3:
+		return b.membership.Get(ctx, uid, cid)
+	default:
+		return nil, gear.ErrBadRequest.WithMsg("invalid kind")
+	}
+
+	if err != nil {
+		if er := gear.ErrInternalServerError.From(err); er.Code == 404 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+func (b *Renewal) UpdateSubscription(ctx context.Context, kind int8, input *SubscriptionInput) (*SubscriptionOutput, error) {
+	switch kind {
+	case 0, 1:
+		return b.writing.InternalUpdateCreationSubscription(ctx, input)
+	case 2:
+		return b.writing.InternalUpdateCollectionSubscription(ctx, input)
+	case 3:
+		return b.membership.Subscribe(ctx, input)
+	}
+	return nil, gear.ErrBadRequest.WithMsg("invalid kind")
+}
+
+func (b *Renewal) Get(ctx context.Context, uid util.ID, kind int8, cid util.ID) (*RenewalPlan, error) {
+	return b.writing.InternalGetRenewal(ctx, uid, kind, cid)
+}
+
+// Enable opts in the auto-renewal, plan.ExpireAt is the expiration of the subscription.
+func (b *Renewal) Enable(ctx context.Context, plan *RenewalPlan) error {
+	if _, ok := subscriptionPeriods[plan.Period]; !ok {
+		return gear.ErrBadRequest.WithMsg("auto-renewal requires a period")
+	}
+	plan.RemindedAt = 0
+	plan.Failures = 0
+	return b.save(ctx, plan, plan.ExpireAt-renewalRemindBefore)
+}
+
+func (b *Renewal) Disable(ctx context.Context, uid util.ID, kind int8, cid util.ID) error {
+	if _, err := b.writing.InternalDeleteRenewal(ctx, uid, kind, cid); err != nil {
+		if er := gear.ErrInternalServerError.From(err); er.Code != 404 {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cancel opts out the auto-renewal, it waits for the renewal in processing.
+func (b *Renewal) Cancel(ctx context.Context, uid util.ID, kind int8, cid util.ID) error {
+	member := renewalMember(uid, kind, cid)
+	lock, err := b.locker.Lock(ctx, "RN:"+member, 30*time.Second)
+	if err != nil {
+		return gear.ErrLocked.From(err)
+	}
+	defer lock.Release(context.Background())
+
+	if err = b.Disable(ctx, uid, kind, cid); err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return nil
+}
+
+// Reminders returns the latest reminder messages of the user, the newest first.
+func (b *Renewal) Reminders(ctx context.Context, uid util.ID) ([]Reminder, error) {
+	output := []Reminder{}
+	if err := b.redis.GetCBOR(ctx, renewalRemindersKey(uid), &output); err != nil {
+		if er := gear.ErrInternalServerError.From(err); er.Code == 404 {
+			return output, nil
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+// Run processes the due renewal plans. Only one replica runs at a time, and
+// every plan is locked while processing, so a plan is never charged twice.
+func (b *Renewal) Run(ctx context.Context) error {
+	lock, err := b.locker.Lock(ctx, "RN:worker", renewalInterval)
+	if err != nil {
+		return nil // running on other replica
+	}
+	defer lock.Release(context.Background())
+
+	plans, err := b.writing.InternalListDueRenewals(ctx, time.Now().Unix(), renewalBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for i := range plans {
+		p := &plans[i]
+		if err := b.process(ctx, p.UID, p.Kind, p.CID); err != nil {
+			logging.Warningf("Renewal.process %s error: %v", renewalMember(p.UID, p.Kind, p.CID), err)
+		}
+	}
+	return nil
+}
+
+func (b *Renewal) process(ctx context.Context, uid util.ID, kind int8, cid util.ID) error {
+	lock, err := b.locker.Lock(ctx, "RN:"+renewalMember(uid, kind, cid), 30*time.Second)
+	if err != nil {
+		return nil
+	}
+	defer lock.Release(context.Background())
+
+	// the plan may be changed or cancelled after listed
+	plan, err := b.Get(ctx, uid, kind, cid)
+	if err != nil {
+		if er := gear.ErrInternalServerError.From(err); er.Code == 404 {
+			return nil
+		}
+		return err
+	}
+
+	// calls to the base services on behalf of the subscriber
+	h := http.Header{}
+	h.Set("x-auth-user", plan.UID.String())
+	ctx = gear.CtxWith[util.CtxHeader](ctx, util.Ptr(util.CtxHeader(h)))
+
+	subscription, err := b.GetSubscription(ctx, plan.Kind, plan.UID, plan.CID)
+	if err != nil {
+		// try again later, or the plan blocks the due queue
+		if er := b.save(ctx, plan, time.Now().Unix()+renewalRetryAfter); er != nil {
+			logging.Warningf("Renewal.process reschedule error: %v", er)
+		}
+		return err
+	}
+	if subscription == nil {
+		return b.Disable(ctx, plan.UID, plan.Kind, plan.CID)
+	}
+
+	now := time.Now().Unix()
+	if subscription.ExpireAt > plan.ExpireAt {
+		// renewed by the subscriber
+		plan.ExpireAt = subscription.ExpireAt
+		plan.RemindedAt = 0
+		return b.save(ctx, plan, plan.ExpireAt-renewalRemindBefore)
+	}
+
+	if plan.ExpireAt-now > renewalChargeBefore {
+		if plan.RemindedAt == 0 {
+			plan.RemindedAt = now
+			b.remind(ctx, plan, ReminderExpiring,
+				fmt.Sprintf("Your subscription will be renewed automatically for %d WEN before it expires.", plan.Amount))
+		}
+		return b.save(ctx, plan, plan.ExpireAt-renewalChargeBefore)
+	}
+
+	// the price may be changed after the plan enabled, the subscriber should
+	// confirm the new price by subscribing again
+	duration, amount, err := b.price(ctx, plan)
+	if err == nil && (amount != plan.Amount || duration != plan.Duration) {
+		b.remind(ctx, plan, ReminderFailed,
+			fmt.Sprintf("Auto-renewal has been cancelled: price changed from %d to %d WEN.", plan.Amount, amount))
+		return b.Disable(ctx, plan.UID, plan.Kind, plan.CID)
+	}
+
+	if err == nil {
+		err = b.charge(ctx, plan, subscription)
+	}
+	if err != nil {
+		plan.Failures++
+		if plan.Failures >= renewalMaxFailures || plan.ExpireAt <= now {
+			b.remind(ctx, plan, ReminderFailed,
+				fmt.Sprintf("Auto-renewal has been cancelled: %v", err))
+			return b.Disable(ctx, plan.UID, plan.Kind, plan.CID)
+		}
+		return b.save(ctx, plan, now+renewalRetryAfter)
+	}
+
+	b.remind(ctx, plan, ReminderRenewed,
+		fmt.Sprintf("Your subscription has been renewed for %d WEN.", plan.Amount))
+	plan.Failures = 0
+	plan.RemindedAt = 0
+	return b.save(ctx, plan, plan.ExpireAt-renewalRemindBefore)
+}
+
+func (b *Renewal) charge(ctx context.Context, plan *RenewalPlan, subscription *SubscriptionOutput) error {
+	wallet, err := b.walletbase.Get(ctx, plan.UID)
+	if err != nil {
+		return err
+	}
+	if wallet.Balance() < plan.Amount {
+		return gear.ErrPaymentRequired.WithMsg("insufficient balance")
+	}
+
+	payload, err := cbor.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	wallet, err = b.walletbase.Subscribe(ctx, &SpendInput{
+		UID:         plan.UID,
+		Amount:      plan.Amount,
+		Payee:       &plan.Payee,
+		SubPayee:    plan.SubPayee,
+		Description: LogActionSubscriptionRenew,
+		Payload:     payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	input := &SubscriptionInput{
+		UID:       plan.UID,
+		CID:       plan.CID,
+		Txn:       wallet.Txn,
+		ExpireAt:  max(subscription.ExpireAt, time.Now().Unix()) + plan.Duration,
+		UpdatedAt: subscription.UpdatedAt,
+	}
+	txn := &TransactionPK{UID: plan.UID, ID: wallet.Txn}
+	subscription, err = b.UpdateSubscription(ctx, plan.Kind, input)
+	if err == nil {
+		err = b.walletbase.CommitTxn(ctx, txn)
+	}
+	if err != nil {
+		_ = b.walletbase.CancelTxn(ctx, txn)
+		return err
+	}
+
+	plan.ExpireAt = subscription.ExpireAt
+	return nil
+}
+
+// price returns the current duration and amount of the plan's period, the same
+// as a new payment code without coupon.
+func (b *Renewal) price(ctx context.Context, plan *RenewalPlan) (int64, int64, error) {
+	price := int64(0)
+	base := int64(SubscriptionDefaultDuration)
+	switch plan.Kind {
+	case 0, 1:
+		doc, err := b.writing.ImplicitGetPublication(ctx, &ImplicitQueryPublication{
+			CID:    plan.CID,
+			GID:    &plan.GID,
+			Fields: "title",
+		}, nil)
+		if err != nil {
+			return 0, 0, err
+		}
+		if doc.Price != nil {
+			price = *doc.Price
+		}
+	case 2:
+		doc, err := b.writing.GetCollection(ctx, &QueryGidID{
+			GID:    util.ZeroID,
+			ID:     plan.CID,
+			Fields: "gid,info",
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		if doc.Price != nil {
+			price = *doc.Price
+		}
+	case 3:
+		gp, err := b.membership.GetPlan(ctx, plan.CID)
+		if err != nil {
+			return 0, 0, err
+		}
+		price = gp.Price
+		base = gp.Duration
+	default:
+		return 0, 0, gear.ErrBadRequest.WithMsg("invalid kind")
+	}
+
+	if price <= 0 || base <= 0 {
+		return 0, 0, gear.ErrBadRequest.WithMsg("subscription is no longer available")
+	}
+	return SubscriptionPeriod(plan.Period, price, base)
+}
+
+func (b *Renewal) save(ctx context.Context, plan *RenewalPlan, dueAt int64) error {
+	plan.DueAt = dueAt
+	_, err := b.writing.InternalUpdateRenewal(ctx, plan)
+	return err
+}
+
+func (b *Renewal) remind(ctx context.Context, plan *RenewalPlan, kind, message string) {
+	list, err := b.Reminders(ctx, plan.UID)
+	if err != nil {
+		logging.Warningf("Renewal.remind error: %v", err)
+		return
+	}
+
+	list = append([]Reminder{{
+		Kind:      kind,
+		SubKind:   plan.Kind,
+		GID:       plan.GID,
+		CID:       plan.CID,
+		Amount:    plan.Amount,
+		ExpireAt:  plan.ExpireAt,
+		Message:   message,
+		CreatedAt: time.Now().Unix(),
+	}}, list...)
+	if len(list) > renewalMaxReminders {
+		list = list[:renewalMaxReminders]
+	}
+	if err = b.redis.SetCBOR(ctx, renewalRemindersKey(plan.UID), list, 3600*24*90); err != nil {
+		logging.Warningf("Renewal.remind error: %v", err)
+	}
+}
+
+func renewalMember(uid util.ID, kind int8, cid util.ID) string {
+	return strings.Join([]string{uid.String(), fmt.Sprint(kind), cid.String()}, ":")
+}
+
+func renewalRemindersKey(uid util.ID) string {
+	return "rn:reminders:" + uid.String()
+}
@@ -0,0 +1,33 @@
+package bll
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionPeriod(t *testing.T) {
+	assert := assert.New(t)
+
+	duration, amount, err := SubscriptionPeriod("", 100, SubscriptionDefaultDuration)
+	assert.NoError(err)
+	assert.Equal(int64(SubscriptionDefaultDuration), duration)
+	assert.Equal(int64(100), amount)
+
+	duration, amount, err = SubscriptionPeriod(SubscriptionPeriodYear, 300, SubscriptionDefaultDuration)
+	assert.NoError(err)
+	assert.Equal(int64(3600*24*365), duration)
+	assert.Equal(int64(100), amount)
+
+	duration, amount, err = SubscriptionPeriod(SubscriptionPeriodMonth, 300, SubscriptionDefaultDuration)
+	assert.NoError(err)
+	assert.Equal(int64(3600*24*30), duration)
+	assert.Equal(int64(9), amount) // 8.2 rounded up
+
+	_, amount, err = SubscriptionPeriod(SubscriptionPeriodMonth, 1, 3600*24*365)
+	assert.NoError(err)
+	assert.Equal(int64(1), amount)
+
+	_, _, err = SubscriptionPeriod("week", 100, SubscriptionDefaultDuration)
+	assert.Error(err)
+}
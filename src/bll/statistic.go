@@ -0,0 +1,278 @@
+package bll
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/conf"
+	"github.com/yiwen-ai/yiwen-api/src/logging"
+	"github.com/yiwen-ai/yiwen-api/src/service"
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+const (
+	StatisticViews         = "views"
+	StatisticBookmarks     = "bookmarks"
+	StatisticSubscriptions = "subscriptions"
+	StatisticTranslations  = "translations"
+
+	statisticFlushInterval = 10 * time.Second
+	statisticDailyTTL      = 3600 * 24 * 100 // seconds
+	statisticMaxDays       = 90
+)
+
+// Statistic buffers engagement counters in memory and flushes them to redis
+// periodically. Unique readers are tracked with HyperLogLog.
+type Statistic struct {
+	redis    *service.Redis
+	mu       sync.Mutex
+	counters map[string]map[string]int64
+	daily    map[string]map[string]int64
+	readers  map[string][]string
+	dreaders map[string][]string
+}
+
+type StatisticCounters struct {
+	Views         uint `json:"views" cbor:"views"`
+	Readers       uint `json:"readers" cbor:"readers"`
+	Bookmarks     uint `json:"bookmarks" cbor:"bookmarks"`
+	Subscriptions uint `json:"subscriptions" cbor:"subscriptions"`
+	Translations  uint `json:"translations" cbor:"translations"`
+}
+
+type StatisticDaily struct {
+	Date string `json:"date" cbor:"date"` // YYYYMMDD, UTC
+	StatisticCounters
+}
+
+type QueryStatistic struct {
+	GID  util.ID `json:"gid" cbor:"gid" query:"gid" validate:"required"`
+	CID  util.ID `json:"cid" cbor:"cid" query:"cid" validate:"required"`
+	Days uint16  `json:"days,omitempty" cbor:"days,omitempty" query:"days" validate:"lte=90"`
+}
+
+func (i *QueryStatistic) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	return nil
+}
+
+func NewStatistic(redis *service.Redis) *Statistic {
+	s := &Statistic{redis: redis}
+	s.reset()
+	return s
+}
+
+func (b *Statistic) InitApp(ctx context.Context, _ *gear.App) error {
+	// hold a job so that the buffers are flushed before shutdown
+	conf.Config.ObtainJob()
+	go func() {
+		defer conf.Config.ReleaseJob()
+		ticker := time.NewTicker(statisticFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				logging.CtxRun(context.Background(), "Statistic.Flush", b.Flush)
+			case <-conf.Config.GlobalSignal.Done():
+				logging.CtxRun(context.Background(), "Statistic.Flush", b.Flush)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// View records a read of the publication by reader (user id or client ip).
+func (b *Statistic) View(gid, cid util.ID, reader string) {
+	date := time.Now().UTC().Format("20060102")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range statisticKeys(gid, cid) {
+		b.incr(b.counters, key, StatisticViews, 1)
+		b.incr(b.daily, key+":"+date, StatisticViews, 1)
+		if reader != "" {
+			b.readers[key+":r"] = append(b.readers[key+":r"], reader)
+			b.dreaders[key+":"+date+":r"] = append(b.dreaders[key+":"+date+":r"], reader)
+		}
+	}
+}
+
+// Incr increases a counter, such as StatisticBookmarks, of the publication
+// and its group.
+func (b *Statistic) Incr(gid, cid util.ID, field string, n int64) {
+	date := time.Now().UTC().Format("20060102")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range statisticKeys(gid, cid) {
+		b.incr(b.counters, key, field, n)
+		b.incr(b.daily, key+":"+date, field, n)
+	}
+}
+
+func (b *Statistic) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	counters, daily, readers, dreaders := b.counters, b.daily, b.readers, b.dreaders
+	b.reset()
+	b.mu.Unlock()
+
+	var err error
+	// merge the buffers back if failed, they will be flushed next time
+	if er := b.redis.HIncrMulti(ctx, counters, 0); er != nil {
+		err = er
+		b.merge(counters, nil, nil, nil)
+	}
+	if er := b.redis.HIncrMulti(ctx, daily, statisticDailyTTL); er != nil {
+		err = er
+		b.merge(nil, daily, nil, nil)
+	}
+	if er := b.redis.PFAddMulti(ctx, readers, 0); er != nil {
+		err = er
+		b.merge(nil, nil, readers, nil)
+	}
+	if er := b.redis.PFAddMulti(ctx, dreaders, statisticDailyTTL); er != nil {
+		err = er
+		b.merge(nil, nil, nil, dreaders)
+	}
+	return err
+}
+
+func (b *Statistic) merge(counters, daily map[string]map[string]int64, readers, dreaders map[string][]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, fields := range counters {
+		for field, n := range fields {
+			b.incr(b.counters, key, field, n)
+		}
+	}
+	for key, fields := range daily {
+		for field, n := range fields {
+			b.incr(b.daily, key, field, n)
+		}
+	}
+	for key, list := range readers {
+		b.readers[key] = append(b.readers[key], list...)
+	}
+	for key, list := range dreaders {
+		b.dreaders[key] = append(b.dreaders[key], list...)
+	}
+}
+
+func (b *Statistic) GroupCounters(ctx context.Context, gid util.ID) (*StatisticCounters, error) {
+	return b.get(ctx, statisticGroupKey(gid))
+}
+
+func (b *Statistic) PublicationCounters(ctx context.Context, cid util.ID) (*StatisticCounters, error) {
+	return b.get(ctx, statisticPublicationKey(cid))
+}
+
+func (b *Statistic) GroupDaily(ctx context.Context, gid util.ID, days uint16) ([]StatisticDaily, error) {
+	return b.getDaily(ctx, statisticGroupKey(gid), days)
+}
+
+func (b *Statistic) PublicationDaily(ctx context.Context, cid util.ID, days uint16) ([]StatisticDaily, error) {
+	return b.getDaily(ctx, statisticPublicationKey(cid), days)
+}
+
+func (b *Statistic) get(ctx context.Context, key string) (*StatisticCounters, error) {
+	hashes, err := b.redis.HGetAllMulti(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	readers, err := b.redis.PFCountMulti(ctx, key+":r")
+	if err != nil {
+		return nil, err
+	}
+
+	output := &StatisticCounters{}
+	output.fill(hashes[0], readers[0])
+	return output, nil
+}
+
+func (b *Statistic) getDaily(ctx context.Context, key string, days uint16) ([]StatisticDaily, error) {
+	if days == 0 {
+		return []StatisticDaily{}, nil
+	}
+	if days > statisticMaxDays {
+		days = statisticMaxDays
+	}
+
+	now := time.Now().UTC()
+	dates := make([]string, days)
+	hkeys := make([]string, days)
+	rkeys := make([]string, days)
+	for i := range dates {
+		dates[i] = now.AddDate(0, 0, i-int(days)+1).Format("20060102")
+		hkeys[i] = key + ":" + dates[i]
+		rkeys[i] = key + ":" + dates[i] + ":r"
+	}
+
+	hashes, err := b.redis.HGetAllMulti(ctx, hkeys...)
+	if err != nil {
+		return nil, err
+	}
+	readers, err := b.redis.PFCountMulti(ctx, rkeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]StatisticDaily, days)
+	for i := range output {
+		output[i].Date = dates[i]
+		output[i].fill(hashes[i], readers[i])
+	}
+	return output, nil
+}
+
+func (b *Statistic) reset() {
+	b.counters = make(map[string]map[string]int64)
+	b.daily = make(map[string]map[string]int64)
+	b.readers = make(map[string][]string)
+	b.dreaders = make(map[string][]string)
+}
+
+func (b *Statistic) incr(m map[string]map[string]int64, key, field string, n int64) {
+	fields, ok := m[key]
+	if !ok {
+		fields = make(map[string]int64)
+		m[key] = fields
+	}
+	fields[field] += n
+}
+
+func (o *StatisticCounters) fill(hash map[string]string, readers int64) {
+	get := func(field string) uint {
+		n, _ := strconv.ParseInt(hash[field], 10, 64)
+		if n < 0 {
+			return 0
+		}
+		return uint(n)
+	}
+
+	o.Views = get(StatisticViews)
+	o.Bookmarks = get(StatisticBookmarks)
+	o.Subscriptions = get(StatisticSubscriptions)
+	o.Translations = get(StatisticTranslations)
+	o.Readers = uint(readers)
+}
+
+func statisticKeys(gid, cid util.ID) []string {
+	return []string{statisticGroupKey(gid), statisticPublicationKey(cid)}
+}
+
+func statisticGroupKey(gid util.ID) string {
+	return "stat:g:" + gid.String()
+}
+
+func statisticPublicationKey(cid util.ID) string {
+	return "stat:p:" + cid.String()
+}
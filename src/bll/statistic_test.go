@@ -0,0 +1,44 @@
+package bll
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+func TestStatistic(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewStatistic(nil)
+	gid := util.NewID()
+	cid := util.NewID()
+
+	s.View(gid, cid, "u1")
+	s.View(gid, cid, "u2")
+	s.View(gid, cid, "")
+	s.Incr(gid, cid, StatisticBookmarks, 1)
+
+	gk := statisticGroupKey(gid)
+	pk := statisticPublicationKey(cid)
+	assert.Equal(int64(3), s.counters[gk][StatisticViews])
+	assert.Equal(int64(3), s.counters[pk][StatisticViews])
+	assert.Equal(int64(1), s.counters[pk][StatisticBookmarks])
+	assert.Equal([]string{"u1", "u2"}, s.readers[pk+":r"])
+	assert.Equal(2, len(s.daily))
+	assert.Equal(2, len(s.dreaders))
+
+	counters, readers := s.counters, s.readers
+	s.reset()
+	s.View(gid, cid, "u3")
+	s.merge(counters, nil, readers, nil)
+	assert.Equal(int64(4), s.counters[pk][StatisticViews])
+	assert.Equal([]string{"u3", "u1", "u2"}, s.readers[pk+":r"])
+
+	o := &StatisticCounters{}
+	o.fill(map[string]string{StatisticViews: "10", StatisticTranslations: "-1"}, 5)
+	assert.Equal(uint(10), o.Views)
+	assert.Equal(uint(0), o.Translations)
+	assert.Equal(uint(5), o.Readers)
+}
@@ -2,8 +2,10 @@ package bll
 
 import (
 	"context"
+	"net/url"
 
 	"github.com/fxamacker/cbor/v2"
+	"github.com/yiwen-ai/yiwen-api/src/content"
 	"github.com/yiwen-ai/yiwen-api/src/logging"
 	"github.com/yiwen-ai/yiwen-api/src/service"
 	"github.com/yiwen-ai/yiwen-api/src/util"
@@ -25,17 +27,113 @@ type CreateTaskInput struct {
 	GroupRole *int8      `json:"group_role,omitempty" cbor:"group_role,omitempty"`
 }
 
+// ReviewTaskPayload is the payload of a "publication.review" task, with the
+// quality report of the translation if any.
+type ReviewTaskPayload struct {
+	LogPayload
+	Quality *content.QualityReport `json:"quality,omitempty" cbor:"quality,omitempty"`
+}
+
+// PublicationHold returns the review task that holds the publication at
+// status 0, or nil if not held or the task approved.
+func (b *Taskbase) PublicationHold(ctx context.Context, p *PublicationOutput) (*TaskOutput, error) {
+	if p.Review == nil {
+		return nil, nil
+	}
+
+	task, err := b.Get(ctx, p.GID, *p.Review)
+	if err != nil {
+		return nil, err
+	}
+	if task.Status == 1 {
+		return nil, nil
+	}
+	return task, nil
+}
+
 func (b *Taskbase) Create(ctx context.Context, input *CreateTaskInput, payload any) {
-	var err error
+	if _, err := b.CreateTask(ctx, input, payload); err != nil {
+		logging.Errf("failed to create task: %v", err)
+	}
+}
+
+type TaskOutput struct {
+	UID       util.ID    `json:"uid" cbor:"uid"` // the creator
+	ID        util.ID    `json:"id" cbor:"id"`
+	GID       util.ID    `json:"gid" cbor:"gid"`
+	Status    int8       `json:"status" cbor:"status"`
+	Kind      string     `json:"kind" cbor:"kind"`
+	CreatedAt int64      `json:"created_at" cbor:"created_at"`
+	UpdatedAt int64      `json:"updated_at" cbor:"updated_at"`
+	Threshold int8       `json:"threshold" cbor:"threshold"`
+	Approvers []util.ID  `json:"approvers" cbor:"approvers"`
+	Assignees []util.ID  `json:"assignees" cbor:"assignees"`
+	Message   string     `json:"message" cbor:"message"`
+	Payload   util.Bytes `json:"payload" cbor:"payload"`
+}
+
+func (b *Taskbase) CreateTask(ctx context.Context, input *CreateTaskInput, payload any) (*TaskOutput, error) {
 	if payload != nil {
-		input.Payload, err = cbor.Marshal(payload)
+		data, err := cbor.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		input.Payload = data
 	}
-	if err == nil {
-		output := SuccessResponse[any]{}
-		err = b.svc.Post(ctx, "/v1/task", input, &output)
+
+	output := SuccessResponse[TaskOutput]{}
+	if err := b.svc.Post(ctx, "/v1/task", input, &output); err != nil {
+		return nil, err
 	}
 
-	if err != nil {
-		logging.Errf("failed to create task: %v", err)
+	return &output.Result, nil
+}
+
+func (b *Taskbase) Get(ctx context.Context, gid, id util.ID) (*TaskOutput, error) {
+	output := SuccessResponse[TaskOutput]{}
+
+	query := url.Values{}
+	query.Add("gid", gid.String())
+	query.Add("id", id.String())
+	if err := b.svc.Get(ctx, "/v1/task?"+query.Encode(), &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+type UpdateTaskInput struct {
+	GID       util.ID     `json:"gid" cbor:"gid"`
+	ID        util.ID     `json:"id" cbor:"id"`
+	UpdatedAt int64       `json:"updated_at" cbor:"updated_at"`
+	Status    *int8       `json:"status,omitempty" cbor:"status,omitempty"`
+	Assignees *[]util.ID  `json:"assignees,omitempty" cbor:"assignees,omitempty"`
+	Message   *string     `json:"message,omitempty" cbor:"message,omitempty"`
+	Payload   *util.Bytes `json:"payload,omitempty" cbor:"payload,omitempty"`
+}
+
+func (b *Taskbase) Update(ctx context.Context, input *UpdateTaskInput) (*TaskOutput, error) {
+	output := SuccessResponse[TaskOutput]{}
+	if err := b.svc.Patch(ctx, "/v1/task", input, &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+type ListTasksInput struct {
+	GID       util.ID     `json:"gid" cbor:"gid"`
+	Kind      string      `json:"kind" cbor:"kind"`
+	PageToken *util.Bytes `json:"page_token,omitempty" cbor:"page_token,omitempty"`
+	PageSize  *uint16     `json:"page_size,omitempty" cbor:"page_size,omitempty"`
+	Status    *int8       `json:"status,omitempty" cbor:"status,omitempty"`
+}
+
+func (b *Taskbase) ListByGID(ctx context.Context, input *ListTasksInput) (*SuccessResponse[[]TaskOutput], error) {
+	output := SuccessResponse[[]TaskOutput]{}
+	if err := b.svc.Post(ctx, "/v1/task/list_by_gid", input, &output); err != nil {
+		return nil, err
 	}
+
+	return &output, nil
 }
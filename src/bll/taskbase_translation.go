@@ -0,0 +1,83 @@
+package bll
+
+import (
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+// TaskKindTranslationRequest is the task of a translation request that group
+// members can claim and translate by hand for a bounty.
+const TaskKindTranslationRequest = "translation.request"
+
+const (
+	TranslationRequestCancelled int8 = -1
+	TranslationRequestOpen      int8 = 0
+	TranslationRequestClaimed   int8 = 1
+	TranslationRequestSubmitted int8 = 2
+	TranslationRequestAccepted  int8 = 3
+)
+
+// translation request state transitions, from state -> allowed next states
+var translationRequestTransitions = map[int8][]int8{
+	TranslationRequestOpen:      {TranslationRequestClaimed, TranslationRequestCancelled},
+	TranslationRequestClaimed:   {TranslationRequestSubmitted, TranslationRequestCancelled},
+	TranslationRequestSubmitted: {TranslationRequestAccepted, TranslationRequestClaimed},
+}
+
+type TranslationRequestPayload struct {
+	GID         util.ID  `json:"gid" cbor:"gid"`
+	CID         util.ID  `json:"cid" cbor:"cid"`
+	Language    string   `json:"language" cbor:"language"`
+	Version     uint16   `json:"version" cbor:"version"`
+	ToLanguage  string   `json:"to_language" cbor:"to_language"`
+	Bounty      int64    `json:"bounty" cbor:"bounty"`
+	State       int8     `json:"state" cbor:"state"`
+	Claimer     *util.ID `json:"claimer,omitempty" cbor:"claimer,omitempty"`
+	ClaimedAt   int64    `json:"claimed_at,omitempty" cbor:"claimed_at,omitempty"`
+	SubmittedAt int64    `json:"submitted_at,omitempty" cbor:"submitted_at,omitempty"`
+	Hold        *util.ID `json:"hold,omitempty" cbor:"hold,omitempty"` // the pending transaction that holds the bounty
+	Txn         *util.ID `json:"txn,omitempty" cbor:"txn,omitempty"`   // the bounty transaction
+}
+
+// Transit changes the state of the request if it is allowed.
+func (p *TranslationRequestPayload) Transit(to int8) error {
+	if !util.SliceHas(translationRequestTransitions[p.State], to) {
+		return gear.ErrConflict.WithMsgf("invalid translation request state transition: %d -> %d", p.State, to)
+	}
+	p.State = to
+	return nil
+}
+
+type TranslationRequestOutput struct {
+	ID        util.ID                    `json:"id" cbor:"id"`
+	GID       util.ID                    `json:"gid" cbor:"gid"`
+	UID       util.ID                    `json:"uid" cbor:"uid"` // the poster
+	Status    int8                       `json:"status" cbor:"status"`
+	CreatedAt int64                      `json:"created_at" cbor:"created_at"`
+	UpdatedAt int64                      `json:"updated_at" cbor:"updated_at"`
+	Message   string                     `json:"message" cbor:"message"`
+	Request   *TranslationRequestPayload `json:"request" cbor:"request"`
+}
+
+func TranslationRequestFrom(task *TaskOutput) (*TranslationRequestOutput, error) {
+	if task.Kind != TaskKindTranslationRequest {
+		return nil, gear.ErrNotFound.WithMsgf("invalid translation request task: %s", task.Kind)
+	}
+
+	p, err := util.Unmarshal[TranslationRequestPayload](&task.Payload)
+	if err != nil {
+		return nil, gear.ErrInternalServerError.From(err)
+	}
+
+	return &TranslationRequestOutput{
+		ID:        task.ID,
+		GID:       task.GID,
+		UID:       task.UID,
+		Status:    task.Status,
+		CreatedAt: task.CreatedAt,
+		UpdatedAt: task.UpdatedAt,
+		Message:   task.Message,
+		Request:   p,
+	}, nil
+}
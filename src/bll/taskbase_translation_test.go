@@ -0,0 +1,48 @@
+package bll
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+func TestTranslationRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	p := &TranslationRequestPayload{State: TranslationRequestOpen}
+	assert.Error(p.Transit(TranslationRequestSubmitted))
+	assert.Error(p.Transit(TranslationRequestAccepted))
+	require.NoError(t, p.Transit(TranslationRequestClaimed))
+	require.NoError(t, p.Transit(TranslationRequestSubmitted))
+	// rejected
+	require.NoError(t, p.Transit(TranslationRequestClaimed))
+	require.NoError(t, p.Transit(TranslationRequestSubmitted))
+	assert.Error(p.Transit(TranslationRequestCancelled))
+	require.NoError(t, p.Transit(TranslationRequestAccepted))
+	assert.Error(p.Transit(TranslationRequestClaimed))
+	assert.Error(p.Transit(TranslationRequestCancelled))
+
+	p = &TranslationRequestPayload{
+		GID:        util.NewID(),
+		CID:        util.NewID(),
+		Language:   "eng",
+		Version:    1,
+		ToLanguage: "zho",
+		Bounty:     100,
+	}
+	data, err := util.Marshal(p)
+	require.NoError(t, err)
+
+	task := &TaskOutput{ID: util.NewID(), GID: p.GID, Kind: "publication.review", Payload: data}
+	_, err = TranslationRequestFrom(task)
+	assert.Error(err)
+
+	task.Kind = TaskKindTranslationRequest
+	req, err := TranslationRequestFrom(task)
+	require.NoError(t, err)
+	assert.Equal(task.ID, req.ID)
+	assert.Equal(p, req.Request)
+}
@@ -215,6 +215,15 @@ func (b *Userbase) GetGroup(ctx context.Context, id util.ID, fields string) (*Gr
 	return &output.Result, nil
 }
 
+func (b *Userbase) CountGroupMembers(ctx context.Context, gid util.ID) (uint, error) {
+	output := SuccessResponse[uint]{}
+	if err := b.svc.Get(ctx, "/v1/group/count_members?id="+gid.String(), &output); err != nil {
+		return 0, err
+	}
+
+	return output.Result, nil
+}
+
 type UpdateGroupInfoInput struct {
 	ID      util.ID `json:"id" cbor:"id" validate:"required"`
 	Name    *string `json:"name,omitempty" cbor:"name,omitempty" validate:"omitempty,gte=2,lte=16"`
@@ -2,37 +2,103 @@ package bll
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"strings"
 
 	"github.com/fxamacker/cbor/v2"
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/conf"
+	"github.com/yiwen-ai/yiwen-api/src/logging"
 	"github.com/yiwen-ai/yiwen-api/src/service"
 	"github.com/yiwen-ai/yiwen-api/src/util"
 )
 
-var AIModels = []AIModel{
-	// 4K context,	i: $0.0015/1K tokens; o: $0.002/1K tokens; 0.157 WEN/1K tokens
-	{ID: "gpt-3.5", Name: "GPT-3.5", Price: 1}, // 0.6 wen/1K tokens
-	// 8K context,	i: $0.03/1K tokens; o: $0.06/1K tokens; 4.7 WEN/1K tokens
-	{ID: "gpt-4", Name: "GPT-4", Price: 10}, // 10 wen/1K tokens
-}
+var AIModels []AIModel
 
-var DefaultModel = AIModels[0]
+var DefaultModel AIModel
+
+func init() {
+	SetAIModels(conf.Config.Models)
+}
 
 type AIModel struct {
-	ID    string  `json:"id" cbor:"id"`
-	Name  string  `json:"name" cbor:"name"`
-	Price float64 `json:"price" cbor:"price"`
+	ID            string   `json:"id" cbor:"id"`
+	Name          string   `json:"name" cbor:"name"`
+	Price         float64  `json:"price" cbor:"price"`
+	Tokenizer     string   `json:"tokenizer" cbor:"tokenizer"`
+	ContextWindow uint32   `json:"context_window" cbor:"context_window"`
+	MaxOutput     uint32   `json:"max_output" cbor:"max_output"`
+	MinLevel      uint8    `json:"min_level" cbor:"min_level"`
+	Languages     []string `json:"languages" cbor:"languages"`
+	Deprecated    bool     `json:"deprecated" cbor:"deprecated"`
 }
 
-func GetAIModel(name string) AIModel {
+// SetAIModels replaces the model registry, the first model that not deprecated
+// will be the default model.
+func SetAIModels(models []conf.Model) {
+	list := make([]AIModel, 0, len(models))
+	for _, m := range models {
+		tokenizer := m.Tokenizer
+		if tokenizer == "" {
+			tokenizer = util.DefaultTokenizer
+		}
+		languages := m.Languages
+		if languages == nil {
+			languages = []string{}
+		}
+		list = append(list, AIModel{
+			ID:            strings.ToLower(m.ID),
+			Name:          m.Name,
+			Price:         m.Price,
+			Tokenizer:     tokenizer,
+			ContextWindow: m.ContextWindow,
+			MaxOutput:     m.MaxOutput,
+			MinLevel:      m.MinLevel,
+			Languages:     languages,
+			Deprecated:    m.Deprecated,
+		})
+	}
+
+	AIModels = list
+	for _, m := range list {
+		if !m.Deprecated {
+			DefaultModel = m
+			break
+		}
+	}
+}
+
+// GetAIModel returns the model by name, or the default model if name is empty.
+func GetAIModel(name string) (AIModel, error) {
+	if name == "" {
+		return DefaultModel, nil
+	}
+
+	name = strings.ToLower(name)
 	for i := range AIModels {
-		if AIModels[i].ID == strings.ToLower(name) {
-			return AIModels[i]
+		if AIModels[i].ID == name {
+			return AIModels[i], nil
 		}
 	}
 
-	return AIModels[0]
+	return AIModel{}, gear.ErrBadRequest.WithMsgf("unknown model %q", name)
+}
+
+// Allow checks whether the model can be used for a new job by the wallet level
+// and the target language.
+func (m *AIModel) Allow(level uint8, language string) error {
+	if m.Deprecated {
+		return gear.ErrBadRequest.WithMsgf("model %q is deprecated", m.ID)
+	}
+	if level < m.MinLevel {
+		return gear.ErrBadRequest.WithMsgf("model %q is not allowed for user level < %d", m.ID, m.MinLevel)
+	}
+	if len(m.Languages) > 0 && !util.SliceHas(m.Languages, language) {
+		return gear.ErrBadRequest.WithMsgf("model %q does not support language %q", m.ID, language)
+	}
+	return nil
 }
 
 func (m *AIModel) CostWEN(tokens uint32) int64 {
@@ -49,7 +115,8 @@ func (m *AIModel) CostWEN(tokens uint32) int64 {
 }
 
 type Walletbase struct {
-	svc service.APIHost
+	svc    service.APIHost
+	budget *Budget
 }
 
 type SpendInput struct {
@@ -71,6 +138,14 @@ type SpendPayload struct {
 	Model    string   `json:"model" cbor:"model"`
 	Price    float64  `json:"price" cbor:"price"`
 	Tokens   uint32   `json:"tokens" cbor:"tokens"`
+	Hold     bool     `json:"hold,omitempty" cbor:"hold,omitempty"` // reservation for a running job
+}
+
+// WalletHold is the funds reserved for a running job.
+type WalletHold struct {
+	TransactionPK
+	Amount  int64 `json:"amount" cbor:"amount"`
+	payload SpendPayload
 }
 
 type WalletOutput struct {
@@ -104,14 +179,139 @@ func (b *Walletbase) Get(ctx context.Context, uid util.ID) (*WalletOutput, error
 
 // should call CommitTxn or CancelTxn to confirm the transaction
 func (b *Walletbase) Spend(ctx context.Context, uid util.ID, input *SpendPayload) (*WalletOutput, error) {
-	data, err := cbor.Marshal(input)
+	amount, err := spendCost(input)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.reserve(ctx, input.GID, uid, amount); err != nil {
+		return nil, err
+	}
+
+	output, _, err := b.spend(ctx, uid, input)
+	if err != nil {
+		b.adjust(ctx, input.GID, uid, -amount)
+		return nil, err
+	}
+
+	b.track(ctx, output.Txn, input.GID, uid, amount)
+	return output, nil
+}
+
+// Hold reserves the cost of input.Tokens as a pending transaction before a
+// long-running job starts, so that concurrent jobs can not overdraw the wallet.
+// The cost is reserved in the group budget too, it returns 402 error if any
+// spending limit exceeded. Should call Settle or Release when the job finished.
+func (b *Walletbase) Hold(ctx context.Context, uid util.ID, input *SpendPayload) (*WalletHold, error) {
+	amount, err := spendCost(input)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.reserve(ctx, input.GID, uid, amount); err != nil {
+		return nil, err
+	}
+
+	payload := *input
+	payload.Hold = true
+	output, _, err := b.spend(ctx, uid, &payload)
 	if err != nil {
+		b.adjust(ctx, input.GID, uid, -amount)
 		return nil, err
 	}
-	m := GetAIModel(input.Model)
+
+	b.track(ctx, output.Txn, input.GID, uid, amount)
+	payload.Hold = false
+	return &WalletHold{
+		TransactionPK: TransactionPK{UID: uid, ID: output.Txn},
+		Amount:        amount,
+		payload:       payload,
+	}, nil
+}
+
+// Settle charges the actual cost of the tokens used by the job with the
+// transaction primitives: the hold is cancelled, the actual cost is spent and
+// committed. hold.ID is the committed transaction after settled. The funds stay
+// reserved until settled, so that concurrent jobs can not spend them.
+// The hold is released if failed to settle.
+func (b *Walletbase) Settle(ctx context.Context, hold *WalletHold, tokens uint32) error {
+	if err := b.CancelTxn(ctx, &hold.TransactionPK); err != nil {
+		return err
+	}
+
+	payload := hold.payload
+	payload.Tokens = tokens
+	output, amount, err := b.spend(ctx, hold.UID, &payload)
+	if err != nil {
+		return err
+	}
+
+	txn := &TransactionPK{UID: hold.UID, ID: output.Txn}
+	if err = b.CommitTxn(ctx, txn); err != nil {
+		_ = b.CancelTxn(ctx, txn)
+		return err
+	}
+
+	// the job is done, the actual cost is counted even if it exceeds the
+	// spending limits.
+	b.adjust(ctx, payload.GID, hold.UID, amount)
+	hold.TransactionPK = *txn
+	hold.Amount = amount
+	return nil
+}
+
+// Release cancels the hold when the job failed, the budget reservation is
+// rolled back by CancelTxn.
+func (b *Walletbase) Release(ctx context.Context, hold *WalletHold) error {
+	return b.CancelTxn(ctx, &hold.TransactionPK)
+}
+
+func spendCost(input *SpendPayload) (int64, error) {
+	m, err := GetAIModel(input.Model)
+	if err != nil {
+		return 0, err
+	}
+	return m.CostWEN(input.Tokens), nil
+}
+
+// reserve reserves the amount in the group budget before spending, it returns
+// 402 error if any spending limit exceeded.
+func (b *Walletbase) reserve(ctx context.Context, gid, uid util.ID, amount int64) error {
+	if b.budget == nil {
+		return nil
+	}
+	return b.budget.Reserve(ctx, gid, uid, amount)
+}
+
+// track keeps the reservation with the pending transaction, it is rolled back
+// by CancelTxn and kept by CommitTxn.
+func (b *Walletbase) track(ctx context.Context, txn, gid, uid util.ID, amount int64) {
+	if b.budget != nil {
+		if err := b.budget.Track(ctx, txn, gid, uid, amount); err != nil {
+			logging.Warningf("Walletbase: failed to track budget of txn %s: %v", txn.String(), err)
+		}
+	}
+}
+
+func (b *Walletbase) adjust(ctx context.Context, gid, uid util.ID, delta int64) {
+	if b.budget != nil {
+		if err := b.budget.Adjust(ctx, gid, uid, delta); err != nil {
+			logging.Warningf("Walletbase: failed to adjust budget: %v", err)
+		}
+	}
+}
+
+func (b *Walletbase) spend(ctx context.Context, uid util.ID, input *SpendPayload) (*WalletOutput, int64, error) {
+	m, err := GetAIModel(input.Model)
+	if err != nil {
+		return nil, 0, err
+	}
 	input.Model = m.ID
 	input.Price = m.Price
 
+	data, err := cbor.Marshal(input)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	ex := SpendInput{
 		UID:         uid,
 		Amount:      m.CostWEN(input.Tokens),
@@ -120,14 +320,16 @@ func (b *Walletbase) Spend(ctx context.Context, uid util.ID, input *SpendPayload
 	}
 	output := SuccessResponse[WalletOutput]{}
 	if err := b.svc.Post(ctx, "/v1/wallet/spend", ex, &output); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	output.Result.SetLevel()
-	return &output.Result, nil
+	return &output.Result, ex.Amount, nil
 }
 
-// should call CommitTxn or CancelTxn to confirm the transaction
+// should call CommitTxn or CancelTxn to confirm the transaction.
+// Subscriptions are paid by the user self rather than a group, so they are not
+// limited by group budgets.
 func (b *Walletbase) Subscribe(ctx context.Context, input *SpendInput) (*WalletOutput, error) {
 	output := SuccessResponse[WalletOutput]{}
 	if err := b.svc.Post(ctx, "/v1/wallet/subscribe", input, &output); err != nil {
@@ -138,6 +340,36 @@ func (b *Walletbase) Subscribe(ctx context.Context, input *SpendInput) (*WalletO
 	return &output.Result, nil
 }
 
+// HoldFunds reserves the amount as a pending transaction, such as the bounty of
+// a translation request of the group. The amount is reserved in the group
+// budget. Should call CancelTxn to release it.
+func (b *Walletbase) HoldFunds(ctx context.Context, gid util.ID, input *SpendInput) (*WalletOutput, error) {
+	return b.spendFunds(ctx, gid, "/v1/wallet/spend", input)
+}
+
+// Pay transfers the amount from the user to the payee, such as a bounty of the
+// group. The amount is reserved in the group budget.
+// Should call CommitTxn or CancelTxn to confirm the transaction.
+func (b *Walletbase) Pay(ctx context.Context, gid util.ID, input *SpendInput) (*WalletOutput, error) {
+	return b.spendFunds(ctx, gid, "/v1/wallet/sponsor", input)
+}
+
+func (b *Walletbase) spendFunds(ctx context.Context, gid util.ID, api string, input *SpendInput) (*WalletOutput, error) {
+	if err := b.reserve(ctx, gid, input.UID, input.Amount); err != nil {
+		return nil, err
+	}
+
+	output := SuccessResponse[WalletOutput]{}
+	if err := b.svc.Post(ctx, api, input, &output); err != nil {
+		b.adjust(ctx, gid, input.UID, -input.Amount)
+		return nil, err
+	}
+
+	b.track(ctx, output.Result.Txn, gid, input.UID, input.Amount)
+	output.Result.SetLevel()
+	return &output.Result, nil
+}
+
 type TransactionPK struct {
 	UID util.ID `json:"uid" cbor:"uid" query:"uid" validate:"required"`
 	ID  util.ID `json:"id" cbor:"id" query:"id" validate:"required"`
@@ -163,6 +395,12 @@ func (b *Walletbase) CommitTxn(ctx context.Context, input *TransactionPK) error
 		return err
 	}
 
+	// the budget was reserved when spending
+	if b.budget != nil {
+		if err := b.budget.Confirm(ctx, input.ID); err != nil {
+			logging.Warningf("Walletbase: failed to confirm budget of txn %s: %v", input.ID.String(), err)
+		}
+	}
 	return nil
 }
 
@@ -172,5 +410,135 @@ func (b *Walletbase) CancelTxn(ctx context.Context, input *TransactionPK) error
 		return err
 	}
 
+	if b.budget != nil {
+		if err := b.budget.Rollback(ctx, input.ID); err != nil {
+			logging.Warningf("Walletbase: failed to roll back budget of txn %s: %v", input.ID.String(), err)
+		}
+	}
 	return nil
 }
+
+type RefundInput struct {
+	UID         util.ID    `json:"uid" cbor:"uid"`
+	Txn         util.ID    `json:"txn" cbor:"txn"`
+	Amount      int64      `json:"amount" cbor:"amount"`
+	Description string     `json:"description,omitempty" cbor:"description,omitempty"`
+	Payload     util.Bytes `json:"payload,omitempty" cbor:"payload,omitempty"`
+}
+
+// Refund returns the amount of a committed transaction to the payer.
+func (b *Walletbase) Refund(ctx context.Context, input *RefundInput) (*WalletOutput, error) {
+	output := SuccessResponse[WalletOutput]{}
+	if err := b.svc.Post(ctx, "/v1/transaction/refund", input, &output); err != nil {
+		return nil, err
+	}
+
+	output.Result.SetLevel()
+	return &output.Result, nil
+}
+
+const maxRefundPages = 10
+
+// FindRefund returns the refund transaction of the refund request, or nil if
+// not refunded. Refunds are listed as the user's incoming transactions.
+func (b *Walletbase) FindRefund(ctx context.Context, uid, refund util.ID) (*TransactionOutput, error) {
+	page := &TransactionPagination{
+		UID:      uid,
+		PageSize: util.Ptr(uint16(100)),
+	}
+	since := refund.Time().Unix()
+	for i := 0; i < maxRefundPages; i++ {
+		output, err := b.ListIncoming(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := range output.Result {
+			txn := &output.Result[j]
+			if txn.ID.Time().Unix() < since {
+				return nil, nil
+			}
+			if txn.Description != LogActionUserRefund || txn.Payload == nil {
+				continue
+			}
+			if p, err := util.Unmarshal[RefundPayload](txn.Payload); err == nil && p.Refund != nil && *p.Refund == refund {
+				return txn, nil
+			}
+		}
+
+		if len(output.NextPageToken) == 0 {
+			return nil, nil
+		}
+		page.PageToken = util.Ptr(output.NextPageToken)
+	}
+	return nil, gear.ErrInternalServerError.WithMsg("too many transactions to find the refund")
+}
+
+func (b *Walletbase) GetTxn(ctx context.Context, input *TransactionPK) (*TransactionOutput, error) {
+	output := SuccessResponse[TransactionOutput]{}
+	api := fmt.Sprintf("/v1/transaction?uid=%s&id=%s", input.UID.String(), input.ID.String())
+	if err := b.svc.Get(ctx, api, &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+// ListOutgoing lists the transactions that the user paid.
+func (b *Walletbase) ListOutgoing(ctx context.Context, input *TransactionPagination) (*SuccessResponse[[]TransactionOutput], error) {
+	output := SuccessResponse[[]TransactionOutput]{}
+	if err := b.svc.Post(ctx, "/v1/transaction/list_outgoing", input, &output); err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+type TransactionInfo struct {
+	ID          util.ID           `json:"id" cbor:"id"`
+	Sequence    int64             `json:"sequence" cbor:"sequence"`
+	Payee       *util.ID          `json:"payee,omitempty" cbor:"payee,omitempty"`
+	SubPayee    *util.ID          `json:"sub_payee,omitempty" cbor:"sub_payee,omitempty"`
+	Status      int8              `json:"status" cbor:"status"`
+	Kind        string            `json:"kind" cbor:"kind"`
+	Amount      int64             `json:"amount" cbor:"amount"`
+	SysFee      int64             `json:"sys_fee" cbor:"sys_fee"`
+	SubShares   int64             `json:"sub_shares" cbor:"sub_shares"`
+	Description string            `json:"description,omitempty" cbor:"description,omitempty"`
+	CreatedAt   int64             `json:"created_at" cbor:"created_at"`
+	Spend       *SpendPayload     `json:"spend,omitempty" cbor:"spend,omitempty"`
+	Subscribe   *SubscribePayload `json:"subscribe,omitempty" cbor:"subscribe,omitempty"`
+}
+
+// Info decodes the payload of transaction so that it can be read by users.
+func (t *TransactionOutput) Info() TransactionInfo {
+	info := TransactionInfo{
+		ID:          t.ID,
+		Sequence:    t.Sequence,
+		Payee:       t.Payee,
+		SubPayee:    t.SubPayee,
+		Status:      t.Status,
+		Kind:        t.Kind,
+		Amount:      t.Amount,
+		SysFee:      t.SysFee,
+		SubShares:   t.SubShares,
+		Description: t.Description,
+		CreatedAt:   t.ID.Time().Unix(),
+	}
+
+	if t.Payload == nil || len(*t.Payload) == 0 {
+		return info
+	}
+
+	switch t.Description {
+	case LogActionCreationSubscribe, LogActionCollectionSubscribe, LogActionGroupSubscribe,
+		LogActionSubscriptionRenew, LogActionGiftPurchase:
+		info.Subscribe = t.subscribePayload()
+	default:
+		payload := &SpendPayload{}
+		if err := cbor.Unmarshal(*t.Payload, payload); err == nil {
+			info.Spend = payload
+		}
+	}
+	return info
+}
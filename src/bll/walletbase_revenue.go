@@ -0,0 +1,239 @@
+package bll
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/teambition/gear"
+
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+type TransactionPagination struct {
+	UID       util.ID     `json:"uid" cbor:"uid"`
+	PageToken *util.Bytes `json:"page_token,omitempty" cbor:"page_token,omitempty"`
+	PageSize  *uint16     `json:"page_size,omitempty" cbor:"page_size,omitempty"`
+	Kind      *string     `json:"kind,omitempty" cbor:"kind,omitempty"`
+}
+
+// ListIncoming lists the transactions that the user received as payee or sub payee.
+func (b *Walletbase) ListIncoming(ctx context.Context, input *TransactionPagination) (*SuccessResponse[[]TransactionOutput], error) {
+	output := SuccessResponse[[]TransactionOutput]{}
+	if err := b.svc.Post(ctx, "/v1/transaction/list_incoming", input, &output); err != nil {
+		return nil, err
+	}
+
+	return &output, nil
+}
+
+// SubscribePayload is the part of payment code that attached to subscription transactions.
+type SubscribePayload struct {
+	Kind int8    `json:"kind" cbor:"1,keyasint"`
+	GID  util.ID `json:"gid" cbor:"6,keyasint"`
+	CID  util.ID `json:"cid" cbor:"8,keyasint"`
+}
+
+type QueryRevenue struct {
+	Period string   `json:"period,omitempty" cbor:"period,omitempty" query:"period" validate:"omitempty,oneof=day month"`
+	From   int64    `json:"from,omitempty" cbor:"from,omitempty" query:"from" validate:"gte=0"` // unix seconds
+	To     int64    `json:"to,omitempty" cbor:"to,omitempty" query:"to" validate:"gte=0"`       // unix seconds
+	CID    *util.ID `json:"cid,omitempty" cbor:"cid,omitempty" query:"cid"`
+	Format string   `json:"format,omitempty" cbor:"format,omitempty" query:"format" validate:"omitempty,oneof=json csv"`
+	// the next_page_token of a truncated result, to aggregate the older transactions
+	PageToken *string `json:"page_token,omitempty" cbor:"page_token,omitempty" query:"page_token"`
+	pageToken *util.Bytes
+}
+
+func (i *QueryRevenue) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+	if i.Period == "" {
+		i.Period = "month"
+	}
+	if i.From == 0 {
+		i.From = time.Now().AddDate(-1, 0, 0).Unix()
+	}
+	if i.To > 0 && i.To < i.From {
+		return gear.ErrBadRequest.WithMsg("invalid time range")
+	}
+	if i.PageToken != nil {
+		i.pageToken = &util.Bytes{}
+		if err := i.pageToken.UnmarshalText([]byte(*i.PageToken)); err != nil {
+			return gear.ErrBadRequest.From(err)
+		}
+	}
+
+	return nil
+}
+
+type RevenueItem struct {
+	Period    string   `json:"period" cbor:"period"`
+	Action    string   `json:"action" cbor:"action"` // subscribe, renew or gift purchase, see LogAction*
+	CID       util.ID  `json:"cid" cbor:"cid"`
+	Kind      int8     `json:"kind" cbor:"kind"`
+	GID       util.ID  `json:"gid" cbor:"gid"` // the group where the subscription happened
+	SubPayee  *util.ID `json:"sub_payee,omitempty" cbor:"sub_payee,omitempty"`
+	Count     uint     `json:"count" cbor:"count"`
+	Amount    int64    `json:"amount" cbor:"amount"`
+	SysFee    int64    `json:"sys_fee" cbor:"sys_fee"`
+	SubShares int64    `json:"sub_shares" cbor:"sub_shares"`
+	Net       int64    `json:"net" cbor:"net"` // what the user actually received
+}
+
+const maxRevenuePages = 100
+
+// Revenue aggregates the user's subscription income. At most maxRevenuePages
+// pages of transactions are scanned, the next page token is returned if there
+// are older transactions in the time range.
+func (b *Walletbase) Revenue(ctx context.Context, uid util.ID, input *QueryRevenue) ([]RevenueItem, util.Bytes, error) {
+	txns := make([]TransactionOutput, 0)
+	page := &TransactionPagination{
+		UID:       uid,
+		PageSize:  util.Ptr(uint16(100)),
+		PageToken: input.pageToken,
+	}
+
+	// transactions are listed from newest to oldest
+	var next util.Bytes
+	for i := 0; ; i++ {
+		if i >= maxRevenuePages {
+			next = *page.PageToken
+			break
+		}
+
+		output, err := b.ListIncoming(ctx, page)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		done := len(output.NextPageToken) == 0
+		for _, txn := range output.Result {
+			at := txn.ID.Time().Unix()
+			if at < input.From {
+				done = true
+				break
+			}
+			if input.To > 0 && at > input.To {
+				continue
+			}
+			if input.CID != nil && !txn.hasCID(*input.CID) {
+				continue
+			}
+			txns = append(txns, txn)
+		}
+
+		if done {
+			break
+		}
+		page.PageToken = util.Ptr(output.NextPageToken)
+	}
+
+	return AggregateRevenue(uid, txns, input.Period), next, nil
+}
+
+func (txn *TransactionOutput) hasCID(cid util.ID) bool {
+	payload := txn.subscribePayload()
+	return payload != nil && payload.CID == cid
+}
+
+// subscribePayload decodes the payload of subscription transactions: the
+// payment code of subscribing and buying gifts, or the plan of auto-renewal.
+// It returns nil for other transactions.
+func (txn *TransactionOutput) subscribePayload() *SubscribePayload {
+	if txn.Payload == nil || len(*txn.Payload) == 0 {
+		return nil
+	}
+
+	switch txn.Description {
+	case LogActionCreationSubscribe, LogActionCollectionSubscribe, LogActionGroupSubscribe, LogActionGiftPurchase:
+		payload := &SubscribePayload{}
+		if err := cbor.Unmarshal(*txn.Payload, payload); err == nil {
+			return payload
+		}
+	case LogActionSubscriptionRenew:
+		plan := &RenewalPlan{}
+		if err := cbor.Unmarshal(*txn.Payload, plan); err == nil {
+			return &SubscribePayload{Kind: plan.Kind, GID: plan.GID, CID: plan.CID}
+		}
+	}
+	return nil
+}
+
+// AggregateRevenue groups committed subscription transactions by period, action,
+// cid, group and sub payee. period is "day" or "month", in UTC.
+func AggregateRevenue(uid util.ID, txns []TransactionOutput, period string) []RevenueItem {
+	layout := "2006-01"
+	if period == "day" {
+		layout = "2006-01-02"
+	}
+
+	type itemKey struct {
+		period   string
+		action   string
+		cid      util.ID
+		gid      util.ID
+		subPayee util.ID
+	}
+
+	idx := make(map[itemKey]int)
+	items := make([]RevenueItem, 0)
+	for _, txn := range txns {
+		if txn.Status != 1 {
+			continue
+		}
+		payload := txn.subscribePayload()
+		if payload == nil {
+			continue
+		}
+
+		k := itemKey{
+			period: txn.ID.Time().UTC().Format(layout),
+			action: txn.Description,
+			cid:    payload.CID,
+			gid:    payload.GID,
+		}
+		if txn.SubPayee != nil {
+			k.subPayee = *txn.SubPayee
+		}
+
+		i, ok := idx[k]
+		if !ok {
+			i = len(items)
+			idx[k] = i
+			items = append(items, RevenueItem{
+				Period:   k.period,
+				Action:   k.action,
+				CID:      k.cid,
+				Kind:     payload.Kind,
+				GID:      k.gid,
+				SubPayee: txn.SubPayee,
+			})
+		}
+
+		item := &items[i]
+		item.Count += 1
+		item.Amount += txn.Amount
+		item.SysFee += txn.SysFee
+		item.SubShares += txn.SubShares
+		switch {
+		case txn.Payee != nil && *txn.Payee == uid:
+			item.Net += txn.Amount - txn.SysFee - txn.SubShares
+		case txn.SubPayee != nil && *txn.SubPayee == uid:
+			item.Net += txn.SubShares
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Period != items[j].Period {
+			return items[i].Period > items[j].Period
+		}
+		if c := items[i].CID.Compare(items[j].CID); c != 0 {
+			return c < 0
+		}
+		return items[i].Action < items[j].Action
+	})
+	return items
+}
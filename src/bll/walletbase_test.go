@@ -3,16 +3,35 @@ package bll
 import (
 	"testing"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/yiwen-ai/yiwen-api/src/util"
 )
 
 func TestModel(t *testing.T) {
 	assert := assert.New(t)
 
-	g35 := GetAIModel("GPT-3.5")
-	g4 := GetAIModel("gpt-4")
+	g35, err := GetAIModel("GPT-3.5")
+	assert.NoError(err)
+	g4, err := GetAIModel("gpt-4")
+	assert.NoError(err)
+
+	md, err := GetAIModel("")
+	assert.NoError(err)
+	assert.Equal(g35, md)
+
+	_, err = GetAIModel("davinci")
+	assert.Error(err)
 
-	assert.Equal(g35, GetAIModel("davinci"))
+	assert.NoError(g35.Allow(0, "eng"))
+	assert.Error(g4.Allow(1, "eng"))
+	assert.NoError(g4.Allow(2, "eng"))
+	md = g4
+	md.Languages = []string{"zho"}
+	assert.Error(md.Allow(2, "eng"))
+	md.Deprecated = true
+	assert.Error(md.Allow(2, "zho"))
 
 	assert.Equal(float64(1.0), g35.Price)
 	assert.Equal(float64(10.0), g4.Price)
@@ -27,3 +46,114 @@ func TestModel(t *testing.T) {
 	assert.Equal(int64(26), g4.CostWEN(2597))
 	assert.Equal(int64(100), g4.CostWEN(10000))
 }
+
+func TestAggregateRevenue(t *testing.T) {
+	assert := assert.New(t)
+
+	uid := util.NewID()
+	sub := util.NewID()
+	cid := util.NewID()
+	gid := util.NewID()
+	payload, err := cbor.Marshal(&SubscribePayload{Kind: 0, GID: gid, CID: cid})
+	assert.NoError(err)
+
+	txns := []TransactionOutput{
+		{ID: util.NewID(), Status: 1, Payee: &uid, Amount: 100, SysFee: 10,
+			Description: LogActionCreationSubscribe, Payload: util.Ptr(util.Bytes(payload))},
+		{ID: util.NewID(), Status: 1, Payee: &uid, SubPayee: &sub, Amount: 100, SysFee: 10, SubShares: 20,
+			Description: LogActionCreationSubscribe, Payload: util.Ptr(util.Bytes(payload))},
+		{ID: util.NewID(), Status: 1, Payee: &uid, Amount: 100, SysFee: 10,
+			Description: LogActionCreationSubscribe, Payload: util.Ptr(util.Bytes(payload))},
+		{ID: util.NewID(), Status: -1, Payee: &uid, Amount: 100,
+			Description: LogActionCreationSubscribe, Payload: util.Ptr(util.Bytes(payload))},
+		{ID: util.NewID(), Status: 1, Payee: &uid, Amount: 100, Description: LogActionUserSpend},
+	}
+
+	items := AggregateRevenue(uid, txns, "day")
+	assert.Equal(2, len(items))
+	total := RevenueItem{}
+	for _, item := range items {
+		assert.Equal(cid, item.CID)
+		assert.Equal(gid, item.GID)
+		total.Count += item.Count
+		total.Amount += item.Amount
+		total.Net += item.Net
+	}
+	assert.Equal(uint(3), total.Count)
+	assert.Equal(int64(300), total.Amount)
+	assert.Equal(int64(250), total.Net)
+
+	items = AggregateRevenue(sub, txns, "month")
+	assert.Equal(2, len(items))
+	assert.Equal(int64(20), items[0].Net+items[1].Net)
+
+	group, err := cbor.Marshal(&SubscribePayload{Kind: 3, GID: gid, CID: gid})
+	assert.NoError(err)
+	plan, err := cbor.Marshal(&RenewalPlan{UID: util.NewID(), Kind: 0, GID: gid, CID: cid, Amount: 100})
+	assert.NoError(err)
+	txns = []TransactionOutput{
+		{ID: util.NewID(), Status: 1, Payee: &uid, Amount: 100,
+			Description: LogActionGroupSubscribe, Payload: util.Ptr(util.Bytes(group))},
+		{ID: util.NewID(), Status: 1, Payee: &uid, Amount: 100,
+			Description: LogActionSubscriptionRenew, Payload: util.Ptr(util.Bytes(plan))},
+		{ID: util.NewID(), Status: 1, Payee: &uid, Amount: 300,
+			Description: LogActionGiftPurchase, Payload: util.Ptr(util.Bytes(payload))},
+		{ID: util.NewID(), Status: 1, Payee: &uid, Amount: 100,
+			Description: LogActionCreationSubscribe, Payload: util.Ptr(util.Bytes(payload))},
+	}
+	items = AggregateRevenue(uid, txns, "month")
+	assert.Equal(4, len(items))
+	actions := map[string]RevenueItem{}
+	for _, item := range items {
+		actions[item.Action] = item
+	}
+	assert.Equal(int8(3), actions[LogActionGroupSubscribe].Kind)
+	assert.Equal(gid, actions[LogActionGroupSubscribe].CID)
+	assert.Equal(cid, actions[LogActionSubscriptionRenew].CID)
+	assert.Equal(int64(100), actions[LogActionSubscriptionRenew].Net)
+	assert.Equal(int64(300), actions[LogActionGiftPurchase].Amount)
+	assert.Equal(uint(1), actions[LogActionCreationSubscribe].Count)
+}
+
+func TestTransactionInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	cid := util.NewID()
+	data, err := cbor.Marshal(&SpendPayload{CID: &cid, Action: LogActionPublicationCreate, Model: "gpt-4", Tokens: 1000})
+	assert.NoError(err)
+
+	txn := &TransactionOutput{ID: util.NewID(), Amount: 10, Description: LogActionPublicationCreate,
+		Payload: util.Ptr(util.Bytes(data))}
+	info := txn.Info()
+	assert.Nil(info.Subscribe)
+	assert.NotNil(info.Spend)
+	assert.Equal(cid, *info.Spend.CID)
+	assert.Equal("gpt-4", info.Spend.Model)
+	assert.Equal(txn.ID.Time().Unix(), info.CreatedAt)
+
+	data, err = cbor.Marshal(&SubscribePayload{Kind: 2, CID: cid})
+	assert.NoError(err)
+	txn = &TransactionOutput{ID: util.NewID(), Description: LogActionCollectionSubscribe,
+		Payload: util.Ptr(util.Bytes(data))}
+	info = txn.Info()
+	assert.Nil(info.Spend)
+	assert.Equal(int8(2), info.Subscribe.Kind)
+	assert.Equal(cid, info.Subscribe.CID)
+	data, err = cbor.Marshal(&RenewalPlan{UID: util.NewID(), Kind: 2, CID: cid})
+	assert.NoError(err)
+	txn = &TransactionOutput{ID: util.NewID(), Description: LogActionSubscriptionRenew,
+		Payload: util.Ptr(util.Bytes(data))}
+	info = txn.Info()
+	assert.Nil(info.Spend)
+	assert.Equal(int8(2), info.Subscribe.Kind)
+	assert.Equal(cid, info.Subscribe.CID)
+
+	for _, action := range []string{LogActionGroupSubscribe, LogActionGiftPurchase} {
+		data, err = cbor.Marshal(&SubscribePayload{Kind: 3, CID: cid})
+		assert.NoError(err)
+		txn = &TransactionOutput{ID: util.NewID(), Description: action, Payload: util.Ptr(util.Bytes(data))}
+		info = txn.Info()
+		assert.Nil(info.Spend)
+		assert.Equal(int8(3), info.Subscribe.Kind)
+	}
+}
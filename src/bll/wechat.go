@@ -17,7 +17,7 @@ type Wechat struct {
 }
 
 func (b *Wechat) InitApp(ctx context.Context, _app *gear.App) error {
-	if conf.Config.Wechat.Secret == "" {
+	if conf.Current().Wechat.Secret == "" {
 		return nil
 	}
 
@@ -70,7 +70,7 @@ func (b *Wechat) GetTicket(ctx context.Context) (string, error) {
 }
 
 func (b *Wechat) redisKey(key string) string {
-	return fmt.Sprintf("wechat:%s:%s", conf.Config.Wechat.AppID, key)
+	return fmt.Sprintf("wechat:%s:%s", conf.Current().Wechat.AppID, key)
 }
 
 // {"access_token":"ACCESS_TOKEN","expires_in":7200}
@@ -83,13 +83,14 @@ type WechatToken struct {
 }
 
 func (b *Wechat) fetchAccessToken(ctx context.Context) error {
-	if conf.Config.Wechat.Secret == "" {
+	cfg := conf.Current().Wechat
+	if cfg.Secret == "" {
 		return fmt.Errorf("Wechat secret is empty")
 	}
 
 	api := fmt.Sprintf(
 		"https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s",
-		conf.Config.Wechat.AppID, conf.Config.Wechat.Secret)
+		cfg.AppID, cfg.Secret)
 	output := &WechatToken{}
 	if err := util.RequestJSON(ctx, util.ExternalHTTPClient, "GET", api, nil, output); err != nil {
 		return err
@@ -3,6 +3,7 @@ package bll
 import (
 	"context"
 	"net/url"
+	"sync/atomic"
 
 	"github.com/yiwen-ai/yiwen-api/src/service"
 	"github.com/yiwen-ai/yiwen-api/src/util"
@@ -11,7 +12,7 @@ import (
 type Writing struct {
 	svc             service.APIHost
 	oss             *service.OSS
-	Recommendations []PublicationOutputs
+	recommendations atomic.Pointer[[]PublicationOutputs]
 }
 
 type SearchInput struct {
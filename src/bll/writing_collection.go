@@ -208,6 +208,21 @@ func (i *TranslateCollectionInfoInput) Validate() error {
 	return nil
 }
 
+type TranslateCollectionChildrenInput struct {
+	ID            util.ID `json:"id" cbor:"id" validate:"required"`
+	GID           util.ID `json:"gid" cbor:"gid" validate:"required"`
+	Language      string  `json:"language" cbor:"language" validate:"required"` // target language
+	Model         *string `json:"model,omitempty" cbor:"model,omitempty" validate:"omitempty,gte=2,lte=16"`
+	ContentFilter *bool   `json:"content_filter,omitempty" cbor:"content_filter,omitempty"`
+}
+
+func (i *TranslateCollectionChildrenInput) Validate() error {
+	if err := util.Validator.Struct(i); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+	return nil
+}
+
 func (b *Writing) UpdateCollectionInfo(ctx context.Context, input *UpdateMessageInput) (*MessageOutput, error) {
 	output := SuccessResponse[MessageOutput]{}
 	if err := b.svc.Patch(ctx, "/v1/collection/info", input, &output); err != nil {
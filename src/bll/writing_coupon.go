@@ -0,0 +1,71 @@
+package bll
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+type UpdateCouponUsesInput struct {
+	GID       util.ID `json:"gid" cbor:"gid"`
+	Code      string  `json:"code" cbor:"code"`
+	Uses      int64   `json:"uses" cbor:"uses"`
+	UpdatedAt int64   `json:"updated_at" cbor:"updated_at"`
+}
+
+func (b *Writing) InternalListCoupons(ctx context.Context, gid util.ID) ([]CouponOutput, error) {
+	output := SuccessResponse[[]CouponOutput]{}
+	query := url.Values{}
+	query.Add("gid", gid.String())
+	if err := b.svc.Get(ctx, "/v1/group/coupon/list?"+query.Encode(), &output); err != nil {
+		return nil, err
+	}
+
+	return output.Result, nil
+}
+
+func (b *Writing) InternalGetCoupon(ctx context.Context, gid util.ID, code string) (*CouponOutput, error) {
+	output := SuccessResponse[CouponOutput]{}
+	query := url.Values{}
+	query.Add("gid", gid.String())
+	query.Add("code", code)
+	if err := b.svc.Get(ctx, "/v1/group/coupon?"+query.Encode(), &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+// InternalUpdateCoupon creates or updates the coupon, the uses are kept when updating.
+func (b *Writing) InternalUpdateCoupon(ctx context.Context, input *UpdateCouponInput) (*CouponOutput, error) {
+	output := SuccessResponse[CouponOutput]{}
+	if err := b.svc.Put(ctx, "/v1/group/coupon", input, &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+// InternalUpdateCouponUses sets the uses of the coupon, it fails with 409 if
+// the coupon is changed after input.UpdatedAt.
+func (b *Writing) InternalUpdateCouponUses(ctx context.Context, input *UpdateCouponUsesInput) (*CouponOutput, error) {
+	output := SuccessResponse[CouponOutput]{}
+	if err := b.svc.Patch(ctx, "/v1/group/coupon/uses", input, &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+func (b *Writing) InternalDeleteCoupon(ctx context.Context, gid util.ID, code string) (bool, error) {
+	output := SuccessResponse[bool]{}
+	query := url.Values{}
+	query.Add("gid", gid.String())
+	query.Add("code", code)
+	if err := b.svc.Delete(ctx, "/v1/group/coupon?"+query.Encode(), &output); err != nil {
+		return false, err
+	}
+
+	return output.Result, nil
+}
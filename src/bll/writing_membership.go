@@ -0,0 +1,50 @@
+package bll
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+func (b *Writing) InternalGetGroupMembership(ctx context.Context, gid util.ID) (*MembershipPlan, error) {
+	output := SuccessResponse[MembershipPlan]{}
+	query := url.Values{}
+	query.Add("gid", gid.String())
+	if err := b.svc.Get(ctx, "/v1/group/membership?"+query.Encode(), &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+func (b *Writing) InternalUpdateGroupMembership(ctx context.Context, input *UpdateMembershipPlanInput) (*MembershipPlan, error) {
+	output := SuccessResponse[MembershipPlan]{}
+	if err := b.svc.Put(ctx, "/v1/group/membership", input, &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+func (b *Writing) InternalListGroupSubscriptions(ctx context.Context, uid util.ID) ([]SubscriptionOutput, error) {
+	output := SuccessResponse[[]SubscriptionOutput]{}
+	query := url.Values{}
+	query.Add("uid", uid.String())
+	if err := b.svc.Get(ctx, "/v1/group/subscription/list?"+query.Encode(), &output); err != nil {
+		return nil, err
+	}
+
+	return output.Result, nil
+}
+
+// InternalUpdateGroupSubscription creates or renews the user's subscription of
+// the group, input.CID is the group id.
+func (b *Writing) InternalUpdateGroupSubscription(ctx context.Context, input *SubscriptionInput) (*SubscriptionOutput, error) {
+	output := SuccessResponse[SubscriptionOutput]{}
+	if err := b.svc.Put(ctx, "/v1/group/subscription", input, &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/teambition/gear"
 	"github.com/yiwen-ai/yiwen-api/src/conf"
 	"github.com/yiwen-ai/yiwen-api/src/content"
+	"github.com/yiwen-ai/yiwen-api/src/logging"
 	"github.com/yiwen-ai/yiwen-api/src/util"
 )
 
@@ -44,6 +46,7 @@ type CreatePublication struct {
 	Language string            `json:"language" cbor:"language"`
 	Version  uint16            `json:"version" cbor:"version"`
 	Draft    *PublicationDraft `json:"draft,omitempty" cbor:"draft,omitempty"`
+	Review   *util.ID          `json:"review,omitempty" cbor:"review,omitempty"` // the review task that holds the publication
 }
 
 type PublicationDraft struct {
@@ -83,6 +86,7 @@ type PublicationOutput struct {
 	RFP          *RFP                `json:"rfp,omitempty" cbor:"rfp,omitempty"`
 	FromGID      *util.ID            `json:"from_gid,omitempty" cbor:"from_gid,omitempty"`
 	GroupInfo    *GroupInfo          `json:"group_info,omitempty" cbor:"group_info,omitempty"`
+	Review       *util.ID            `json:"review,omitempty" cbor:"review,omitempty"`
 }
 
 type PublicationOutputs []PublicationOutput
@@ -211,7 +215,35 @@ func (i *PublicationOutput) IntoPublicationDraft(gid util.ID, language, model st
 }
 
 func (b *Writing) InitApp(ctx context.Context, _ *gear.App) error {
-	for _, v := range conf.Config.Recommendations {
+	if err := b.loadRecommendations(ctx, conf.Config.Recommendations); err != nil {
+		return err
+	}
+
+	h := gear.CtxValue[util.CtxHeader](ctx)
+	conf.OnReload(func(cfg *conf.ConfigTpl) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if h != nil {
+			ctx = gear.CtxWith[util.CtxHeader](ctx, h)
+		}
+		logging.CtxRun(ctx, "Writing.loadRecommendations", func(ctx context.Context) error {
+			return b.loadRecommendations(ctx, cfg.Recommendations)
+		})
+	})
+	return nil
+}
+
+// Recommendations returns the publications of the recommended collections.
+func (b *Writing) Recommendations() []PublicationOutputs {
+	if v := b.recommendations.Load(); v != nil {
+		return *v
+	}
+	return nil
+}
+
+func (b *Writing) loadRecommendations(ctx context.Context, list []conf.Recommendation) error {
+	recommendations := make([]PublicationOutputs, 0, len(list))
+	for _, v := range list {
 		res, err := b.GetPublicationList(ctx, 2, &QueryGidCid{
 			GID: v.GID,
 			CID: v.CID,
@@ -219,9 +251,10 @@ func (b *Writing) InitApp(ctx context.Context, _ *gear.App) error {
 		if err != nil {
 			return err
 		}
-		b.Recommendations = append(b.Recommendations, res.Result)
+		recommendations = append(recommendations, res.Result)
 	}
 
+	b.recommendations.Store(&recommendations)
 	return nil
 }
 
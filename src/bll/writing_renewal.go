@@ -0,0 +1,60 @@
+package bll
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+func (b *Writing) InternalGetRenewal(ctx context.Context, uid util.ID, kind int8, cid util.ID) (*RenewalPlan, error) {
+	output := SuccessResponse[RenewalPlan]{}
+	query := url.Values{}
+	query.Add("uid", uid.String())
+	query.Add("kind", strconv.Itoa(int(kind)))
+	query.Add("cid", cid.String())
+	if err := b.svc.Get(ctx, "/v1/subscription/renewal?"+query.Encode(), &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+// InternalUpdateRenewal creates or updates the renewal plan, it is due for
+// processing at plan.DueAt.
+func (b *Writing) InternalUpdateRenewal(ctx context.Context, plan *RenewalPlan) (*RenewalPlan, error) {
+	output := SuccessResponse[RenewalPlan]{}
+	if err := b.svc.Put(ctx, "/v1/subscription/renewal", plan, &output); err != nil {
+		return nil, err
+	}
+
+	return &output.Result, nil
+}
+
+func (b *Writing) InternalDeleteRenewal(ctx context.Context, uid util.ID, kind int8, cid util.ID) (bool, error) {
+	output := SuccessResponse[bool]{}
+	query := url.Values{}
+	query.Add("uid", uid.String())
+	query.Add("kind", strconv.Itoa(int(kind)))
+	query.Add("cid", cid.String())
+	if err := b.svc.Delete(ctx, "/v1/subscription/renewal?"+query.Encode(), &output); err != nil {
+		return false, err
+	}
+
+	return output.Result, nil
+}
+
+// InternalListDueRenewals returns at most size renewal plans due before the
+// unix time, the earliest due first.
+func (b *Writing) InternalListDueRenewals(ctx context.Context, before int64, size uint16) ([]RenewalPlan, error) {
+	output := SuccessResponse[[]RenewalPlan]{}
+	query := url.Values{}
+	query.Add("before", strconv.FormatInt(before, 10))
+	query.Add("page_size", strconv.Itoa(int(size)))
+	if err := b.svc.Get(ctx, "/v1/subscription/renewal/due?"+query.Encode(), &output); err != nil {
+		return nil, err
+	}
+
+	return output.Result, nil
+}
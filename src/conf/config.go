@@ -2,19 +2,23 @@ package conf
 
 import (
 	"context"
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"math/rand"
+	"net"
 	"os"
 	"path/filepath"
-	"sync"
+	"sort"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/BurntSushi/toml"
-	"github.com/fxamacker/cbor/v2"
-	"github.com/ldclabs/cose/key"
 	"github.com/teambition/gear"
+	gearLogging "github.com/teambition/gear/logging"
 	"github.com/yiwen-ai/yiwen-api/src/util"
 )
 
@@ -26,14 +30,20 @@ var AppVersion = "0.1.0"
 var BuildTime = "unknown"
 var GitSHA1 = "unknown"
 
-var once sync.Once
+// ConfigErr is the error of loading the config, it is reported by main with
+// the --check-config flag instead of panic.
+var ConfigErr error
 
 func init() {
 	p := &Config
-	readConfig(p, "../../config/default.toml")
-	if err := p.Validate(); err != nil {
-		panic(err)
+	ConfigErr = p.read("../../config/default.toml")
+	if ConfigErr == nil {
+		ConfigErr = p.Validate()
 	}
+	if ConfigErr != nil && !checkingConfig() {
+		panic(ConfigErr)
+	}
+	current.Store(p)
 	p.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
 	p.GlobalSignal = gear.ContextWithSignal(context.Background())
 
@@ -57,6 +67,33 @@ type Server struct {
 type Keys struct {
 	Hmac   string `json:"hmac" toml:"hmac"`
 	Aesgcm string `json:"aesgcm" toml:"aesgcm"`
+	// Ed25519 or ES256 key to sign subscription tokens, HMAC is used if empty
+	Signing string `json:"signing" toml:"signing"`
+}
+
+// KeyRings are the COSE key rings loaded from the key files.
+type KeyRings struct {
+	Hmac    *util.KeyRing
+	Aesgcm  *util.KeyRing
+	Signing *util.KeyRing // nil if no signing key configured
+}
+
+// Load reads the key ring files, it is called again to reload rotated keys.
+func (k Keys) Load() (*KeyRings, error) {
+	var err error
+	rings := &KeyRings{}
+	if rings.Hmac, err = readKeyRing(k.Hmac); err != nil {
+		return nil, err
+	}
+	if rings.Aesgcm, err = readKeyRing(k.Aesgcm); err != nil {
+		return nil, err
+	}
+	if k.Signing != "" {
+		if rings.Signing, err = readKeyRing(k.Signing); err != nil {
+			return nil, err
+		}
+	}
+	return rings, nil
 }
 
 type Redis struct {
@@ -88,6 +125,33 @@ type Wechat struct {
 	Secret string `json:"secret" toml:"secret"`
 }
 
+type Model struct {
+	ID            string   `json:"id" toml:"id"`
+	Name          string   `json:"name" toml:"name"`
+	Price         float64  `json:"price" toml:"price"`         // WEN per 1K tokens
+	Tokenizer     string   `json:"tokenizer" toml:"tokenizer"` // tiktoken encoding, default "cl100k_base"
+	ContextWindow uint32   `json:"context_window" toml:"context_window"`
+	MaxOutput     uint32   `json:"max_output" toml:"max_output"`
+	MinLevel      uint8    `json:"min_level" toml:"min_level"`   // minimum wallet level allowed to use
+	Languages     []string `json:"languages" toml:"languages"`   // enabled target languages, empty means all
+	Deprecated    bool     `json:"deprecated" toml:"deprecated"` // can not be used for new jobs
+}
+
+type Provider struct {
+	Name     string `json:"name" toml:"name"`
+	Kind     string `json:"kind" toml:"kind"`         // "jarvis" or "fake"
+	Endpoint string `json:"endpoint" toml:"endpoint"` // default to base.jarvis for "jarvis" kind
+}
+
+// ProviderRoute picks providers for AI jobs, empty condition matches any.
+type ProviderRoute struct {
+	Providers     []string  `json:"providers" toml:"providers"` // in failover order
+	Models        []string  `json:"models" toml:"models"`
+	FromLanguages []string  `json:"from_languages" toml:"from_languages"`
+	ToLanguages   []string  `json:"to_languages" toml:"to_languages"`
+	Groups        []util.ID `json:"groups" toml:"groups"`
+}
+
 type Recommendation struct {
 	GID util.ID `json:"gid" toml:"gid"`
 	CID util.ID `json:"cid" toml:"cid"`
@@ -95,9 +159,11 @@ type Recommendation struct {
 
 // ConfigTpl ...
 type ConfigTpl struct {
-	Rand            *rand.Rand
-	GlobalSignal    context.Context
-	GlobalShutdown  context.Context
+	Rand            *rand.Rand         `json:"-" toml:"-"`
+	GlobalSignal    context.Context    `json:"-" toml:"-"`
+	GlobalShutdown  context.Context    `json:"-" toml:"-"`
+	Version         string             `json:"version" toml:"-"`   // hash of the config file
+	LoadedAt        int64              `json:"loaded_at" toml:"-"` // unix seconds
 	Env             string             `json:"env" toml:"env"`
 	Logger          Logger             `json:"log" toml:"log"`
 	Server          Server             `json:"server" toml:"server"`
@@ -109,29 +175,177 @@ type ConfigTpl struct {
 	Wechat          Wechat             `json:"wechat" toml:"wechat"`
 	TokensRate      map[string]float32 `json:"tokens_rate" toml:"tokens_rate"`
 	Recommendations []Recommendation   `json:"recommendations" toml:"recommendations"`
-	COSEKeys        struct {
-		Hmac   key.Key
-		Aesgcm key.Key
-	}
+	Reviewers       []util.ID          `json:"reviewers" toml:"reviewers"` // users who can review refunds
+	Admins          []util.ID          `json:"admins" toml:"admins"`       // users who can access admin APIs
+	Models          []Model            `json:"models" toml:"models"`
+	Providers       []Provider         `json:"providers" toml:"providers"`
+	Routes          []ProviderRoute    `json:"routes" toml:"routes"`
+	COSEKeys        KeyRings           `json:"-" toml:"-"`
 
-	globalJobs int64 // global async jobs counter for graceful shutdown
+	filePath   string
+	files      []string  // the config files in layers
+	modTime    time.Time // the latest modification time of the files
+	globalJobs int64     // global async jobs counter for graceful shutdown
 }
 
+// Validate checks the config and loads the key rings, all the invalid fields
+// are reported together.
 func (c *ConfigTpl) Validate() error {
-	var err error
+	errs := &configErrors{}
 	execDir := os.Getenv("EXEC_DIR_PATH")
 	if execDir != "" {
 		c.Keys.Hmac = filepath.Join(execDir, c.Keys.Hmac)
 		c.Keys.Aesgcm = filepath.Join(execDir, c.Keys.Aesgcm)
+		if c.Keys.Signing != "" {
+			c.Keys.Signing = filepath.Join(execDir, c.Keys.Signing)
+		}
 	}
 
-	if c.COSEKeys.Hmac, err = readKey(c.Keys.Hmac); err != nil {
-		return err
+	switch c.Env {
+	case "test", "dev", "prod":
+	default:
+		errs.add("env", "should be one of test, dev, prod, got %q", c.Env)
 	}
-	if c.COSEKeys.Aesgcm, err = readKey(c.Keys.Aesgcm); err != nil {
-		return err
+	if _, err := gearLogging.ParseLevel(c.Logger.Level); err != nil {
+		errs.add("log.level", "%v", err)
 	}
-	return nil
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		errs.add("server.addr", "should be host:port, %v", err)
+	}
+	if c.Server.GracefulShutdown > 3600 {
+		errs.add("server.graceful_shutdown", "should be at most 3600 seconds, got %d", c.Server.GracefulShutdown)
+	}
+
+	if c.Keys.Hmac == "" || c.Keys.Aesgcm == "" {
+		errs.add("keys", "hmac and aesgcm are required")
+	} else if rings, err := c.Keys.Load(); err != nil {
+		errs.add("keys", "%v", err)
+	} else {
+		c.COSEKeys = *rings
+	}
+
+	if _, _, err := net.SplitHostPort(c.Redis.Node); err != nil {
+		errs.add("redis.node", "should be host:port, %v", err)
+	}
+	if c.Redis.Prefix == "" {
+		errs.add("redis.prefix", "is required")
+	}
+
+	for _, b := range []struct{ name, url string }{
+		{"userbase", c.Base.Userbase},
+		{"writing", c.Base.Writing},
+		{"jarvis", c.Base.Jarvis},
+		{"logbase", c.Base.Logbase},
+		{"taskbase", c.Base.Taskbase},
+		{"webscraper", c.Base.Webscraper},
+		{"walletbase", c.Base.Walletbase},
+	} {
+		errs.checkURL("base."+b.name, b.url)
+	}
+
+	for _, o := range []struct {
+		name string
+		oss  *OSS
+	}{{"oss", &c.OSS}, {"oss_pic", &c.OSSPic}} {
+		if o.oss.Bucket == "" {
+			errs.add(o.name+".bucket", "is required")
+		}
+		if o.oss.Endpoint == "" {
+			errs.add(o.name+".endpoint", "is required")
+		}
+		errs.checkURL(o.name+".base_url", o.oss.BaseUrl)
+		if c.Env == "prod" && (o.oss.AccessKeyId == "" || o.oss.AccessKeySecret == "") {
+			errs.add(o.name, "access_key_id and access_key_secret are required in prod")
+		}
+	}
+	if (c.Wechat.AppID == "") != (c.Wechat.Secret == "") {
+		errs.add("wechat", "appid and secret should be set together")
+	}
+
+	langs := make([]string, 0, len(c.TokensRate))
+	for lang := range c.TokensRate {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		if rate := c.TokensRate[lang]; rate <= 0 || rate > 100 {
+			errs.add(fmt.Sprintf("tokens_rate.%q", lang), "should be in (0, 100], got %v", rate)
+		}
+	}
+	for i, r := range c.Recommendations {
+		if r.GID == util.ZeroID || r.CID == util.ZeroID {
+			errs.add(fmt.Sprintf("recommendations[%d]", i), "gid and cid are required")
+		}
+	}
+	errs.checkIDs("reviewers", c.Reviewers)
+	errs.checkIDs("admins", c.Admins)
+
+	if len(c.Models) == 0 {
+		errs.add("models", "no models configured")
+	}
+	ids := make(map[string]struct{}, len(c.Models))
+	for i, m := range c.Models {
+		name := fmt.Sprintf("models[%d]", i)
+		if m.ID == "" || m.Price <= 0 {
+			errs.add(name, "invalid model %q: id and price are required", m.ID)
+		}
+		if _, ok := ids[strings.ToLower(m.ID)]; ok {
+			errs.add(name, "duplicate model %q", m.ID)
+		}
+		ids[strings.ToLower(m.ID)] = struct{}{}
+		if m.ContextWindow == 0 || m.MaxOutput == 0 || m.MaxOutput > m.ContextWindow {
+			errs.add(name, "max_output should be in (0, context_window], got %d and %d", m.MaxOutput, m.ContextWindow)
+		}
+		for _, lang := range m.Languages {
+			if util.Lang639_3(lang) == "" {
+				errs.add(name+".languages", "unknown language %q", lang)
+			}
+		}
+	}
+
+	if len(c.Providers) == 0 {
+		c.Providers = []Provider{{Name: "jarvis", Kind: "jarvis"}}
+	}
+	names := make(map[string]struct{}, len(c.Providers))
+	for i, p := range c.Providers {
+		name := fmt.Sprintf("providers[%d]", i)
+		if p.Name == "" {
+			errs.add(name, "invalid provider: name is required")
+		}
+		if _, ok := names[p.Name]; ok {
+			errs.add(name, "duplicate provider %q", p.Name)
+		}
+		names[p.Name] = struct{}{}
+		switch p.Kind {
+		case "jarvis":
+			if p.Endpoint == "" {
+				c.Providers[i].Endpoint = c.Base.Jarvis
+			} else {
+				errs.checkURL(name+".endpoint", p.Endpoint)
+			}
+		case "fake":
+		default:
+			errs.add(name, "invalid provider %q: unknown kind %q", p.Name, p.Kind)
+		}
+	}
+	for i, r := range c.Routes {
+		name := fmt.Sprintf("routes[%d]", i)
+		if len(r.Providers) == 0 {
+			errs.add(name, "invalid route: providers is required")
+		}
+		for _, p := range r.Providers {
+			if _, ok := names[p]; !ok {
+				errs.add(name, "invalid route: unknown provider %q", p)
+			}
+		}
+		for _, m := range r.Models {
+			if _, ok := ids[strings.ToLower(m)]; !ok {
+				errs.add(name, "invalid route: unknown model %q", m)
+			}
+		}
+		errs.checkIDs(name+".groups", r.Groups)
+	}
+	return errs.err()
 }
 
 func (c *ConfigTpl) ObtainJob() {
@@ -146,37 +360,99 @@ func (c *ConfigTpl) JobsIdle() bool {
 	return atomic.LoadInt64(&c.globalJobs) <= 0
 }
 
-func readKey(filePath string) (k key.Key, err error) {
-	var data []byte
-	data, err = os.ReadFile(filePath)
+func readKeyRing(filePath string) (*util.KeyRing, error) {
+	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return
+		return nil, err
 	}
-	data, err = base64.RawURLEncoding.DecodeString(string(data))
+	ring, err := util.ParseKeyRing(data)
 	if err != nil {
-		return
+		return nil, fmt.Errorf("invalid key file %q: %w", filePath, err)
 	}
-	err = cbor.Unmarshal(data, &k)
-	return
+	return ring, nil
 }
 
-func readConfig(v interface{}, path ...string) {
-	once.Do(func() {
-		filePath, err := getConfigFilePath(path...)
-		if err != nil {
-			panic(err)
+// checkingConfig reports whether the --check-config flag is set, it is called
+// before flag.Parse so it accepts the same forms: -check-config,
+// --check-config and --check-config=<bool>.
+func checkingConfig() bool {
+	for _, arg := range os.Args[1:] {
+		if arg == "--" {
+			break
 		}
-
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			panic(err)
+		if !strings.HasPrefix(arg, "-") {
+			continue
 		}
+		name, val, hasVal := strings.Cut(strings.TrimPrefix(arg[1:], "-"), "=")
+		if name != "check-config" {
+			continue
+		}
+		if !hasVal {
+			return true
+		}
+		// an invalid value is reported by flag.Parse
+		b, err := strconv.ParseBool(val)
+		return b || err != nil
+	}
+	return false
+}
+
+// read loads the config in layers: the config file, the file of the env in the
+// same directory such as "prod.toml", then the YWAPI_* environment variables.
+func (c *ConfigTpl) read(path ...string) error {
+	filePath, err := getConfigFilePath(path...)
+	if err != nil {
+		return err
+	}
 
-		_, err = toml.Decode(string(data), v)
-		if err != nil {
-			panic(err)
+	h := sha256.New()
+	if err = c.decodeFile(filePath, h); err != nil {
+		return err
+	}
+
+	env := c.Env
+	if v := os.Getenv(EnvPrefix + "ENV"); v != "" {
+		env = v
+	}
+	if env != "" {
+		envFile := filepath.Join(filepath.Dir(filePath), env+".toml")
+		if _, err = os.Stat(envFile); err == nil && envFile != filepath.Clean(filePath) {
+			if err = c.decodeFile(envFile, h); err != nil {
+				return err
+			}
 		}
-	})
+	}
+
+	if _, err = applyEnv(c, os.Environ(), h); err != nil {
+		return err
+	}
+
+	c.Version = hex.EncodeToString(h.Sum(nil)[:8])
+	c.LoadedAt = time.Now().Unix()
+	c.filePath = filePath
+	return nil
+}
+
+func (c *ConfigTpl) decodeFile(filePath string, h hash.Hash) error {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	if _, err = toml.Decode(string(data), c); err != nil {
+		return fmt.Errorf("invalid config file %q: %w", filePath, err)
+	}
+
+	h.Write(data)
+	c.files = append(c.files, filePath)
+	if fi.ModTime().After(c.modTime) {
+		c.modTime = fi.ModTime()
+	}
+	return nil
 }
 
 func getConfigFilePath(path ...string) (string, error) {
@@ -0,0 +1,126 @@
+package conf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	gearLogging "github.com/teambition/gear/logging"
+)
+
+// EnvPrefix is the prefix of environment variables that override the config.
+// The name is the upper-cased key path joined by "__", for example:
+//
+//	YWAPI_ENV=prod
+//	YWAPI_LOG__LEVEL=debug
+//	YWAPI_WECHAT__SECRET=xxx
+//	YWAPI_REVIEWERS='["cil6ehjmps48vprp24f0"]'
+//
+// With the "_FILE" suffix, the value is read from the file, such as a
+// Kubernetes secret mount: YWAPI_OSS__ACCESS_KEY_SECRET_FILE=/run/secrets/oss.
+// Values that are not strings are in TOML syntax.
+const EnvPrefix = "YWAPI_"
+
+const envFileSuffix = "_FILE"
+
+// applyEnv overrides the config with the environment variables, it returns the
+// applied names in order. The applied values are written to h for versioning,
+// unknown names are logged as a warning.
+func applyEnv(v any, environ []string, h io.Writer) ([]string, error) {
+	envs := make(map[string]string)
+	for _, kv := range environ {
+		if name, val, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(name, EnvPrefix) {
+			envs[name] = val
+		}
+	}
+	if len(envs) == 0 {
+		return nil, nil
+	}
+
+	applied := make([]string, 0, len(envs))
+	err := walkEnv(reflect.ValueOf(v).Elem(), EnvPrefix, func(name string, field reflect.Value) error {
+		raw, ok := envs[name]
+		if ok {
+			delete(envs, name)
+		} else if file, has := envs[name+envFileSuffix]; has {
+			delete(envs, name+envFileSuffix)
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name+envFileSuffix, err)
+			}
+			raw, ok = strings.TrimRight(string(data), "\r\n"), true
+			name += envFileSuffix
+		}
+		if !ok {
+			return nil
+		}
+
+		if err := setEnvValue(field, raw); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		applied = append(applied, name)
+		fmt.Fprintf(h, "%s=%s\n", name, raw)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(envs) > 0 {
+		unknown := make([]string, 0, len(envs))
+		for name := range envs {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		// may be a typo, or a variable for a newer or older version
+		gearLogging.Warning(fmt.Sprintf("unknown config environment variables: %s", strings.Join(unknown, ", ")))
+	}
+	sort.Strings(applied)
+	return applied, nil
+}
+
+func walkEnv(v reflect.Value, prefix string, fn func(name string, field reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, _, _ := strings.Cut(sf.Tag.Get("toml"), ",")
+		if !sf.IsExported() || tag == "" || tag == "-" {
+			continue
+		}
+
+		name := prefix + strings.ToUpper(tag)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := walkEnv(field, name+"__", fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(name, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setEnvValue(field reflect.Value, raw string) error {
+	if field.Kind() == reflect.String {
+		field.SetString(raw)
+		return nil
+	}
+
+	holder := reflect.New(reflect.StructOf([]reflect.StructField{{
+		Name: "V",
+		Type: field.Type(),
+		Tag:  `toml:"v"`,
+	}}))
+	if _, err := toml.Decode("v = "+raw, holder.Interface()); err != nil {
+		return err
+	}
+	field.Set(holder.Elem().Field(0))
+	return nil
+}
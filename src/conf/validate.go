@@ -0,0 +1,47 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/yiwen-ai/yiwen-api/src/util"
+)
+
+// configErrors collects the invalid fields of the config, each error is
+// prefixed with the key path of the field.
+type configErrors []error
+
+func (e *configErrors) add(field, format string, args ...any) {
+	*e = append(*e, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+func (e *configErrors) checkURL(field, s string) {
+	if s == "" {
+		e.add(field, "is required")
+		return
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		e.add(field, "invalid url %q, %v", s, err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		e.add(field, "invalid url %q, should be http(s)://host", s)
+	}
+}
+
+func (e *configErrors) checkIDs(field string, ids []util.ID) {
+	for i, id := range ids {
+		if id == util.ZeroID {
+			e.add(fmt.Sprintf("%s[%d]", field, i), "invalid zero id")
+		}
+	}
+}
+
+func (e configErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n%w", errors.Join(e...))
+}
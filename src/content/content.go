@@ -29,8 +29,9 @@ type DocumentNode struct {
 }
 
 type TEContent struct {
-	ID    string   `json:"id" cbor:"id"`
-	Texts []string `json:"texts" cbor:"texts"`
+	ID      string   `json:"id" cbor:"id"`
+	Texts   []string `json:"texts" cbor:"texts"`
+	Heading bool     `json:"-" cbor:"-"` // the content is a heading, work as a section boundary
 }
 
 func (te *TEContent) Equal(b *TEContent) bool {
@@ -58,7 +59,7 @@ func (te *TEContents) visitNode(node *DocumentNode) {
 	var content *TEContent
 	if v, ok := node.Attrs["id"]; ok {
 		if id := v.ToString(); id != "" {
-			content = &TEContent{ID: id, Texts: make([]string, 0)}
+			content = &TEContent{ID: id, Texts: make([]string, 0), Heading: node.Type == "heading"}
 			*te = append(*te, content)
 		}
 	}
@@ -96,6 +97,68 @@ func (d DocumentNode) ToTEContents() TEContents {
 	return *tes
 }
 
+func (te *TEContent) Tokens() uint32 {
+	tokens := uint32(2)
+	for _, text := range te.Texts {
+		tokens += util.Tiktokens(text) + 2
+	}
+	return tokens
+}
+
+func (te TEContents) Tokens() uint32 {
+	tokens := uint32(0)
+	for _, v := range te {
+		tokens += v.Tokens()
+	}
+	return tokens
+}
+
+// Split splits the contents into chunks at section boundaries (the "------"
+// separators and headings), each chunk has at most maxTokens tokens.
+// A section larger than maxTokens will be split at content boundaries.
+func (te TEContents) Split(maxTokens uint32) []TEContents {
+	sections := make([]TEContents, 0)
+	var section TEContents
+	for _, v := range te {
+		if (v.ID == "------" || v.Heading) && len(section) > 0 {
+			sections = append(sections, section)
+			section = nil
+		}
+		section = append(section, v)
+	}
+	if len(section) > 0 {
+		sections = append(sections, section)
+	}
+
+	chunks := make([]TEContents, 0)
+	var chunk TEContents
+	size := uint32(0)
+	add := func(vs TEContents, tokens uint32) {
+		if size+tokens > maxTokens && len(chunk) > 0 {
+			chunks = append(chunks, chunk)
+			chunk = nil
+			size = 0
+		}
+		chunk = append(chunk, vs...)
+		size += tokens
+	}
+
+	for _, s := range sections {
+		if tokens := s.Tokens(); tokens <= maxTokens {
+			add(s, tokens)
+			continue
+		}
+
+		for _, v := range s {
+			add(TEContents{v}, v.Tokens())
+		}
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
 func (d *DocumentNode) FromTEContents(te TEContents) {
 	textMap := make(map[string][]string, len(te))
 	for i := range te {
@@ -229,3 +229,91 @@ func TestContentFilter(t *testing.T) {
 	te.ContentFilter()
 	assert.Equal(te[0].Texts, []string{"some 暴力** text"})
 }
+
+func TestSplit(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := os.ReadFile("./content.json")
+	require.NoError(t, err)
+
+	var doc DocumentNode
+	err = json.Unmarshal(data, &doc)
+	require.NoError(t, err)
+
+	contents := doc.ToTEContents()
+	chunks := contents.Split(util.MAX_CHUNK_TOKENS)
+	assert.Equal(1, len(chunks))
+	assert.Equal(len(contents), len(chunks[0]))
+
+	te := TEContents{
+		{ID: "h1", Texts: []string{"Chapter 1"}, Heading: true},
+		{ID: "p1", Texts: []string{"some text in chapter one"}},
+		{ID: "------", Texts: []string{}},
+		{ID: "p2", Texts: []string{"some text after separator"}},
+		{ID: "h2", Texts: []string{"Chapter 2"}, Heading: true},
+		{ID: "p3", Texts: []string{"some text in chapter two"}},
+		{ID: "p4", Texts: []string{"more text in chapter two"}},
+	}
+	chunks = te.Split(te[0].Tokens() + te[1].Tokens())
+	ids := make([][]string, 0, len(chunks))
+	for _, c := range chunks {
+		s := make([]string, 0, len(c))
+		for _, v := range c {
+			s = append(s, v.ID)
+		}
+		ids = append(ids, s)
+	}
+	assert.Equal([][]string{{"h1", "p1"}, {"------", "p2"}, {"h2", "p3"}, {"p4"}}, ids)
+
+	merged := TEContents{}
+	for _, c := range te.Split(te.Tokens()) {
+		merged = append(merged, c...)
+	}
+	assert.Equal(te, merged)
+}
+
+func TestCheckTranslation(t *testing.T) {
+	assert := assert.New(t)
+
+	var src, dst TEContents
+	data, err := os.ReadFile("./content.te.json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &src))
+	data, err = os.ReadFile("./content.te.zho.json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &dst))
+
+	report := CheckTranslation(src, dst)
+	assert.True(report.Passed(), report)
+	assert.True(report.Paragraphs > 0)
+
+	src = TEContents{
+		{ID: "p1", Texts: []string{"The price rose to 1,250.5 dollars in 2023, see https://example.com/report for details."}},
+		{ID: "p2", Texts: []string{"This paragraph is not translated at all by the model."}},
+		{ID: "p3", Texts: []string{"This paragraph is lost."}},
+		{ID: "p4", Texts: []string{"A short paragraph with some words in it."}},
+		{ID: "p5", Texts: []string{"Another paragraph with some words in it."}},
+	}
+	dst = TEContents{
+		{ID: "p1", Texts: []string{"价格在2023年涨到了1.250,5美元，详情见报告。"}},
+		{ID: "p2", Texts: []string{"This paragraph is not translated at all by the model."}},
+		{ID: "p3", Texts: []string{}},
+		{ID: "p4", Texts: []string{"一个简短的段落，其中有一些词。这里还有很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多很多额外的内容。"}},
+		{ID: "p5", Texts: []string{"另一个包含一些词的段落。"}},
+	}
+
+	report = CheckTranslation(src, dst)
+	kinds := map[string]string{}
+	for _, issue := range report.Issues {
+		kinds[issue.ID+":"+issue.Kind] = issue.Detail
+	}
+	assert.Equal("https://example.com/report", kinds["p1:"+QualityIssueURL])
+	_, ok := kinds["p1:"+QualityIssueNumber]
+	assert.False(ok)
+	assert.Contains(kinds, "p2:"+QualityIssueUntranslated)
+	assert.Contains(kinds, "p3:"+QualityIssueEmpty)
+	assert.Contains(kinds, "p4:"+QualityIssueLength)
+	assert.Equal(5, report.Paragraphs)
+	assert.Equal(0.4, report.Score) // 1 - (0.5 + 1 + 1 + 0.5) / 5
+	assert.False(report.Passed())
+}
@@ -0,0 +1,179 @@
+package content
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// publications with a lower quality score need a human review.
+const MinQualityScore = 0.7
+
+const (
+	QualityIssueEmpty        = "empty"
+	QualityIssueUntranslated = "untranslated"
+	QualityIssueLength       = "length"
+	QualityIssueNumber       = "number"
+	QualityIssueURL          = "url"
+)
+
+var qualityIssueWeights = map[string]float64{
+	QualityIssueEmpty:        1,
+	QualityIssueUntranslated: 1,
+	QualityIssueLength:       0.5,
+	QualityIssueNumber:       0.5,
+	QualityIssueURL:          0.5,
+}
+
+type QualityIssue struct {
+	ID     string `json:"id" cbor:"id"`
+	Kind   string `json:"kind" cbor:"kind"`
+	Detail string `json:"detail,omitempty" cbor:"detail,omitempty"`
+}
+
+// QualityReport is the result of the quality estimation of a translation.
+type QualityReport struct {
+	Score      float64        `json:"score" cbor:"score"`
+	Paragraphs int            `json:"paragraphs" cbor:"paragraphs"`
+	Issues     []QualityIssue `json:"issues" cbor:"issues"`
+}
+
+func (r *QualityReport) Passed() bool {
+	return r.Score >= MinQualityScore
+}
+
+var (
+	urlRe    = regexp.MustCompile(`https?://[^\s<>"')\]]+`)
+	numberRe = regexp.MustCompile(`\d+(?:[.,]\d+)*`)
+)
+
+// CheckTranslation estimates the quality of the translated contents by
+// comparing them with the source contents paragraph by paragraph. It checks
+// empty or untranslated paragraphs, length ratio outliers, and numbers or
+// URLs that are lost in the translation.
+func CheckTranslation(src, dst TEContents) *QualityReport {
+	dstMap := make(map[string]*TEContent, len(dst))
+	for _, v := range dst {
+		dstMap[v.ID] = v
+	}
+
+	type pair struct {
+		id       string
+		src, dst string
+	}
+	pairs := make([]pair, 0, len(src))
+	report := &QualityReport{Issues: []QualityIssue{}}
+	penalties := make(map[string]float64)
+	addIssue := func(id, kind, detail string) {
+		report.Issues = append(report.Issues, QualityIssue{ID: id, Kind: kind, Detail: detail})
+		penalties[id] = math.Min(1, penalties[id]+qualityIssueWeights[kind])
+	}
+
+	for _, s := range src {
+		srcText := strings.TrimSpace(strings.Join(s.Texts, " "))
+		if srcText == "" {
+			continue
+		}
+
+		report.Paragraphs++
+		d, ok := dstMap[s.ID]
+		if !ok {
+			addIssue(s.ID, QualityIssueEmpty, "missing")
+			continue
+		}
+		dstText := strings.TrimSpace(strings.Join(d.Texts, " "))
+		if dstText == "" {
+			addIssue(s.ID, QualityIssueEmpty, "")
+			continue
+		}
+		// short texts such as names and terms are often kept as is
+		if dstText == srcText && countLetters(srcText) >= 20 {
+			addIssue(s.ID, QualityIssueUntranslated, "")
+			continue
+		}
+
+		if lost := missingItems(numberItems(srcText), numberItems(dstText)); len(lost) > 0 {
+			addIssue(s.ID, QualityIssueNumber, strings.Join(lost, ", "))
+		}
+		if lost := missingItems(urlRe.FindAllString(srcText, -1), urlRe.FindAllString(dstText, -1)); len(lost) > 0 {
+			addIssue(s.ID, QualityIssueURL, strings.Join(lost, ", "))
+		}
+		pairs = append(pairs, pair{id: s.ID, src: srcText, dst: dstText})
+	}
+
+	// length ratio between languages varies, so outliers are compared with
+	// the median ratio of the document.
+	ratios := make([]float64, 0, len(pairs))
+	for _, p := range pairs {
+		ratios = append(ratios, lengthRatio(p.src, p.dst))
+	}
+	if len(ratios) > 0 {
+		sorted := append([]float64{}, ratios...)
+		sort.Float64s(sorted)
+		median := sorted[len(sorted)/2]
+		for i, p := range pairs {
+			if utf8.RuneCountInString(p.src) < 20 {
+				continue
+			}
+			if r := ratios[i] / median; r > 3 || r < 1.0/3 {
+				addIssue(p.id, QualityIssueLength, "")
+			}
+		}
+	}
+
+	report.Score = 1
+	if report.Paragraphs > 0 {
+		total := 0.0
+		for _, v := range penalties {
+			total += v
+		}
+		report.Score = math.Round((1-total/float64(report.Paragraphs))*1000) / 1000
+	}
+	return report
+}
+
+func lengthRatio(src, dst string) float64 {
+	return float64(utf8.RuneCountInString(dst)) / float64(utf8.RuneCountInString(src))
+}
+
+func countLetters(s string) int {
+	n := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// numberItems returns the numbers in the text with separators removed,
+// as they are localized in some languages, e.g. "1,000.5" and "1.000,5".
+func numberItems(s string) []string {
+	s = urlRe.ReplaceAllString(s, " ")
+	items := numberRe.FindAllString(s, -1)
+	for i, v := range items {
+		items[i] = strings.NewReplacer(",", "", ".", "").Replace(v)
+	}
+	return items
+}
+
+// missingItems returns the items in src that are not in dst, with duplicates counted.
+func missingItems(src, dst []string) []string {
+	counts := make(map[string]int, len(dst))
+	for _, v := range dst {
+		counts[v]++
+	}
+
+	lost := []string{}
+	for _, v := range src {
+		if counts[v] > 0 {
+			counts[v]--
+		} else {
+			lost = append(lost, v)
+		}
+	}
+	return lost
+}
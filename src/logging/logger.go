@@ -19,6 +19,12 @@ func init() {
 	if err != nil {
 		Logger.Err(err)
 	}
+
+	conf.OnReload(func(cfg *conf.ConfigTpl) {
+		if err := gearLogging.SetLoggerLevel(Logger, cfg.Logger.Level); err != nil {
+			Logger.Err(err)
+		}
+	})
 }
 
 // AccessLogger is used for access log
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	"github.com/bsm/redislock"
@@ -65,6 +66,220 @@ func (s *Redis) SetCBOR(ctx context.Context, key string, val any, ttl uint) erro
 	return nil
 }
 
+// UpdateCBOR reads the key into val, calls fn to change it and writes it back
+// in a transaction. It fails with 409 if the key is changed by others meanwhile.
+func (s *Redis) UpdateCBOR(ctx context.Context, key string, val any, ttl uint, fn func() error) error {
+	err := s.cli.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, s.prefix+key).Bytes()
+		if err != nil && err != redis.Nil {
+			return gear.ErrInternalServerError.From(err)
+		}
+		if err == nil {
+			if err = cbor.Unmarshal(data, val); err != nil {
+				return gear.ErrInternalServerError.From(err)
+			}
+		}
+		if err = fn(); err != nil {
+			return err
+		}
+
+		if data, err = cbor.Marshal(val); err != nil {
+			return gear.ErrBadRequest.From(err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, s.prefix+key, data, time.Duration(ttl)*time.Second)
+			return nil
+		})
+		return err
+	}, s.prefix+key)
+
+	if err == redis.TxFailedErr {
+		return gear.ErrConflict.WithMsgf("key %q updated by others", key)
+	} else if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return nil
+}
+
+// TakeCBOR gets and deletes the key, it returns false if the key does not exist.
+func (s *Redis) TakeCBOR(ctx context.Context, key string, val any) (bool, error) {
+	data, err := s.cli.GetDel(ctx, s.prefix+key).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, gear.ErrInternalServerError.From(err)
+	}
+	if err = cbor.Unmarshal(data, val); err != nil {
+		return false, gear.ErrInternalServerError.From(err)
+	}
+	return true, nil
+}
+
+// SetCBORNX sets the value only if the key does not exist, it returns false if the key exists.
+func (s *Redis) SetCBORNX(ctx context.Context, key string, val any, ttl uint) (bool, error) {
+	data, err := cbor.Marshal(val)
+	if err != nil {
+		return false, gear.ErrBadRequest.From(err)
+	}
+	ok, err := s.cli.SetNX(ctx, s.prefix+key, data, time.Duration(ttl)*time.Second).Result()
+	if err != nil {
+		return false, gear.ErrInternalServerError.From(err)
+	}
+	return ok, nil
+}
+
+// HIncrMulti increments hash fields of many keys in one pipeline.
+// If ttl > 0, the expiration of every touched key is refreshed.
+func (s *Redis) HIncrMulti(ctx context.Context, hashes map[string]map[string]int64, ttl uint) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	_, err := s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, fields := range hashes {
+			for field, n := range fields {
+				pipe.HIncrBy(ctx, s.prefix+key, field, n)
+			}
+			if ttl > 0 {
+				pipe.Expire(ctx, s.prefix+key, time.Duration(ttl)*time.Second)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return nil
+}
+
+// PFAddMulti adds members to many HyperLogLog keys in one pipeline.
+func (s *Redis) PFAddMulti(ctx context.Context, hlls map[string][]string, ttl uint) error {
+	if len(hlls) == 0 {
+		return nil
+	}
+
+	_, err := s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, members := range hlls {
+			els := make([]any, 0, len(members))
+			for _, m := range members {
+				els = append(els, m)
+			}
+			pipe.PFAdd(ctx, s.prefix+key, els...)
+			if ttl > 0 {
+				pipe.Expire(ctx, s.prefix+key, time.Duration(ttl)*time.Second)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return nil
+}
+
+// PFCountMulti returns the cardinality of each HyperLogLog key.
+func (s *Redis) PFCountMulti(ctx context.Context, keys ...string) ([]int64, error) {
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.PFCount(ctx, s.prefix+key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, gear.ErrInternalServerError.From(err)
+	}
+
+	res := make([]int64, len(keys))
+	for i, cmd := range cmds {
+		res[i] = cmd.Val()
+	}
+	return res, nil
+}
+
+// HGetAllMulti returns all fields of each hash key.
+func (s *Redis) HGetAllMulti(ctx context.Context, keys ...string) ([]map[string]string, error) {
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	_, err := s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(ctx, s.prefix+key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, gear.ErrInternalServerError.From(err)
+	}
+
+	res := make([]map[string]string, len(keys))
+	for i, cmd := range cmds {
+		res[i] = cmd.Val()
+	}
+	return res, nil
+}
+
+// IncrByMulti increments many keys by n in one pipeline and returns the new values.
+func (s *Redis) IncrByMulti(ctx context.Context, keys []string, n int64, ttl uint) ([]int64, error) {
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.IncrBy(ctx, s.prefix+key, n)
+			if ttl > 0 {
+				pipe.Expire(ctx, s.prefix+key, time.Duration(ttl)*time.Second)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, gear.ErrInternalServerError.From(err)
+	}
+
+	res := make([]int64, len(keys))
+	for i, cmd := range cmds {
+		res[i] = cmd.Val()
+	}
+	return res, nil
+}
+
+// GetInts returns the integer value of each key, 0 for a missing key.
+func (s *Redis) GetInts(ctx context.Context, keys ...string) ([]int64, error) {
+	pkeys := make([]string, len(keys))
+	for i, key := range keys {
+		pkeys[i] = s.prefix + key
+	}
+	vals, err := s.cli.MGet(ctx, pkeys...).Result()
+	if err != nil {
+		return nil, gear.ErrInternalServerError.From(err)
+	}
+
+	res := make([]int64, len(keys))
+	for i, v := range vals {
+		if str, ok := v.(string); ok {
+			res[i], _ = strconv.ParseInt(str, 10, 64)
+		}
+	}
+	return res, nil
+}
+
+func (s *Redis) Del(ctx context.Context, keys ...string) error {
+	pkeys := make([]string, len(keys))
+	for i, key := range keys {
+		pkeys[i] = s.prefix + key
+	}
+	if err := s.cli.Del(ctx, pkeys...).Err(); err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return nil
+}
+
+// Take deletes the key and reports whether it existed, the first caller wins.
+func (s *Redis) Take(ctx context.Context, key string) (bool, error) {
+	n, err := s.cli.Del(ctx, s.prefix+key).Result()
+	if err != nil {
+		return false, gear.ErrInternalServerError.From(err)
+	}
+	return n > 0, nil
+}
+
 type Locker struct {
 	prefix string
 	locker *redislock.Client
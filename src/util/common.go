@@ -4,6 +4,7 @@ import (
 	crand "crypto/rand"
 	"encoding/base64"
 	"math/rand"
+	"strings"
 	"time"
 	"unicode/utf8"
 )
@@ -57,3 +58,20 @@ func Reverse(s string) string {
 	}
 	return string(buf)
 }
+
+// Truncate returns s with at most n runes. It cuts at the last sentence end
+// in the second half of the limit if there is one.
+func Truncate(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	rs := []rune(s)[:n]
+	for i := len(rs) - 1; i >= n/2; i-- {
+		switch rs[i] {
+		case '.', '!', '?', '。', '！', '？', '\n':
+			return strings.TrimSpace(string(rs[:i+1]))
+		}
+	}
+	return strings.TrimSpace(string(rs))
+}
@@ -27,3 +27,11 @@ func TestReverse(t *testing.T) {
 		assert.Equal(t, s, Reverse(Reverse(s)))
 	}
 }
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "Hello", Truncate("Hello", 10))
+	assert.Equal(t, "Hello", Truncate("Hello", 0))
+	assert.Equal(t, "Hello world.", Truncate("Hello world. How are you?", 20))
+	assert.Equal(t, "你好，世界。", Truncate("你好，世界。今天天气很好", 10))
+	assert.Equal(t, "Hello", Truncate("Hello world", 6))
+}
@@ -3,9 +3,13 @@ package util
 import (
 	"encoding/base64"
 	"errors"
+	"fmt"
 
 	"github.com/ldclabs/cose/cose"
+	"github.com/ldclabs/cose/iana"
 	"github.com/ldclabs/cose/key"
+	"github.com/ldclabs/cose/key/ecdsa"
+	"github.com/ldclabs/cose/key/ed25519"
 )
 
 func EncodeMac0[T any](macer key.MACer, obj T, externalData []byte) (string, error) {
@@ -67,3 +71,90 @@ func DecodeEncrypt0[T any](encryptor key.Encryptor, input string, externalData [
 
 	return &obj.Payload, nil
 }
+
+// EncodeSign1 signs the object with an asymmetric key. The headers are left
+// nil so that the alg is added to the protected headers and the kid to the
+// unprotected headers, verifiers pick the public key by the kid.
+func EncodeSign1[T any](signer key.Signer, obj T, externalData []byte) (string, error) {
+	m := &cose.Sign1Message[T]{
+		Payload: obj,
+	}
+	data, err := m.SignAndEncode(signer, externalData)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func DecodeSign1[T any](verifier key.Verifier, input string, externalData []byte) (*T, error) {
+	if input == "" {
+		return nil, errors.New("empty input")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(input)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := cose.VerifySign1Message[T](verifier, data, externalData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &obj.Payload, nil
+}
+
+// ToPublicKey returns the public key of an Ed25519 or ECDSA key.
+func ToPublicKey(k key.Key) (key.Key, error) {
+	switch k.Kty() {
+	case iana.KeyTypeOKP:
+		return ed25519.ToPublicKey(k)
+	case iana.KeyTypeEC2:
+		return ecdsa.ToPublicKey(k)
+	}
+	return nil, fmt.Errorf("unsupported key type %d", k.Kty())
+}
+
+// ToJWK converts a public COSE key to a JSON Web Key (RFC 7517), only
+// Ed25519 and ES256 keys are supported.
+func ToJWK(k key.Key) (map[string]any, error) {
+	jwk := map[string]any{
+		"use": "sig",
+		"kid": base64.RawURLEncoding.EncodeToString(k.Kid()),
+	}
+
+	switch k.Alg() {
+	case iana.AlgorithmEdDSA:
+		x, err := k.GetBytes(iana.OKPKeyParameterX)
+		if err != nil {
+			return nil, err
+		}
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["alg"] = "EdDSA"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(x)
+	case iana.AlgorithmES256:
+		x, err := k.GetBytes(iana.EC2KeyParameterX)
+		if err != nil {
+			return nil, err
+		}
+		y, err := k.GetBytes(iana.EC2KeyParameterY)
+		if err != nil {
+			return nil, err
+		}
+		jwk["kty"] = "EC"
+		jwk["crv"] = "P-256"
+		jwk["alg"] = "ES256"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(leftPad(x, 32))
+		jwk["y"] = base64.RawURLEncoding.EncodeToString(leftPad(y, 32))
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %d", k.Alg())
+	}
+	return jwk, nil
+}
+
+func leftPad(b []byte, n int) []byte {
+	if len(b) >= n {
+		return b
+	}
+	return append(make([]byte, n-len(b)), b...)
+}
@@ -1,12 +1,17 @@
 package util
 
 import (
+	"encoding/base64"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/ldclabs/cose/cose"
 	"github.com/ldclabs/cose/iana"
+	"github.com/ldclabs/cose/key"
 	"github.com/ldclabs/cose/key/aesgcm"
+	"github.com/ldclabs/cose/key/ecdsa"
+	"github.com/ldclabs/cose/key/ed25519"
 	"github.com/ldclabs/cose/key/hmac"
 	"github.com/stretchr/testify/assert"
 )
@@ -74,3 +79,55 @@ func TestEncrypt0(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(obj, *obj2)
 }
+
+func TestSign1(t *testing.T) {
+	assert := assert.New(t)
+
+	obj := PaymentCode{
+		Kind:     2,
+		ExpireAt: uint64(time.Now().Add(time.Hour).Unix()),
+		UID:      NewID(),
+		CID:      NewID(),
+	}
+
+	edKey, err := ed25519.GenerateKey()
+	assert.NoError(err)
+	esKey, err := ecdsa.GenerateKey(iana.AlgorithmES256)
+	assert.NoError(err)
+
+	for _, k := range []key.Key{edKey, esKey} {
+		signer, err := k.Signer()
+		assert.NoError(err)
+		text, err := EncodeSign1(signer, obj, []byte("SubscriptionToken"))
+		assert.NoError(err)
+
+		pk, err := ToPublicKey(k)
+		assert.NoError(err)
+		assert.False(pk.Has(iana.OKPKeyParameterD))
+		verifier, err := pk.Verifier()
+		assert.NoError(err)
+
+		obj2, err := DecodeSign1[PaymentCode](verifier, text, []byte("SubscriptionToken"))
+		assert.NoError(err)
+		assert.Equal(obj, *obj2)
+
+		data, err := base64.RawURLEncoding.DecodeString(text)
+		assert.NoError(err)
+		msg, err := cose.VerifySign1Message[PaymentCode](verifier, data, []byte("SubscriptionToken"))
+		assert.NoError(err)
+		kid, err := msg.Unprotected.GetBytes(iana.HeaderParameterKid)
+		assert.NoError(err)
+		assert.Equal([]byte(k.Kid()), kid)
+		alg, err := msg.Protected.GetInt(iana.HeaderParameterAlg)
+		assert.NoError(err)
+		assert.Equal(int(k.Alg()), alg)
+
+		_, err = DecodeSign1[PaymentCode](verifier, text, []byte("PaymentCode"))
+		assert.Error(err)
+
+		jwk, err := ToJWK(pk)
+		assert.NoError(err)
+		assert.Equal("sig", jwk["use"])
+		assert.NotEmpty(jwk["x"])
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"strconv"
+	"time"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/google/uuid"
@@ -50,6 +51,11 @@ func (id ID) Compare(other ID) int {
 	return xid.ID(id).Compare(xid.ID(other))
 }
 
+// Time returns the creation time embedded in the ID.
+func (id ID) Time() time.Time {
+	return xid.ID(id).Time()
+}
+
 func (id ID) MarshalCBOR() ([]byte, error) {
 	return cbor.Marshal(xid.ID(id).Bytes())
 }
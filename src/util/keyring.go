@@ -0,0 +1,344 @@
+package util
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ldclabs/cose/iana"
+	"github.com/ldclabs/cose/key"
+)
+
+// KeyRing holds the keys of one purpose by kid. New messages are created with
+// the active key, and verified or decrypted with any key that is not retired.
+type KeyRing struct {
+	Active  key.ByteStr   `cbor:"active"`
+	Keys    key.KeySet    `cbor:"keys"`
+	Retired []key.ByteStr `cbor:"retired,omitempty"`
+}
+
+// ParseKeyRing parses a key ring file. A file with a single key, the format
+// before key rings, is parsed as a ring with the key active.
+func ParseKeyRing(data []byte) (*KeyRing, error) {
+	data, err := base64.RawURLEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &KeyRing{}
+	var k key.Key
+	if err = cbor.Unmarshal(data, &k); err == nil && k.Has(iana.KeyParameterKty) {
+		if err = r.Add(k); err != nil {
+			return nil, err
+		}
+		r.Active = r.Keys[0].Kid()
+	} else if err = cbor.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+
+	if err = r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Marshal encodes the key ring in the format of key files.
+func (r *KeyRing) Marshal() ([]byte, error) {
+	data, err := cbor.Marshal(cbor.Tag{
+		Number:  55799, // self described CBOR Tag
+		Content: r,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.RawURLEncoding.EncodeToString(data)), nil
+}
+
+func (r *KeyRing) Validate() error {
+	if len(r.Keys) == 0 {
+		return errors.New("key ring: no keys")
+	}
+	for i, k := range r.Keys {
+		if len(k.Kid()) == 0 {
+			return fmt.Errorf("key ring: key %d without kid", i)
+		}
+		if r.Keys[:i].Lookup(k.Kid()) != nil {
+			return fmt.Errorf("key ring: duplicate kid %s", kidText(k.Kid()))
+		}
+	}
+	for _, kid := range r.Retired {
+		if r.Keys.Lookup(kid) == nil {
+			return fmt.Errorf("key ring: retired kid %s not found", kidText(kid))
+		}
+	}
+	if r.ActiveKey() == nil {
+		return fmt.Errorf("key ring: active kid %s not found", kidText(r.Active))
+	}
+	if r.IsRetired(r.Active) {
+		return fmt.Errorf("key ring: active kid %s is retired", kidText(r.Active))
+	}
+	return nil
+}
+
+func (r *KeyRing) ActiveKey() key.Key {
+	return r.Keys.Lookup(r.Active)
+}
+
+func (r *KeyRing) IsRetired(kid key.ByteStr) bool {
+	for _, id := range r.Retired {
+		if bytes.Equal(id, kid) {
+			return true
+		}
+	}
+	return false
+}
+
+// Usable returns the keys that are not retired, the active key first.
+func (r *KeyRing) Usable() key.KeySet {
+	ks := make(key.KeySet, 0, len(r.Keys))
+	if k := r.ActiveKey(); k != nil {
+		ks = append(ks, k)
+	}
+	for _, k := range r.Keys {
+		if !bytes.Equal(k.Kid(), r.Active) && !r.IsRetired(k.Kid()) {
+			ks = append(ks, k)
+		}
+	}
+	return ks
+}
+
+// Add adds a key to the ring, a kid is derived from the key if missing.
+func (r *KeyRing) Add(k key.Key) error {
+	if len(k.Kid()) == 0 {
+		k.SetKid(key.SumKid(k.Bytesify()))
+	}
+	if r.Keys.Lookup(k.Kid()) != nil {
+		return fmt.Errorf("key ring: duplicate kid %s", kidText(k.Kid()))
+	}
+	if len(r.Keys) > 0 && r.Keys[0].Alg() != k.Alg() {
+		return fmt.Errorf("key ring: key algorithm %d mismatch with %d", k.Alg(), r.Keys[0].Alg())
+	}
+	r.Keys = append(r.Keys, k)
+	return nil
+}
+
+// Activate makes the key the active key, messages created with the previous
+// active key are still valid until it is retired.
+func (r *KeyRing) Activate(kid key.ByteStr) error {
+	if r.Keys.Lookup(kid) == nil {
+		return fmt.Errorf("key ring: kid %s not found", kidText(kid))
+	}
+	if r.IsRetired(kid) {
+		return fmt.Errorf("key ring: kid %s is retired", kidText(kid))
+	}
+	r.Active = kid
+	return nil
+}
+
+// Retire stops accepting messages created with the key.
+func (r *KeyRing) Retire(kid key.ByteStr) error {
+	if r.Keys.Lookup(kid) == nil {
+		return fmt.Errorf("key ring: kid %s not found", kidText(kid))
+	}
+	if bytes.Equal(kid, r.Active) {
+		return fmt.Errorf("key ring: can not retire the active kid %s", kidText(kid))
+	}
+	if !r.IsRetired(kid) {
+		r.Retired = append(r.Retired, kid)
+	}
+	return nil
+}
+
+// MACerRing is a key.MACer backed by a key ring, it can be reloaded safely.
+type MACerRing struct {
+	v atomic.Pointer[macerRing]
+}
+
+type macerRing struct {
+	active key.MACer
+	all    []key.MACer
+}
+
+func NewMACerRing(r *KeyRing) (*MACerRing, error) {
+	m := &MACerRing{}
+	if err := m.Load(r); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *MACerRing) Load(r *KeyRing) error {
+	v := &macerRing{}
+	for _, k := range r.Usable() {
+		macer, err := k.MACer()
+		if err != nil {
+			return err
+		}
+		v.all = append(v.all, macer)
+	}
+	if len(v.all) == 0 {
+		return errors.New("key ring: no usable keys")
+	}
+	v.active = v.all[0]
+	m.v.Store(v)
+	return nil
+}
+
+func (m *MACerRing) MACCreate(data []byte) ([]byte, error) {
+	return m.v.Load().active.MACCreate(data)
+}
+
+func (m *MACerRing) MACVerify(data, mac []byte) (err error) {
+	for _, macer := range m.v.Load().all {
+		if err = macer.MACVerify(data, mac); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (m *MACerRing) Key() key.Key {
+	return m.v.Load().active.Key()
+}
+
+// EncryptorRing is a key.Encryptor backed by a key ring, it can be reloaded safely.
+type EncryptorRing struct {
+	v atomic.Pointer[encryptorRing]
+}
+
+type encryptorRing struct {
+	active key.Encryptor
+	all    []key.Encryptor
+}
+
+func NewEncryptorRing(r *KeyRing) (*EncryptorRing, error) {
+	e := &EncryptorRing{}
+	if err := e.Load(r); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *EncryptorRing) Load(r *KeyRing) error {
+	v := &encryptorRing{}
+	for _, k := range r.Usable() {
+		encryptor, err := k.Encryptor()
+		if err != nil {
+			return err
+		}
+		v.all = append(v.all, encryptor)
+	}
+	if len(v.all) == 0 {
+		return errors.New("key ring: no usable keys")
+	}
+	v.active = v.all[0]
+	e.v.Store(v)
+	return nil
+}
+
+func (e *EncryptorRing) Encrypt(nonce, plaintext, additionalData []byte) ([]byte, error) {
+	return e.v.Load().active.Encrypt(nonce, plaintext, additionalData)
+}
+
+func (e *EncryptorRing) Decrypt(nonce, ciphertext, additionalData []byte) (data []byte, err error) {
+	for _, encryptor := range e.v.Load().all {
+		if data, err = encryptor.Decrypt(nonce, ciphertext, additionalData); err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+func (e *EncryptorRing) NonceSize() int {
+	return e.v.Load().active.NonceSize()
+}
+
+func (e *EncryptorRing) Key() key.Key {
+	return e.v.Load().active.Key()
+}
+
+// SignerRing is a key.Signer backed by a key ring of asymmetric keys, it can
+// be reloaded safely. Its Verifier accepts signatures of any usable key.
+type SignerRing struct {
+	v atomic.Pointer[signerRing]
+}
+
+type signerRing struct {
+	active    key.Signer
+	verifiers []key.Verifier
+	public    key.KeySet
+}
+
+func NewSignerRing(r *KeyRing) (*SignerRing, error) {
+	s := &SignerRing{}
+	if err := s.Load(r); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SignerRing) Load(r *KeyRing) error {
+	usable := r.Usable()
+	if len(usable) == 0 {
+		return errors.New("key ring: no usable keys")
+	}
+
+	v := &signerRing{}
+	var err error
+	if v.active, err = usable[0].Signer(); err != nil {
+		return err
+	}
+	for _, k := range usable {
+		pk, err := ToPublicKey(k)
+		if err != nil {
+			return err
+		}
+		verifier, err := pk.Verifier()
+		if err != nil {
+			return err
+		}
+		v.public = append(v.public, pk)
+		v.verifiers = append(v.verifiers, verifier)
+	}
+	s.v.Store(v)
+	return nil
+}
+
+func (s *SignerRing) Sign(data []byte) ([]byte, error) {
+	return s.v.Load().active.Sign(data)
+}
+
+func (s *SignerRing) Key() key.Key {
+	return s.v.Load().active.Key()
+}
+
+// PublicKeys returns the public keys of all usable keys, the active key first.
+func (s *SignerRing) PublicKeys() key.KeySet {
+	return s.v.Load().public
+}
+
+func (s *SignerRing) Verifier() key.Verifier {
+	return (*verifierRing)(s)
+}
+
+type verifierRing SignerRing
+
+func (s *verifierRing) Verify(data, sig []byte) (err error) {
+	for _, verifier := range s.v.Load().verifiers {
+		if err = verifier.Verify(data, sig); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *verifierRing) Key() key.Key {
+	return s.v.Load().public[0]
+}
+
+func kidText(kid []byte) string {
+	return base64.RawURLEncoding.EncodeToString(kid)
+}
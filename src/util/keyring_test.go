@@ -0,0 +1,128 @@
+package util
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ldclabs/cose/iana"
+	"github.com/ldclabs/cose/key"
+	"github.com/ldclabs/cose/key/aesgcm"
+	"github.com/ldclabs/cose/key/ed25519"
+	"github.com/ldclabs/cose/key/hmac"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyRing(t *testing.T) {
+	assert := assert.New(t)
+
+	k1, err := hmac.GenerateKey(iana.AlgorithmHMAC_256_64)
+	assert.NoError(err)
+	data, err := cbor.Marshal(cbor.Tag{Number: 55799, Content: k1})
+	assert.NoError(err)
+
+	// legacy single key file
+	ring, err := ParseKeyRing([]byte(base64.RawURLEncoding.EncodeToString(data)))
+	assert.NoError(err)
+	assert.Equal(k1.Kid(), ring.Active)
+	assert.Equal(1, len(ring.Usable()))
+
+	macer, err := NewMACerRing(ring)
+	assert.NoError(err)
+	obj := PaymentCode{Kind: 2, Payee: NewID(), Amount: 100}
+	text1, err := EncodeMac0(macer, obj, nil)
+	assert.NoError(err)
+
+	k2, err := hmac.GenerateKey(iana.AlgorithmHMAC_256_64)
+	assert.NoError(err)
+	assert.NoError(ring.Add(k2))
+	assert.Error(ring.Add(k2))
+	assert.NoError(ring.Activate(k2.Kid()))
+	assert.Error(ring.Retire(k2.Kid()))
+
+	data, err = ring.Marshal()
+	assert.NoError(err)
+	ring, err = ParseKeyRing(data)
+	assert.NoError(err)
+	assert.Equal(key.ByteStr(k2.Kid()), ring.Active)
+	assert.Equal(2, len(ring.Usable()))
+
+	assert.NoError(macer.Load(ring))
+	assert.Equal(k2.Kid(), macer.Key().Kid())
+	obj2, err := DecodeMac0[PaymentCode](macer, text1, nil)
+	assert.NoError(err)
+	assert.Equal(obj, *obj2)
+	text2, err := EncodeMac0(macer, obj, nil)
+	assert.NoError(err)
+
+	assert.NoError(ring.Retire(k1.Kid()))
+	assert.Error(ring.Activate(k1.Kid()))
+	assert.NoError(ring.Validate())
+	assert.NoError(macer.Load(ring))
+	_, err = DecodeMac0[PaymentCode](macer, text1, nil)
+	assert.Error(err)
+	_, err = DecodeMac0[PaymentCode](macer, text2, nil)
+	assert.NoError(err)
+
+	k3, err := aesgcm.GenerateKey(iana.AlgorithmA256GCM)
+	assert.NoError(err)
+	assert.Error(ring.Add(k3))
+}
+
+func TestEncryptorRing(t *testing.T) {
+	assert := assert.New(t)
+
+	k1, err := aesgcm.GenerateKey(iana.AlgorithmA256GCM)
+	assert.NoError(err)
+	ring := &KeyRing{}
+	assert.NoError(ring.Add(k1))
+	assert.NoError(ring.Activate(ring.Keys[0].Kid()))
+	encryptor, err := NewEncryptorRing(ring)
+	assert.NoError(err)
+
+	obj := PaymentCode{Kind: 2, Payee: NewID(), Amount: 100}
+	text, err := EncodeEncrypt0(encryptor, obj, []byte("PaymentCode"))
+	assert.NoError(err)
+
+	k2, err := aesgcm.GenerateKey(iana.AlgorithmA256GCM)
+	assert.NoError(err)
+	assert.NoError(ring.Add(k2))
+	assert.NoError(ring.Activate(ring.Keys[1].Kid()))
+	assert.NoError(encryptor.Load(ring))
+
+	obj2, err := DecodeEncrypt0[PaymentCode](encryptor, text, []byte("PaymentCode"))
+	assert.NoError(err)
+	assert.Equal(obj, *obj2)
+}
+
+func TestSignerRing(t *testing.T) {
+	assert := assert.New(t)
+
+	k1, err := ed25519.GenerateKey()
+	assert.NoError(err)
+	ring := &KeyRing{Active: k1.Kid()}
+	assert.NoError(ring.Add(k1))
+	signer, err := NewSignerRing(ring)
+	assert.NoError(err)
+
+	obj := PaymentCode{Kind: 2, Payee: NewID(), Amount: 100}
+	text, err := EncodeSign1(signer, obj, nil)
+	assert.NoError(err)
+
+	k2, err := ed25519.GenerateKey()
+	assert.NoError(err)
+	assert.NoError(ring.Add(k2))
+	assert.NoError(ring.Activate(k2.Kid()))
+	assert.NoError(signer.Load(ring))
+	assert.Equal(2, len(signer.PublicKeys()))
+	assert.Equal(k2.Kid(), signer.Verifier().Key().Kid())
+
+	obj2, err := DecodeSign1[PaymentCode](signer.Verifier(), text, nil)
+	assert.NoError(err)
+	assert.Equal(obj, *obj2)
+
+	assert.NoError(ring.Retire(k1.Kid()))
+	assert.NoError(signer.Load(ring))
+	_, err = DecodeSign1[PaymentCode](signer.Verifier(), text, nil)
+	assert.Error(err)
+}
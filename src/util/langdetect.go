@@ -0,0 +1,271 @@
+package util
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DetectLang detects the language of the text in process, it returns the
+// ISO 639-3 code and a confidence score in [0, 1]. The result is empty if
+// the text has no letters. Languages with a unique script in Languages are
+// detected by script. Scripts shared by many languages are detected by trigram
+// profiles of a few common languages, or as the most common language of the
+// script, and the confidence is at most maxAmbiguousConfidence, so that the
+// result should be confirmed by the providers.
+func DetectLang(text string) (string, float64) {
+	scripts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range langScripts {
+			if unicode.Is(s.table, r) {
+				scripts[s.name]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return "", 0
+	}
+
+	script, count := "", 0
+	for name, n := range scripts {
+		if n > count || (n == count && name < script) {
+			script, count = name, n
+		}
+	}
+	// kana is mixed with Han in Japanese, a few kana in Chinese text are
+	// ambiguous, such as quoted names.
+	ambiguous := false
+	if kana, han := scripts["Hiragana"]+scripts["Katakana"], scripts["Han"]; kana > 0 && kana+han >= count {
+		if kana*5 >= kana+han {
+			script, count = "Kana", kana+han
+		} else {
+			script, count, ambiguous = "Han", kana+han, true
+		}
+	}
+	if script == "" {
+		return "", 0
+	}
+
+	share := float64(count) / float64(letters)
+	lengthFactor := math.Min(1, float64(letters)/30)
+	if lang, ok := scriptLangs[script]; ok {
+		confidence := share * math.Max(lengthFactor, 0.5)
+		if ambiguous {
+			confidence = math.Min(confidence, maxAmbiguousConfidence)
+		}
+		return lang, confidence
+	}
+	if lang, ok := sharedScriptLangs[script]; ok {
+		return lang, math.Min(share*math.Max(lengthFactor, 0.5), maxAmbiguousConfidence)
+	}
+
+	profiles := langProfiles[script]
+	if len(profiles) == 0 {
+		return "", 0
+	}
+
+	grams := trigrams(text)
+	type scored struct {
+		lang  string
+		score float64
+	}
+	res := make([]scored, 0, len(profiles))
+	for lang, p := range profiles {
+		res = append(res, scored{lang, p.similarity(grams)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].score == res[j].score {
+			return res[i].lang < res[j].lang
+		}
+		return res[i].score > res[j].score
+	})
+
+	best := res[0]
+	if best.score == 0 {
+		return "", 0
+	}
+	margin := 1.0
+	if len(res) > 1 {
+		margin = math.Min(1, 3*(best.score-res[1].score)/best.score)
+	}
+	return best.lang, math.Min(share*margin*lengthFactor, maxAmbiguousConfidence)
+}
+
+// maxAmbiguousConfidence is the max confidence of the languages detected from
+// a script shared by many languages.
+const maxAmbiguousConfidence = 0.5
+
+type langScript struct {
+	name  string
+	table *unicode.RangeTable
+}
+
+var langScripts = []langScript{
+	{"Latin", unicode.Latin},
+	{"Han", unicode.Han},
+	{"Hiragana", unicode.Hiragana},
+	{"Katakana", unicode.Katakana},
+	{"Hangul", unicode.Hangul},
+	{"Cyrillic", unicode.Cyrillic},
+	{"Arabic", unicode.Arabic},
+	{"Devanagari", unicode.Devanagari},
+	{"Greek", unicode.Greek},
+	{"Hebrew", unicode.Hebrew},
+	{"Thai", unicode.Thai},
+	{"Bengali", unicode.Bengali},
+	{"Gujarati", unicode.Gujarati},
+	{"Gurmukhi", unicode.Gurmukhi},
+	{"Oriya", unicode.Oriya},
+	{"Tamil", unicode.Tamil},
+	{"Telugu", unicode.Telugu},
+	{"Kannada", unicode.Kannada},
+	{"Malayalam", unicode.Malayalam},
+	{"Sinhala", unicode.Sinhala},
+	{"Georgian", unicode.Georgian},
+	{"Armenian", unicode.Armenian},
+	{"Ethiopic", unicode.Ethiopic},
+	{"Khmer", unicode.Khmer},
+	{"Lao", unicode.Lao},
+	{"Myanmar", unicode.Myanmar},
+	{"Tibetan", unicode.Tibetan},
+	{"Thaana", unicode.Thaana},
+}
+
+// scripts that used by only one language in Languages, Han is also used in
+// Japanese but mixed with kana.
+var scriptLangs = map[string]string{
+	"Han":       "zho",
+	"Kana":      "jpn",
+	"Hangul":    "kor",
+	"Greek":     "ell",
+	"Thai":      "tha",
+	"Gujarati":  "guj",
+	"Gurmukhi":  "pan",
+	"Oriya":     "ori",
+	"Tamil":     "tam",
+	"Telugu":    "tel",
+	"Kannada":   "kan",
+	"Malayalam": "mal",
+	"Sinhala":   "sin",
+	"Georgian":  "kat",
+	"Armenian":  "hye",
+	"Khmer":     "khm",
+	"Lao":       "lao",
+	"Myanmar":   "mya",
+	"Thaana":    "div",
+}
+
+// the most common language of the scripts without trigram profiles, which are
+// shared by other languages:
+// Hebrew with yid, Bengali with asm, Ethiopic with tir, Tibetan with dzo.
+var sharedScriptLangs = map[string]string{
+	"Hebrew":   "heb",
+	"Bengali":  "ben",
+	"Ethiopic": "amh",
+	"Tibetan":  "bod",
+}
+
+type langProfile map[string]float64
+
+func (p langProfile) similarity(grams map[string]float64) float64 {
+	dot, norm := 0.0, 0.0
+	for g, v := range grams {
+		dot += v * p[g]
+		norm += v * v
+	}
+	if norm == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(norm)
+}
+
+// trigrams returns the normalized trigram frequencies of the words.
+func trigrams(text string) map[string]float64 {
+	grams := make(map[string]float64)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.Is(unicode.Mn, r) && !unicode.Is(unicode.Mc, r)
+	}) {
+		rs := []rune(" " + w + " ")
+		for i := 0; i+3 <= len(rs); i++ {
+			grams[string(rs[i:i+3])]++
+		}
+	}
+	return grams
+}
+
+func newLangProfile(sample string) langProfile {
+	grams := trigrams(sample)
+	norm := 0.0
+	for _, v := range grams {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	for g := range grams {
+		grams[g] /= norm
+	}
+	return grams
+}
+
+// script -> language -> profile
+var langProfiles = make(map[string]map[string]langProfile)
+
+func init() {
+	for script, samples := range langSamples {
+		langProfiles[script] = make(map[string]langProfile, len(samples))
+		for lang, sample := range samples {
+			langProfiles[script][lang] = newLangProfile(sample)
+		}
+	}
+}
+
+// samples are the article 1 of the Universal Declaration of Human Rights,
+// with some of the most common words of the language.
+var langSamples = map[string]map[string]string{
+	"Latin": {
+		"eng": "All human beings are born free and equal in dignity and rights. They are endowed with reason and conscience and should act towards one another in a spirit of brotherhood. the of and to in is that it for was with as on be at by this have from or had not but what which you were we when there can an your would will their",
+		"fra": "Tous les êtres humains naissent libres et égaux en dignité et en droits. Ils sont doués de raison et de conscience et doivent agir les uns envers les autres dans un esprit de fraternité. le la les de des du un une et est que qui dans pour pas sur ce il elle nous vous avec plus par mais comme être avoir",
+		"deu": "Alle Menschen sind frei und gleich an Würde und Rechten geboren. Sie sind mit Vernunft und Gewissen begabt und sollen einander im Geist der Brüderlichkeit begegnen. der die das und ist nicht ein eine ich sie es zu den mit von auf für sich auch dem wird werden noch nach wie über",
+		"spa": "Todos los seres humanos nacen libres e iguales en dignidad y derechos y, dotados como están de razón y conciencia, deben comportarse fraternalmente los unos con los otros. el la los las de del que en y un una por con para es se no su al lo como más pero sus le ya este",
+		"por": "Todos os seres humanos nascem livres e iguais em dignidade e em direitos. Dotados de razão e de consciência, devem agir uns para com os outros em espírito de fraternidade. o a os as de do da dos das que em um uma para com não se por mais como mas foi ao ele ela são também você",
+		"ita": "Tutti gli esseri umani nascono liberi ed eguali in dignità e diritti. Essi sono dotati di ragione e di coscienza e devono agire gli uni verso gli altri in spirito di fratellanza. il lo la gli le di del della che è e un una per non con sono nel alla anche più questo come ma ci si",
+		"nld": "Alle mensen worden vrij en gelijk in waardigheid en rechten geboren. Zij zijn begiftigd met verstand en geweten, en behoren zich jegens elkander in een geest van broederschap te gedragen. de het een van en is dat niet op te zijn met voor er aan ook als maar om bij nog wel door",
+		"swe": "Alla människor är födda fria och lika i värde och rättigheter. De har utrustats med förnuft och samvete och bör handla gentemot varandra i en anda av broderskap. och att det som en på är av för med till den har de inte om ett han men var jag sig från vi så",
+		"dan": "Alle mennesker er født frie og lige i værdighed og rettigheder. De er udstyret med fornuft og samvittighed, og de bør handle mod hverandre i en broderskabets ånd. og i at det er en til på de med for af den ikke der som han var jeg har fra vi kan også efter",
+		"nor": "Alle mennesker er født frie og med samme menneskeverd og menneskerettigheter. De er utstyrt med fornuft og samvittighet og bør handle mot hverandre i brorskapets ånd. og i det som på er en til av for med at ikke de har jeg var om ble seg kan men fra også skal",
+		"fin": "Kaikki ihmiset syntyvät vapaina ja tasavertaisina arvoltaan ja oikeuksiltaan. Heille on annettu järki ja omatunto, ja heidän on toimittava toisiaan kohtaan veljeyden hengessä. ja on ei se että oli hän mutta kun niin myös ovat kuin jos tai sen joka vain olla minä",
+		"pol": "Wszyscy ludzie rodzą się wolni i równi pod względem swej godności i swych praw. Są oni obdarzeni rozumem i sumieniem i powinni postępować wobec innych w duchu braterstwa. i w nie na się jest to że do z jak co ale tak za od po jego jej już czy przez także",
+		"ces": "Všichni lidé rodí se svobodní a sobě rovní co do důstojnosti a práv. Jsou nadáni rozumem a svědomím a mají spolu jednat v duchu bratrství. a v se na je že to s z do o jako ale by jsem pro jeho které není tak již jsou také",
+		"tur": "Bütün insanlar hür, haysiyet ve haklar bakımından eşit doğarlar. Akıl ve vicdana sahiptirler ve birbirlerine karşı kardeşlik zihniyeti ile hareket etmelidirler. bir ve bu da de için ile çok ne daha gibi olan ama en kadar sonra değil var ben onun olarak",
+		"vie": "Tất cả mọi người sinh ra đều được tự do và bình đẳng về nhân phẩm và quyền lợi. Mọi con người đều được tạo hóa ban cho lý trí và lương tâm và cần phải đối xử với nhau trong tình bằng hữu. của và các có là không những được một người cho trong này với đã để khi cũng",
+		"ind": "Semua orang dilahirkan merdeka dan mempunyai martabat dan hak-hak yang sama. Mereka dikaruniai akal dan hati nurani dan hendaknya bergaul satu sama lain dalam semangat persaudaraan. yang dan di ini itu dengan untuk tidak dari dalam akan pada juga saya ke karena ada mereka atau bisa",
+		"hun": "Minden emberi lény szabadon születik és egyenlő méltósága és joga van. Az emberek, ésszel és lelkiismerettel bírván, egymással szemben testvéri szellemben kell hogy viseltessenek. a az és hogy nem is egy de meg ez csak már volt van mint még el ki vagy",
+		"ron": "Toate ființele umane se nasc libere și egale în demnitate și în drepturi. Ele sunt înzestrate cu rațiune și conștiință și trebuie să se comporte unele față de altele în spiritul fraternității. și în de la cu nu să pe o un ce se din care este mai dar pentru sunt fost",
+		"cat": "Tots els éssers humans neixen lliures i iguals en dignitat i en drets. Són dotats de raó i de consciència, i han de comportar-se fraternalment els uns amb els altres. el la els les de del i que un una per amb no es és al com més però seu també",
+		"tgl": "Ang lahat ng tao'y isinilang na malaya at pantay-pantay sa karangalan at mga karapatan. Sila'y pinagkalooban ng katwiran at budhi at dapat magpalagayan ang isa't isa sa diwa ng pagkakapatiran. ang ng sa na at mga ay hindi ko siya ito kung may para niya",
+		"swa": "Watu wote wamezaliwa huru, hadhi na haki zao ni sawa. Wote wamejaliwa akili na dhamiri, hivyo yapasa watendeane kindugu. na ya wa kwa ni la za katika kuwa hii huo lakini pia sana kama",
+	},
+	"Cyrillic": {
+		"rus": "Все люди рождаются свободными и равными в своем достоинстве и правах. Они наделены разумом и совестью и должны поступать в отношении друг друга в духе братства. и в не на я что он с как это по но из его к у за то все она так же от было",
+		"ukr": "Всі люди народжуються вільними і рівними у своїй гідності та правах. Вони наділені розумом і совістю і повинні діяти у відношенні один до одного в дусі братерства. і в не на що я з до як це та але він його її від був була для є також",
+		"bul": "Всички хора се раждат свободни и равни по достойнство и права. Те са надарени с разум и съвест и следва да се отнасят помежду си в дух на братство. и на да се в не е за от че с по това са като той тя но още",
+		"srp": "Сва људска бића рађају се слободна и једнака у достојанству и правима. Она су обдарена разумом и свешћу и треба једни према другима да поступају у духу братства. и у да је се на за не са од што као али који када још",
+		"bel": "Усе людзі нараджаюцца свабоднымі і роўнымі ў сваёй годнасці і правах. Яны надзелены розумам і сумленнем і павінны ставіцца адзін да аднаго ў духу брацтва. і ў не на што з да як гэта але ён яго яе быў была для",
+	},
+	"Arabic": {
+		"ara": "يولد جميع الناس أحرارًا متساوين في الكرامة والحقوق. وقد وهبوا عقلاً وضميرًا وعليهم أن يعامل بعضهم بعضًا بروح الإخاء. في من على أن إلى هذا التي الذي عن مع كان لا ما هو هي",
+		"fas": "تمام افراد بشر آزاد به دنیا می‌آیند و از لحاظ حیثیت و حقوق با هم برابرند. همه دارای عقل و وجدان می‌باشند و باید نسبت به یکدیگر با روح برادری رفتار کنند. و در به از که این را با است برای آن یک خود تا کرد شده بود",
+		"urd": "تمام انسان آزاد اور حقوق و عزت کے اعتبار سے برابر پیدا ہوئے ہیں۔ انہیں ضمیر اور عقل ودیعت ہوئی ہے۔ اس لئے انہیں ایک دوسرے کے ساتھ بھائی چارے کا سلوک کرنا چاہیئے۔ کے میں کی ہے اور کو سے یہ کہ نہیں تھا پر بھی",
+	},
+	"Devanagari": {
+		"hin": "सभी मनुष्यों को गौरव और अधिकारों के मामले में जन्मजात स्वतन्त्रता और समानता प्राप्त है। उन्हें बुद्धि और अन्तरात्मा की देन प्राप्त है और परस्पर उन्हें भाईचारे के भाव से बर्ताव करना चाहिए। के है में की और को से का यह एक पर भी नहीं था",
+		"mar": "सर्व मानवी व्यक्ति जन्मतःच स्वतंत्र आहेत व त्यांना समान प्रतिष्ठा व समान अधिकार आहेत. त्यांना विचारशक्ती व सदसद्विवेकबुद्धी लाभलेली आहे व त्यांनी एकमेकांशी बंधुत्वाच्या भावनेने आचरण करावे. आहे आणि या व ते की हे होते त्या मध्ये",
+		"nep": "सबै व्यक्तिहरू जन्मजात स्वतन्त्र हुन् ती सबैको समान अधिकार र महत्व छ। निजहरूमा विचार शक्ति र सद्विचार भएकोले निजहरूले आपसमा भ्रातृत्वको भावनाबाट व्यवहार गर्नु पर्छ। र छ को मा पनि भएको गरेको छन् थियो",
+	},
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"testing"
+	"unicode"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLang(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		text string
+		lang string
+	}{
+		{"The quick brown fox jumps over the lazy dog, and then it runs into the forest with the other animals.", "eng"},
+		{"Le renard brun rapide saute par-dessus le chien paresseux, puis il court dans la forêt avec les autres animaux.", "fra"},
+		{"Der schnelle braune Fuchs springt über den faulen Hund und läuft dann mit den anderen Tieren in den Wald.", "deu"},
+		{"El rápido zorro marrón salta sobre el perro perezoso y luego corre hacia el bosque con los otros animales.", "spa"},
+		{"Быстрая коричневая лиса прыгает через ленивую собаку, а потом убегает в лес вместе с другими животными.", "rus"},
+		{"敏捷的棕色狐狸跳过了那只懒狗，然后和其他动物一起跑进了森林。", "zho"},
+		{"素早い茶色の狐はのろまな犬を飛び越えて、ほかの動物たちと一緒に森へ走っていった。", "jpn"},
+		{"빠른 갈색 여우가 게으른 개를 뛰어넘고 다른 동물들과 함께 숲으로 달려갔다.", "kor"},
+	}
+
+	for _, c := range cases {
+		lang, confidence := DetectLang(c.text)
+		assert.Equal(c.lang, lang, c.text)
+		assert.True(confidence > 0.3, c.text)
+	}
+
+	lang, confidence := DetectLang("12345 !!!")
+	assert.Equal("", lang)
+	assert.Equal(float64(0), confidence)
+
+	// short text is ambiguous
+	_, confidence = DetectLang("no")
+	assert.True(confidence < 0.5)
+
+	// scripts shared by many languages
+	lang, confidence = DetectLang("כל בני האדם נולדו בני חורין ושווים בערכם ובזכויותיהם.")
+	assert.Equal("heb", lang)
+	assert.True(confidence <= maxAmbiguousConfidence)
+	_, confidence = DetectLang("The quick brown fox jumps over the lazy dog, and then it runs into the forest.")
+	assert.True(confidence <= maxAmbiguousConfidence)
+
+	// Chinese text with a few kana
+	lang, confidence = DetectLang("我们在东京的一家叫「すし」的餐厅吃了晚饭，然后回到了酒店休息。")
+	assert.Equal("zho", lang)
+	assert.True(confidence <= maxAmbiguousConfidence)
+	lang, confidence = DetectLang("敏捷的棕色狐狸跳过了那只懒狗，然后和其他动物一起跑进了森林。")
+	assert.Equal("zho", lang)
+	assert.True(confidence > maxAmbiguousConfidence)
+}
+
+func TestScriptLangs(t *testing.T) {
+	assert := assert.New(t)
+
+	// the script of a unique script language is not used by other languages
+	for _, l := range Languages {
+		script, count := "", 0
+		scripts := make(map[string]int)
+		for _, r := range l[3] {
+			for _, s := range langScripts {
+				if unicode.Is(s.table, r) {
+					scripts[s.name]++
+					if scripts[s.name] > count {
+						script, count = s.name, scripts[s.name]
+					}
+					break
+				}
+			}
+		}
+		if lang, ok := scriptLangs[script]; ok && l[1] != "jpn" {
+			assert.Equal(lang, l[1], l[2])
+		}
+	}
+}
@@ -9,21 +9,48 @@ import (
 
 var onceTK sync.Once
 var tk *tiktoken.Tiktoken
+var tks sync.Map // encoding name -> *tiktoken.Tiktoken
+
+const DefaultTokenizer = "cl100k_base"
 
 const MAX_CREATION_TOKENS = 64 * 1024
 const MAX_TOKENS = 128 * 1024
 
+// documents larger than MAX_TOKENS will be translated in chunks
+const MAX_CHUNK_TOKENS = 32 * 1024
+const MAX_DOCUMENT_TOKENS = 1024 * 1024
+
 func init() {
 	onceTK.Do(func() {
 		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
 		var err error
-		tk, err = tiktoken.GetEncoding("cl100k_base")
+		tk, err = tiktoken.GetEncoding(DefaultTokenizer)
 		if err != nil {
 			panic(err)
 		}
+		tks.Store(DefaultTokenizer, tk)
 	})
 }
 
 func Tiktokens(input string) uint32 {
 	return uint32(len(tk.Encode(input, nil, nil)))
 }
+
+// TiktokensWith counts tokens with the given encoding, the default encoding
+// will be used if the encoding is empty or unknown.
+func TiktokensWith(encoding, input string) uint32 {
+	if encoding == "" {
+		return Tiktokens(input)
+	}
+
+	if v, ok := tks.Load(encoding); ok {
+		return uint32(len(v.(*tiktoken.Tiktoken).Encode(input, nil, nil)))
+	}
+
+	enc, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return Tiktokens(input)
+	}
+	tks.Store(encoding, enc)
+	return uint32(len(enc.Encode(input, nil, nil)))
+}